@@ -0,0 +1,100 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	aclScopeTypeUser = "user"
+	aclRoleNone      = "none"
+)
+
+// accessRoleRanks orders the access roles from the lowest to the highest.
+// No access ranks below all of them.
+var accessRoleRanks = map[string]int{
+	AccessRoleFreeBusyReader: 1,
+	AccessRoleReader:         2,
+	AccessRoleWriter:         3,
+	AccessRoleOwner:          4,
+}
+
+// maxAccessRole returns the highest of the roles, or an empty string when
+// none of them gives access
+func maxAccessRole(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		if accessRoleRanks[role] > accessRoleRanks[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
+// ShareCalendar grants a role on a calendar to a Google account. Google
+// emails the grantee a link to add the calendar to their list.
+func (c *client) ShareCalendar(calendarID, email, role string) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal ShareCalendar, error creating service")
+	}
+
+	rule := &calendar.AclRule{
+		Role: role,
+		Scope: &calendar.AclRuleScope{
+			Type:  aclScopeTypeUser,
+			Value: email,
+		},
+	}
+
+	_, err = service.Acl.Insert(calendarID, rule).Do()
+	if err != nil {
+		return errors.Wrap(err, "gcal ShareCalendar, error inserting access rule")
+	}
+
+	return nil
+}
+
+// GetCalendarAccess returns the role a Google account has on a calendar
+// through its own access rule, or an empty string when it has none
+func (c *client) GetCalendarAccess(calendarID, email string) (string, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return "", errors.Wrap(err, "gcal GetCalendarAccess, error creating service")
+	}
+
+	rule, err := service.Acl.Get(calendarID, aclScopeTypeUser+":"+email).Do()
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "gcal GetCalendarAccess, error getting access rule")
+	}
+	if rule.Role == aclRoleNone {
+		return "", nil
+	}
+
+	return rule.Role, nil
+}
+
+// UnshareCalendar removes the access of a Google account to a calendar. It is
+// not an error if the account had no access.
+func (c *client) UnshareCalendar(calendarID, email string) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal UnshareCalendar, error creating service")
+	}
+
+	err = service.Acl.Delete(calendarID, aclScopeTypeUser+":"+email).Do()
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "gcal UnshareCalendar, error deleting access rule")
+	}
+
+	return nil
+}
@@ -5,6 +5,10 @@ package gcal
 
 import (
 	"context"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
@@ -13,19 +17,245 @@ import (
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
-// CreateCalendar creates a calendar
+const (
+	AccessRoleOwner          = "owner"
+	AccessRoleWriter         = "writer"
+	AccessRoleReader         = "reader"
+	AccessRoleFreeBusyReader = "freeBusyReader"
+
+	// calendarListMaxAge is how long the cached calendar list is used, the
+	// calendar list notifications of subscribed users refresh it sooner
+	calendarListMaxAge = 15 * time.Minute
+)
+
+// Calendar is an entry of the user's calendar list. remote.Calendar only
+// carries the ID and name, so the rest of the Google metadata lives here.
+type Calendar struct {
+	*remote.Calendar
+
+	Description     string
+	TimeZone        string
+	ColorID         string
+	BackgroundColor string
+	ForegroundColor string
+	AccessRole      string
+	Primary         bool
+	Hidden          bool
+	Selected        bool
+
+	// DefaultReminders are the minutes before start the events of the
+	// calendar remind of themselves, unless they have their own reminders
+	DefaultReminders []int
+}
+
+// CanWrite reports whether the user is allowed to create events on the calendar
+func (cal *Calendar) CanWrite() bool {
+	return cal.AccessRole == AccessRoleOwner || cal.AccessRole == AccessRoleWriter
+}
+
+// CreateCalendar creates a secondary calendar owned by the user, in the user's timezone
 func (c *client) CreateCalendar(remoteUserID string, calIn *remote.Calendar) (*remote.Calendar, error) {
-	return nil, errors.New("gcal CreateCalendar not implemented")
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateCalendar, error creating service")
+	}
+
+	googleCal := &calendar.Calendar{
+		Summary: calIn.Name,
+	}
+
+	// Google falls back to UTC when no timezone is given
+	settings, err := c.GetMailboxSettings(remoteUserID)
+	if err == nil {
+		googleCal.TimeZone = settings.TimeZone
+	}
+
+	googleCal, err = service.Calendars.Insert(googleCal).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateCalendar, error creating calendar")
+	}
+	c.forgetCalendarList()
+
+	return convertGoogleCalendarToRemoteCalendar(googleCal), nil
 }
 
-// DeleteCalendar deletes a calendar
+// DeleteCalendar deletes a secondary calendar owned by the user, with all its events
 func (c *client) DeleteCalendar(remoteUserID string, calID string) error {
-	return errors.New("gcal DeleteCalendar not implemented")
+	if calID == defaultCalendarName {
+		return errors.New("gcal DeleteCalendar, the primary calendar cannot be deleted")
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal DeleteCalendar, error creating service")
+	}
+
+	err = service.Calendars.Delete(calID).Do()
+	if err != nil {
+		return errors.Wrap(err, "gcal DeleteCalendar, error deleting calendar")
+	}
+	c.forgetCalendarList()
+
+	return nil
+}
+
+// AddCalendar adds an existing calendar, such as a public holiday calendar,
+// to the user's calendar list. The calendar is given by its ID or by one of
+// the URLs Google Calendar shares calendars with.
+func (c *client) AddCalendar(idOrURL string) (*Calendar, error) {
+	calendarID := parseCalendarID(idOrURL)
+	if calendarID == "" {
+		return nil, errors.New("gcal AddCalendar, no calendar ID found")
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal AddCalendar, error creating service")
+	}
+
+	entry, err := service.CalendarList.Insert(&calendar.CalendarListEntry{Id: calendarID}).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.Errorf("calendar %s does not exist or is not public", calendarID)
+		}
+		return nil, errors.Wrap(err, "gcal AddCalendar, error inserting calendar list entry")
+	}
+	c.forgetCalendarList()
+
+	return convertGoogleCalendarListEntryToCalendar(entry), nil
+}
+
+// parseCalendarID returns the calendar ID of a calendar URL, which comes as
+// an embed link (?src=ID), a subscription link (?cid=base64 ID) or an iCal
+// address (/calendar/ical/ID/...). Anything else is taken as an ID.
+func parseCalendarID(idOrURL string) string {
+	idOrURL = strings.TrimSpace(idOrURL)
+	if !strings.HasPrefix(idOrURL, "http://") && !strings.HasPrefix(idOrURL, "https://") {
+		return idOrURL
+	}
+
+	u, err := url.Parse(idOrURL)
+	if err != nil {
+		return ""
+	}
+
+	query := u.Query()
+	if src := query.Get("src"); src != "" {
+		return src
+	}
+	if cid := query.Get("cid"); cid != "" {
+		for _, encoding := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+			decoded, err := encoding.DecodeString(strings.TrimRight(cid, "="))
+			if err == nil {
+				return string(decoded)
+			}
+		}
+		return cid
+	}
+
+	parts := strings.Split(u.EscapedPath(), "/")
+	for i, part := range parts {
+		if part == "ical" && i+1 < len(parts) {
+			id, err := url.PathUnescape(parts[i+1])
+			if err != nil {
+				return ""
+			}
+			return id
+		}
+	}
+
+	return ""
 }
 
 // GetCalendars returns a list of calendars
-func (c *client) GetCalendars(remoteUserID string) ([]*remote.Calendar, error) {
-	return nil, errors.New("gcal GetCalendars not implemented")
+func (c *client) GetCalendars(_ string) ([]*remote.Calendar, error) {
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*remote.Calendar, 0, len(calendars))
+	for _, cal := range calendars {
+		out = append(out, cal.Calendar)
+	}
+
+	return out, nil
+}
+
+// ListCalendars pages through the user's calendar list, including hidden
+// calendars, and returns every entry along with its Google specific metadata.
+// The entries are cached for SelectedCalendars.
+func (c *client) ListCalendars() ([]*Calendar, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal ListCalendars, error creating service")
+	}
+
+	calendars := []*Calendar{}
+	err = service.CalendarList.
+		List().
+		ShowHidden(true).
+		Pages(ctx, func(page *calendar.CalendarList) error {
+			for _, entry := range page.Items {
+				calendars = append(calendars, convertGoogleCalendarListEntryToCalendar(entry))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal ListCalendars, error listing calendars")
+	}
+
+	if c.store != nil && c.mattermostUserID != "" {
+		err = c.store.StoreCalendarList(c.mattermostUserID, &CachedCalendarList{
+			RefreshedAt: time.Now(),
+			Calendars:   calendars,
+		})
+		if err != nil {
+			c.Logger.Warnf("gcal: failed to cache calendar list. err=%v", err)
+		}
+	}
+
+	return calendars, nil
+}
+
+// cachedCalendars returns the user's calendar list as cached by the last
+// ListCalendars, unless it is older than calendarListMaxAge
+func (c *client) cachedCalendars() ([]*Calendar, error) {
+	if c.store != nil && c.mattermostUserID != "" {
+		list, err := c.store.LoadCalendarList(c.mattermostUserID)
+		if err == nil && time.Since(list.RefreshedAt) < calendarListMaxAge {
+			return list.Calendars, nil
+		}
+	}
+	return c.ListCalendars()
+}
+
+// forgetCalendarList drops the cached calendar list after the plugin changed
+// it, for the next read to see the change
+func (c *client) forgetCalendarList() {
+	if c.store == nil || c.mattermostUserID == "" {
+		return
+	}
+	if err := c.store.DeleteCalendarList(c.mattermostUserID); err != nil {
+		c.Logger.Warnf("gcal: failed to delete cached calendar list. err=%v", err)
+	}
+}
+
+// GetEventColors returns the palette of event colors by color ID. Events only
+// carry the ID of their color, the palette is the same for all events of a user.
+func (c *client) GetEventColors() (map[string]calendar.ColorDefinition, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventColors, error creating service")
+	}
+
+	colors, err := service.Colors.Get().Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventColors, error getting colors")
+	}
+
+	return colors.Event, nil
 }
 
 // GetDefaultCalendar returns the default calendar for the user
@@ -46,6 +276,146 @@ func (c *client) GetDefaultCalendar() (*remote.Calendar, error) {
 	return remoteCal, nil
 }
 
+// SelectedCalendars returns the calendars the user included in their event
+// views, or only the primary calendar when the user made no choice yet.
+// Selected calendars that are no longer in the calendar list are skipped.
+// The calendar list is read from the cache, see ListCalendars.
+func (c *client) SelectedCalendars() ([]*Calendar, error) {
+	settings, err := c.loadUserSettings()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal SelectedCalendars, error loading user settings")
+	}
+
+	calendars, err := c.cachedCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterSelectedCalendars(calendars, settings.SelectedCalendarIDs), nil
+}
+
+// SelectCalendar includes a calendar of the user's calendar list in their
+// event views, and watches it when the user is subscribed to notifications
+func (c *client) SelectCalendar(calendarID string) error {
+	if c.store == nil {
+		return errors.New("gcal SelectCalendar, calendar selection is not available")
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return err
+	}
+
+	cal := findCalendar(calendars, calendarID)
+	if cal == nil {
+		return errors.Errorf("calendar %s is not in your calendar list", calendarID)
+	}
+
+	primaryID := ""
+	if primary := findCalendar(calendars, defaultCalendarName); primary != nil {
+		primaryID = primary.ID
+	}
+
+	settings, err := c.loadUserSettings()
+	if err != nil {
+		return errors.Wrap(err, "gcal SelectCalendar, error loading user settings")
+	}
+
+	settings.SelectCalendar(cal.ID, primaryID)
+	err = c.store.StoreUserSettings(c.mattermostUserID, settings)
+	if err != nil {
+		return errors.Wrap(err, "gcal SelectCalendar, error storing user settings")
+	}
+
+	return c.SyncCalendarWatches()
+}
+
+// GetWritableCalendar returns the calendar list entry of a calendar the user
+// can create events on. The "primary" alias matches the primary calendar.
+func (c *client) GetWritableCalendar(calendarID string) (*Calendar, error) {
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	cal := findCalendar(calendars, calendarID)
+	if cal == nil {
+		return nil, errors.Errorf("calendar %s is not in your calendar list", calendarID)
+	}
+	if !cal.CanWrite() {
+		return nil, errors.Errorf("you are not allowed to create events on calendar %s", cal.Name)
+	}
+
+	return cal, nil
+}
+
+// DefaultEventCalendarID returns the calendar new events are created on,
+// which is the user's preference or the primary calendar
+func (c *client) DefaultEventCalendarID() (string, error) {
+	settings, err := c.loadUserSettings()
+	if err != nil {
+		return "", errors.Wrap(err, "gcal DefaultEventCalendarID, error loading user settings")
+	}
+
+	if settings.DefaultCalendarID == "" {
+		return defaultCalendarName, nil
+	}
+	return settings.DefaultCalendarID, nil
+}
+
+func findCalendar(calendars []*Calendar, calendarID string) *Calendar {
+	for _, cal := range calendars {
+		if cal.ID == calendarID || (calendarID == defaultCalendarName && cal.Primary) {
+			return cal
+		}
+	}
+	return nil
+}
+
+func filterSelectedCalendars(calendars []*Calendar, selectedIDs []string) []*Calendar {
+	selected := map[string]bool{}
+	for _, id := range selectedIDs {
+		selected[id] = true
+	}
+
+	out := []*Calendar{}
+	for _, cal := range calendars {
+		if selected[cal.ID] || (len(selected) == 0 && cal.Primary) {
+			out = append(out, cal)
+		}
+	}
+	return out
+}
+
+// convertGoogleCalendarListEntryToCalendar converts a calendar list entry to a local representation calendar
+func convertGoogleCalendarListEntryToCalendar(entry *calendar.CalendarListEntry) *Calendar {
+	name := entry.Summary
+	if entry.SummaryOverride != "" {
+		name = entry.SummaryOverride
+	}
+
+	return &Calendar{
+		Calendar: &remote.Calendar{
+			ID:           entry.Id,
+			Name:         name,
+			Events:       []remote.Event{},
+			CalendarView: []remote.Event{},
+			Owner:        nil,
+		},
+		Description:     entry.Description,
+		TimeZone:        entry.TimeZone,
+		ColorID:         entry.ColorId,
+		BackgroundColor: entry.BackgroundColor,
+		ForegroundColor: entry.ForegroundColor,
+		AccessRole:      entry.AccessRole,
+		Primary:         entry.Primary,
+		Hidden:          entry.Hidden,
+		Selected:        entry.Selected,
+
+		DefaultReminders: popupReminderMinutes(entry.DefaultReminders),
+	}
+}
+
 // convertGoogleCalendarToRemoteCalendar converts a google calendar to a local representation calendar
 func convertGoogleCalendarToRemoteCalendar(cal *calendar.Calendar) *remote.Calendar {
 	return &remote.Calendar{
@@ -0,0 +1,271 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
+)
+
+const (
+	PathCalendarListWebhook   = "/api/v1/calendars/list/webhook"
+	PathIncludeCalendarAction = "/api/v1/calendars/actions/include"
+)
+
+// CalendarListWatcher follows the calendar lists of subscribed users. New
+// calendars are offered for inclusion in the user's event views, and removed
+// calendars are dropped from the selection, which stops watching them.
+type CalendarListWatcher struct {
+	Env   engine.Env
+	Store Store
+	API   plugin.API
+
+	// makeClient is replaced in tests
+	makeClient func(mattermostUserID string) (*client, error)
+}
+
+// NewCalendarListWatcher creates a new calendar list watcher
+func NewCalendarListWatcher(env engine.Env, store Store, api plugin.API) *CalendarListWatcher {
+	return &CalendarListWatcher{
+		Env:   env,
+		Store: store,
+		API:   api,
+
+		makeClient: userClientMaker(env),
+	}
+}
+
+// HandleWebhook handles the Google push notifications of calendar lists
+func (w *CalendarListWatcher) HandleWebhook(rw http.ResponseWriter, r *http.Request) {
+	wh, isSync := parseWebhook(r)
+	if isSync {
+		rw.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	mattermostUserID := mattermostUserIDFromCalendarListWatchID(wh.SubscriptionID)
+	watches, err := w.Store.LoadCalendarWatches(mattermostUserID)
+	if err != nil || watches.CalendarListWatch == nil || watches.CalendarListWatch.ID != wh.SubscriptionID || watches.CalendarListWatch.Token != wh.ClientState {
+		// Google stops retrying once the channel is unknown
+		http.Error(rw, "Unknown channel", http.StatusNotFound)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+
+	go w.syncCalendarList(mattermostUserID)
+}
+
+// syncCalendarList compares the calendar list of a user with the one known at
+// the previous notification
+func (w *CalendarListWatcher) syncCalendarList(mattermostUserID string) {
+	logger := w.Env.Logger.With(bot.LogContext{"mattermostUserID": mattermostUserID})
+
+	unlock, err := lockCluster(w.API, "gcal_calendar_list_lock_"+mattermostUserID)
+	if err != nil {
+		logger.Errorf("gcal: failed to lock calendar list. err=%v", err)
+		return
+	}
+	defer unlock()
+
+	c, err := w.makeClient(mattermostUserID)
+	if err != nil {
+		logger.Warnf("gcal: failed to make client for calendar list. err=%v", err)
+		return
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		logger.Warnf("gcal: failed to list calendars. err=%v", err)
+		return
+	}
+
+	added, removed, err := w.updateKnownCalendars(mattermostUserID, calendars)
+	if err != nil {
+		logger.Warnf("gcal: failed to update known calendars. err=%v", err)
+		return
+	}
+
+	if len(removed) > 0 {
+		err = w.removeCalendars(c, mattermostUserID, removed)
+		if err != nil {
+			logger.Warnf("gcal: failed to remove calendars from selection. err=%v", err)
+		}
+	}
+
+	// Calendars added from Mattermost are already selected
+	settings, err := w.Store.LoadUserSettings(mattermostUserID)
+	if err != nil {
+		logger.Warnf("gcal: failed to load user settings. err=%v", err)
+		return
+	}
+	selected := map[string]bool{}
+	for _, id := range settings.SelectedCalendarIDs {
+		selected[id] = true
+	}
+
+	for _, cal := range added {
+		if !selected[cal.ID] {
+			w.offerCalendar(mattermostUserID, cal)
+		}
+	}
+}
+
+// updateKnownCalendars stores the calendar list of a user as the known one,
+// returning the calendars added and removed since the previous one
+func (w *CalendarListWatcher) updateKnownCalendars(mattermostUserID string, calendars []*Calendar) ([]*Calendar, map[string]bool, error) {
+	unlock, err := w.Store.LockCalendarWatches(mattermostUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	watches, err := w.Store.LoadCalendarWatches(mattermostUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := map[string]bool{}
+	for _, id := range watches.KnownCalendarIDs {
+		known[id] = true
+	}
+
+	added := []*Calendar{}
+	watches.KnownCalendarIDs = []string{}
+	for _, cal := range calendars {
+		if !known[cal.ID] {
+			added = append(added, cal)
+		}
+		delete(known, cal.ID)
+		watches.KnownCalendarIDs = append(watches.KnownCalendarIDs, cal.ID)
+	}
+
+	err = w.Store.StoreCalendarWatches(mattermostUserID, watches)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// What is left in known was removed from the calendar list
+	return added, known, nil
+}
+
+func (w *CalendarListWatcher) removeCalendars(c *client, mattermostUserID string, removed map[string]bool) error {
+	settings, err := w.Store.LoadUserSettings(mattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for calendarID := range removed {
+		if settings.RemoveCalendar(calendarID) {
+			changed = true
+		}
+	}
+	if changed {
+		err = w.Store.StoreUserSettings(mattermostUserID, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.SyncCalendarWatches()
+}
+
+// offerCalendar asks the user whether to include a new calendar in their
+// event views, the answer is handled by HandleIncludeCalendarAction
+func (w *CalendarListWatcher) offerCalendar(mattermostUserID string, cal *Calendar) {
+	actionURL := w.Env.Config.PluginURLPath + PathIncludeCalendarAction
+	actionContext := map[string]any{
+		"calendar_id":   cal.ID,
+		"calendar_name": cal.Name,
+	}
+
+	_, err := w.Env.Poster.DMWithAttachments(mattermostUserID, &model.SlackAttachment{
+		Title: "New calendar: " + cal.Name,
+		Text:  "This calendar was added to your Google calendar list. Do you want to see its events and get notified about them in Mattermost?",
+		Actions: []*model.PostAction{
+			{
+				Name: "Include",
+				Type: model.PostActionTypeButton,
+				Integration: &model.PostActionIntegration{
+					URL:     actionURL,
+					Context: withAction(actionContext, actionConfirm),
+				},
+			},
+			{
+				Name: "Ignore",
+				Type: model.PostActionTypeButton,
+				Integration: &model.PostActionIntegration{
+					URL:     actionURL,
+					Context: withAction(actionContext, actionCancel),
+				},
+			},
+		},
+	})
+	if err != nil {
+		w.Env.Logger.With(bot.LogContext{
+			"mattermostUserID": mattermostUserID,
+			"calendarID":       cal.ID,
+		}).Warnf("gcal: failed to offer new calendar. err=%v", err)
+	}
+}
+
+// HandleIncludeCalendarAction handles the buttons of the new calendar offer
+func (w *CalendarListWatcher) HandleIncludeCalendarAction(rw http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if mattermostUserID == "" || req.UserId != mattermostUserID {
+		http.Error(rw, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	action, _ := req.Context["action"].(string)
+	calendarID, _ := req.Context["calendar_id"].(string)
+	calendarName, _ := req.Context["calendar_name"].(string)
+
+	message := fmt.Sprintf("Calendar **%s** is not included in your calendar views.", calendarName)
+	if action == actionConfirm {
+		message = fmt.Sprintf("Calendar **%s** is now included in your calendar views.", calendarName)
+		if err := w.includeCalendar(mattermostUserID, calendarID); err != nil {
+			message = fmt.Sprintf("Failed to include calendar **%s**: %s", calendarName, err.Error())
+		}
+	}
+
+	httputils.WriteJSONResponse(rw, &model.PostActionIntegrationResponse{
+		Update: &model.Post{
+			Id:      req.PostId,
+			Message: message,
+		},
+	}, http.StatusOK)
+}
+
+func (w *CalendarListWatcher) includeCalendar(mattermostUserID, calendarID string) error {
+	c, err := w.makeClient(mattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	return c.SelectCalendar(calendarID)
+}
+
+func withAction(context map[string]any, action string) map[string]any {
+	out := map[string]any{"action": action}
+	for k, v := range context {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestCalendarListWatcherSync(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Summary: "Me", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "new", Summary: "New", AccessRole: AccessRoleReader},
+	)
+	g.watches["watch-old"] = "old"
+
+	api := newTestAPI(t)
+	poster := &testPoster{}
+	w := NewCalendarListWatcher(newTestEnv(poster), NewStore(api), api)
+	w.makeClient = g.makeClient(api)
+
+	err := w.Store.StoreCalendarWatches("user1", &CalendarWatches{
+		SubscriptionID:   "sub1",
+		Watches:          map[string]*WatchChannel{"old": {ID: "watch-old", CalendarID: "old"}},
+		LastSyncTimes:    map[string]time.Time{"old": time.Now()},
+		KnownCalendarIDs: []string{"me@example.com", "old"},
+	})
+	require.NoError(t, err)
+	err = w.Store.StoreUserSettings("user1", &UserSettings{
+		SelectedCalendarIDs: []string{"me@example.com", "old"},
+		DefaultCalendarID:   "old",
+	})
+	require.NoError(t, err)
+
+	w.syncCalendarList("user1")
+
+	watches, err := w.Store.LoadCalendarWatches("user1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"me@example.com", "new"}, watches.KnownCalendarIDs)
+	require.Empty(t, watches.Watches, "the removed calendar is no longer watched")
+	require.Equal(t, []string{"watch-old"}, g.stopped)
+
+	settings, err := w.Store.LoadUserSettings("user1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"me@example.com"}, settings.SelectedCalendarIDs)
+	require.Empty(t, settings.DefaultCalendarID)
+
+	require.Len(t, poster.dms["user1"], 1)
+	require.Equal(t, "New calendar: New", poster.dms["user1"][0].Title)
+
+	t.Run("including the new calendar watches it", func(t *testing.T) {
+		err := w.includeCalendar("user1", "new")
+		require.NoError(t, err)
+
+		settings, err := w.Store.LoadUserSettings("user1")
+		require.NoError(t, err)
+		require.Equal(t, []string{"me@example.com", "new"}, settings.SelectedCalendarIDs)
+
+		watches, err := w.Store.LoadCalendarWatches("user1")
+		require.NoError(t, err)
+		require.Contains(t, watches.Watches, "new")
+		require.Equal(t, "new", g.watched(watches.Watches["new"].ID))
+	})
+}
@@ -0,0 +1,648 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const membersPerPage = 200
+
+var shareRoles = map[string]bool{
+	AccessRoleFreeBusyReader: true,
+	AccessRoleReader:         true,
+	AccessRoleWriter:         true,
+}
+
+// CalendarSharing shares Google calendars with the members of Mattermost
+// channels and groups. Only members who connected their Google account can
+// be granted access, using the email stored when they connected.
+type CalendarSharing struct {
+	Env   engine.Env
+	Store Store
+	API   plugin.API
+
+	// makeClient and googleEmail reach the user's Google account and
+	// connection, tests replace them
+	makeClient  func(mattermostUserID string) (*client, error)
+	googleEmail func(mattermostUserID string) string
+}
+
+// NewCalendarSharing creates a new calendar sharing handler
+func NewCalendarSharing(env engine.Env, store Store, api plugin.API) *CalendarSharing {
+	cs := &CalendarSharing{
+		Env:   env,
+		Store: store,
+		API:   api,
+
+		makeClient: userClientMaker(env),
+	}
+	cs.googleEmail = cs.getGoogleEmail
+	return cs
+}
+
+// ResolveTarget returns the channel or group a calendar is shared with. The
+// target is a ~channel of the team, an @group, or the current channel when
+// empty. Users only see the channels they are a member of, and the groups
+// they are in or can mention, so that their shares are not disclosed.
+func (cs *CalendarSharing) ResolveTarget(mattermostUserID, teamID, currentChannelID, target string) (channelID, groupID string, err error) {
+	switch {
+	case target == "":
+		if _, appErr := cs.API.GetChannelMember(currentChannelID, mattermostUserID); appErr != nil {
+			return "", "", errors.New("you can only manage the calendars shared with channels you are a member of")
+		}
+		return currentChannelID, "", nil
+	case strings.HasPrefix(target, "~"):
+		channel, appErr := cs.API.GetChannelByName(teamID, strings.TrimPrefix(target, "~"), false)
+		if appErr != nil {
+			return "", "", errors.Errorf("channel %s not found", target)
+		}
+		if _, appErr = cs.API.GetChannelMember(channel.Id, mattermostUserID); appErr != nil {
+			return "", "", errors.Errorf("channel %s not found", target)
+		}
+		return channel.Id, "", nil
+	case strings.HasPrefix(target, "@"):
+		group, appErr := cs.API.GetGroupByName(strings.TrimPrefix(target, "@"))
+		if appErr != nil || !cs.canSeeGroup(mattermostUserID, group) {
+			return "", "", errors.Errorf("group %s not found", target)
+		}
+		return "", group.Id, nil
+	}
+	return "", "", errors.Errorf("%s is neither a ~channel nor an @group", target)
+}
+
+// canSeeGroup reports whether a group can be mentioned by everyone, or the
+// user is one of its members
+func (cs *CalendarSharing) canSeeGroup(mattermostUserID string, group *model.Group) bool {
+	if group.AllowReference {
+		return true
+	}
+
+	groups, appErr := cs.API.GetGroupsForUser(mattermostUserID)
+	if appErr != nil {
+		return false
+	}
+	for _, userGroup := range groups {
+		if userGroup.Id == group.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// Share shares a calendar the user owns with a channel or group, and grants
+// the role to the members. Sharing a calendar again changes the role. The
+// target must come from ResolveTarget, which checks the user can see it.
+func (cs *CalendarSharing) Share(mattermostUserID, nameOrID, role, channelID, groupID string) (*CalendarShare, error) {
+	if !shareRoles[role] {
+		return nil, errors.Errorf("role must be one of %s, %s or %s", AccessRoleFreeBusyReader, AccessRoleReader, AccessRoleWriter)
+	}
+
+	c, cal, err := cs.getOwnedCalendar(mattermostUserID, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	share := &CalendarShare{
+		CalendarID:   cal.ID,
+		CalendarName: cal.Name,
+		Role:         role,
+		ChannelID:    channelID,
+		GroupID:      groupID,
+		SharedBy:     mattermostUserID,
+		Members:      map[string]string{},
+	}
+
+	unlock, err := cs.lockTarget(share.TargetID())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	shares, err := cs.Store.LoadCalendarShares(share.TargetID())
+	if err != nil {
+		return nil, err
+	}
+
+	oldRole := ""
+	found := false
+	for i, existing := range shares {
+		if existing.CalendarID == cal.ID {
+			if existing.Role != role {
+				oldRole = existing.Role
+			}
+			share.Members = existing.Members
+			share.PriorRoles = existing.PriorRoles
+			shares[i] = share
+			found = true
+			break
+		}
+	}
+	if !found {
+		// Indexed before the access is granted, so other shares of the
+		// calendar always see this one
+		err = cs.Store.AddCalendarShareIndex(cal.ID, share.TargetID())
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	syncErr := cs.syncShare(c, share, oldRole)
+
+	err = cs.Store.StoreCalendarShares(share.TargetID(), shares)
+	if err != nil {
+		return nil, err
+	}
+	if syncErr != nil {
+		return nil, syncErr
+	}
+
+	return share, nil
+}
+
+// Unshare stops sharing a calendar the user owns with a channel or group, and
+// removes the access granted to its members
+func (cs *CalendarSharing) Unshare(mattermostUserID, nameOrID, channelID, groupID string) (*CalendarShare, error) {
+	c, cal, err := cs.getOwnedCalendar(mattermostUserID, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	targetID := channelID
+	if groupID != "" {
+		targetID = groupID
+	}
+
+	unlock, err := cs.lockTarget(targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	shares, err := cs.Store.LoadCalendarShares(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed *CalendarShare
+	remaining := []*CalendarShare{}
+	for _, share := range shares {
+		if share.CalendarID == cal.ID {
+			removed = share
+			continue
+		}
+		remaining = append(remaining, share)
+	}
+	if removed == nil {
+		return nil, ErrNotFound
+	}
+
+	others, err := cs.otherShares(removed)
+	if err != nil {
+		return nil, err
+	}
+
+	for mattermostMemberID := range removed.Members {
+		if err = cs.revokeMember(c, removed, others, mattermostMemberID); err != nil {
+			// Keep the share with the members left, for unsharing again
+			if storeErr := cs.Store.StoreCalendarShares(targetID, shares); storeErr != nil {
+				return nil, storeErr
+			}
+			return nil, err
+		}
+	}
+
+	err = cs.Store.StoreCalendarShares(targetID, remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cs.Store.RemoveCalendarShareIndex(cal.ID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// ListShares returns the calendars shared with a channel or group
+func (cs *CalendarSharing) ListShares(targetID string) ([]*CalendarShare, error) {
+	return cs.Store.LoadCalendarShares(targetID)
+}
+
+// HandleChannelMembershipChange grants or removes the access of a user to the
+// calendars shared with a channel they joined or left
+func (cs *CalendarSharing) HandleChannelMembershipChange(channelID, mattermostUserID string, isMember bool) {
+	logger := cs.Env.Logger.With(bot.LogContext{
+		"channelID":        channelID,
+		"mattermostUserID": mattermostUserID,
+	})
+
+	unlock, err := cs.lockTarget(channelID)
+	if err != nil {
+		logger.Errorf("gcal: failed to lock calendar shares. err=%v", err)
+		return
+	}
+	defer unlock()
+
+	shares, err := cs.Store.LoadCalendarShares(channelID)
+	if err != nil || len(shares) == 0 {
+		return
+	}
+
+	email := ""
+	if isMember {
+		email = cs.googleEmail(mattermostUserID)
+	}
+
+	for _, share := range shares {
+		if share.SharedBy == mattermostUserID {
+			continue
+		}
+
+		c, err := cs.makeClient(share.SharedBy)
+		if err != nil {
+			logger.Warnf("gcal: failed to make client of calendar owner. err=%v", err)
+			continue
+		}
+
+		others, err := cs.otherShares(share)
+		if err != nil {
+			logger.Warnf("gcal: failed to load calendar shares. err=%v", err)
+			continue
+		}
+
+		err = cs.syncMember(c, share, others, mattermostUserID, email, "")
+		if err != nil {
+			logger.With(bot.LogContext{"calendarID": share.CalendarID}).Warnf("gcal: failed to update calendar share. err=%v", err)
+		}
+	}
+
+	err = cs.Store.StoreCalendarShares(channelID, shares)
+	if err != nil {
+		logger.Warnf("gcal: failed to store calendar shares. err=%v", err)
+	}
+}
+
+// ResyncAll brings the access rules of all shared calendars in line with the
+// channel and group memberships. It catches group changes, which have no
+// hook, and members who connected their Google account after the share.
+func (cs *CalendarSharing) ResyncAll() {
+	targetIDs, err := cs.Store.ListCalendarShareTargets()
+	if err != nil {
+		cs.Env.Logger.Warnf("gcal: failed to list calendar shares. err=%v", err)
+		return
+	}
+
+	for _, targetID := range targetIDs {
+		err = cs.resyncTarget(targetID)
+		if err != nil {
+			cs.Env.Logger.With(bot.LogContext{
+				"targetID": targetID,
+			}).Warnf("gcal: failed to resync calendar shares. err=%v", err)
+		}
+	}
+}
+
+func (cs *CalendarSharing) resyncTarget(targetID string) error {
+	unlock, err := cs.lockTarget(targetID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	shares, err := cs.Store.LoadCalendarShares(targetID)
+	if err != nil {
+		return err
+	}
+
+	// A share that fails to sync keeps the members it was synced for, so the
+	// shares are stored whatever the errors
+	var lastErr error
+	for _, share := range shares {
+		c, err := cs.makeClient(share.SharedBy)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		err = cs.syncShare(c, share, "")
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	err = cs.Store.StoreCalendarShares(targetID, shares)
+	if err != nil {
+		return err
+	}
+
+	return lastErr
+}
+
+// syncShare grants the role to all the connected members of the share target,
+// and removes the access of those who are no longer members. With oldRole,
+// the role the share had before, existing members are granted the new role.
+// A member who fails is skipped, and the share keeps the state of the others.
+func (cs *CalendarSharing) syncShare(c *client, share *CalendarShare, oldRole string) error {
+	memberIDs, err := cs.getTargetMemberIDs(share)
+	if err != nil {
+		return err
+	}
+
+	others, err := cs.otherShares(share)
+	if err != nil {
+		return err
+	}
+
+	if share.Members == nil {
+		share.Members = map[string]string{}
+	}
+
+	var lastErr error
+	current := map[string]bool{}
+	for _, mattermostUserID := range memberIDs {
+		if mattermostUserID == share.SharedBy {
+			continue
+		}
+		current[mattermostUserID] = true
+
+		email := cs.googleEmail(mattermostUserID)
+		err = cs.syncMember(c, share, others, mattermostUserID, email, oldRole)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	for mattermostUserID := range share.Members {
+		if current[mattermostUserID] {
+			continue
+		}
+		err = cs.revokeMember(c, share, others, mattermostUserID)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// syncMember grants the share role to a member's Google email, or removes
+// their access when email is empty. An email that changed since the member
+// was granted access is replaced.
+func (cs *CalendarSharing) syncMember(c *client, share *CalendarShare, others []*CalendarShare, mattermostUserID, email, oldRole string) error {
+	granted, isGranted := share.Members[mattermostUserID]
+	if isGranted && granted == email && oldRole == "" {
+		return nil
+	}
+
+	if isGranted && granted != email {
+		err := cs.revokeMember(c, share, others, mattermostUserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if email == "" {
+		return nil
+	}
+
+	return cs.grantMember(c, share, others, mattermostUserID, email, oldRole)
+}
+
+// grantMember gives a member's Google email at least the share role. An
+// access rule is never lowered, except from oldRole, the role the share
+// granted before, when the rule still has the role the plugin set.
+func (cs *CalendarSharing) grantMember(c *client, share *CalendarShare, others []*CalendarShare, mattermostUserID, email, oldRole string) error {
+	current, err := c.GetCalendarAccess(share.CalendarID, email)
+	if err != nil {
+		return err
+	}
+
+	othersRole, othersPrior, covered := otherGrants(others, email)
+
+	prior := current
+	switch {
+	case share.Members[mattermostUserID] == email:
+		prior = share.PriorRoles[mattermostUserID]
+	case covered:
+		prior = othersPrior
+	}
+
+	role := maxAccessRole(prior, othersRole, share.Role)
+	lower := oldRole != "" && current == maxAccessRole(prior, othersRole, oldRole)
+	if accessRoleRanks[role] > accessRoleRanks[current] || (lower && role != current) {
+		err = c.ShareCalendar(share.CalendarID, email, role)
+		if err != nil {
+			return err
+		}
+	}
+
+	if share.PriorRoles == nil {
+		share.PriorRoles = map[string]string{}
+	}
+	share.Members[mattermostUserID] = email
+	share.PriorRoles[mattermostUserID] = prior
+
+	return nil
+}
+
+// revokeMember takes back the access the share gave a member, leaving them
+// the role they had before or that other shares of the calendar still grant.
+// An access rule someone changed since is left as it is.
+func (cs *CalendarSharing) revokeMember(c *client, share *CalendarShare, others []*CalendarShare, mattermostUserID string) error {
+	email := share.Members[mattermostUserID]
+	prior := share.PriorRoles[mattermostUserID]
+
+	current, err := c.GetCalendarAccess(share.CalendarID, email)
+	if err != nil {
+		return err
+	}
+
+	othersRole, _, _ := otherGrants(others, email)
+	remaining := maxAccessRole(prior, othersRole)
+	if current == maxAccessRole(prior, othersRole, share.Role) && current != remaining {
+		if remaining == "" {
+			err = c.UnshareCalendar(share.CalendarID, email)
+		} else {
+			err = c.ShareCalendar(share.CalendarID, email, remaining)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(share.Members, mattermostUserID)
+	delete(share.PriorRoles, mattermostUserID)
+
+	return nil
+}
+
+// otherShares returns the shares of the same calendar with other channels and
+// groups, which may grant access to the same members
+func (cs *CalendarSharing) otherShares(share *CalendarShare) ([]*CalendarShare, error) {
+	err := cs.buildShareIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	targetIDs, err := cs.Store.LoadCalendarShareIndex(share.CalendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	others := []*CalendarShare{}
+	for _, targetID := range targetIDs {
+		if targetID == share.TargetID() {
+			continue
+		}
+
+		shares, err := cs.Store.LoadCalendarShares(targetID)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range shares {
+			if other.CalendarID == share.CalendarID {
+				others = append(others, other)
+			}
+		}
+	}
+
+	return others, nil
+}
+
+// buildShareIndex adds the shares stored before the share index existed to
+// it. It lists all the keys once, the first time the index is needed.
+func (cs *CalendarSharing) buildShareIndex() error {
+	built, err := cs.Store.IsCalendarShareIndexBuilt()
+	if err != nil || built {
+		return err
+	}
+
+	unlock, err := lockCluster(cs.API, "gcal_calendar_share_index_build_lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	built, err = cs.Store.IsCalendarShareIndexBuilt()
+	if err != nil || built {
+		return err
+	}
+
+	targetIDs, err := cs.Store.ListCalendarShareTargets()
+	if err != nil {
+		return err
+	}
+	for _, targetID := range targetIDs {
+		shares, err := cs.Store.LoadCalendarShares(targetID)
+		if err != nil {
+			return err
+		}
+		for _, share := range shares {
+			err = cs.Store.AddCalendarShareIndex(share.CalendarID, targetID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return cs.Store.StoreCalendarShareIndexBuilt()
+}
+
+// otherGrants returns the highest role other shares grant to a Google email,
+// the role the email had apart from the plugin's shares, and whether any
+// other share covers it at all
+func otherGrants(others []*CalendarShare, email string) (role, prior string, covered bool) {
+	for _, other := range others {
+		for mattermostUserID, granted := range other.Members {
+			if granted != email {
+				continue
+			}
+			role = maxAccessRole(role, other.Role)
+			prior = other.PriorRoles[mattermostUserID]
+			covered = true
+		}
+	}
+	return role, prior, covered
+}
+
+// getGoogleEmail returns the Google email of a connected user, or an empty
+// string when the user did not connect their account
+func (cs *CalendarSharing) getGoogleEmail(mattermostUserID string) string {
+	user, err := cs.Env.Store.LoadUser(mattermostUserID)
+	if err != nil || user.Remote == nil || !strings.Contains(user.Remote.Mail, "@") {
+		return ""
+	}
+	return user.Remote.Mail
+}
+
+func (cs *CalendarSharing) getTargetMemberIDs(share *CalendarShare) ([]string, error) {
+	memberIDs := []string{}
+
+	if share.GroupID != "" {
+		for page := 0; ; page++ {
+			users, appErr := cs.API.GetGroupMemberUsers(share.GroupID, page, membersPerPage)
+			if appErr != nil {
+				return nil, errors.Wrap(appErr, "failed to get group members")
+			}
+			for _, user := range users {
+				memberIDs = append(memberIDs, user.Id)
+			}
+			if len(users) < membersPerPage {
+				return memberIDs, nil
+			}
+		}
+	}
+
+	for page := 0; ; page++ {
+		members, appErr := cs.API.GetChannelMembers(share.ChannelID, page, membersPerPage)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get channel members")
+		}
+		for _, member := range members {
+			memberIDs = append(memberIDs, member.UserId)
+		}
+		if len(members) < membersPerPage {
+			return memberIDs, nil
+		}
+	}
+}
+
+// getOwnedCalendar returns the client of the user and one of their calendars,
+// which they must own to manage who it is shared with
+func (cs *CalendarSharing) getOwnedCalendar(mattermostUserID, nameOrID string) (*client, *Calendar, error) {
+	c, err := cs.makeClient(mattermostUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, cal := range calendars {
+		if cal.ID == nameOrID || strings.EqualFold(cal.Name, nameOrID) || (nameOrID == defaultCalendarName && cal.Primary) {
+			if cal.AccessRole != AccessRoleOwner {
+				return nil, nil, errors.Errorf("only owners can share calendar %s", cal.Name)
+			}
+			return c, cal, nil
+		}
+	}
+
+	return nil, nil, errors.Errorf("no calendar in your calendar list matches %q", nameOrID)
+}
+
+func (cs *CalendarSharing) lockTarget(targetID string) (unlock func(), err error) {
+	return lockCluster(cs.API, "gcal_calendar_shares_lock_"+targetID)
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+const testTeamCalendarID = "team@group.calendar.google.com"
+
+var testGoogleEmails = map[string]string{
+	"owner": "owner@example.com",
+	"user1": "user1@example.com",
+	"user2": "user2@example.com",
+	"user3": "user3@example.com",
+}
+
+func newTestCalendarSharing(t *testing.T) (*CalendarSharing, *plugintest.API, *fakeGoogle) {
+	g := newFakeGoogle(t, &calendar.CalendarListEntry{
+		Id:         testTeamCalendarID,
+		Summary:    "Team",
+		AccessRole: AccessRoleOwner,
+	})
+	g.setRole(testTeamCalendarID, testGoogleEmails["owner"], AccessRoleOwner)
+
+	api := newTestAPI(t)
+	cs := NewCalendarSharing(newTestEnv(nil), NewStore(api), api)
+	cs.makeClient = g.makeClient(api)
+	cs.googleEmail = func(mattermostUserID string) string {
+		return testGoogleEmails[mattermostUserID]
+	}
+
+	return cs, api, g
+}
+
+func TestCalendarSharingShare(t *testing.T) {
+	cs, api, g := newTestCalendarSharing(t)
+	mockChannelMembers(api, map[string][]string{
+		"channel1": {"owner", "user1", "user2", "notconnected"},
+	})
+	g.setRole(testTeamCalendarID, testGoogleEmails["user2"], AccessRoleWriter)
+
+	share, err := cs.Share("owner", "Team", AccessRoleReader, "channel1", "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"user1": "user1@example.com", "user2": "user2@example.com"}, share.Members)
+	require.Equal(t, map[string]string{"user1": "", "user2": AccessRoleWriter}, share.PriorRoles)
+	require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user1@example.com"))
+	require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user2@example.com"), "a higher role is not lowered")
+	require.Equal(t, AccessRoleOwner, g.role(testTeamCalendarID, "owner@example.com"))
+
+	shares, err := cs.ListShares("channel1")
+	require.NoError(t, err)
+	require.Len(t, shares, 1)
+
+	t.Run("changing the role only changes the access the share granted", func(t *testing.T) {
+		_, err := cs.Share("owner", "Team", AccessRoleWriter, "channel1", "")
+		require.NoError(t, err)
+		require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user1@example.com"))
+
+		_, err = cs.Share("owner", "Team", AccessRoleFreeBusyReader, "channel1", "")
+		require.NoError(t, err)
+		require.Equal(t, AccessRoleFreeBusyReader, g.role(testTeamCalendarID, "user1@example.com"))
+		require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user2@example.com"))
+	})
+
+	t.Run("only owners share", func(t *testing.T) {
+		g.calendars[0].AccessRole = AccessRoleWriter
+		defer func() { g.calendars[0].AccessRole = AccessRoleOwner }()
+
+		_, err := cs.Share("owner", "Team", AccessRoleReader, "channel1", "")
+		require.Error(t, err)
+	})
+
+	t.Run("invalid role", func(t *testing.T) {
+		_, err := cs.Share("owner", "Team", AccessRoleOwner, "channel1", "")
+		require.Error(t, err)
+	})
+}
+
+func TestCalendarSharingUnshare(t *testing.T) {
+	cs, api, g := newTestCalendarSharing(t)
+	mockChannelMembers(api, map[string][]string{
+		"channel1": {"owner", "user1", "user2"},
+		"channel2": {"owner", "user1"},
+	})
+	g.setRole(testTeamCalendarID, testGoogleEmails["user2"], AccessRoleWriter)
+
+	_, err := cs.Share("owner", "Team", AccessRoleReader, "channel1", "")
+	require.NoError(t, err)
+	_, err = cs.Share("owner", "Team", AccessRoleWriter, "channel2", "")
+	require.NoError(t, err)
+	require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user1@example.com"))
+
+	// channel1 still gives user1 read access
+	_, err = cs.Unshare("owner", "Team", "channel2", "")
+	require.NoError(t, err)
+	require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user1@example.com"))
+
+	// user2 had write access before the share
+	_, err = cs.Unshare("owner", "Team", "channel1", "")
+	require.NoError(t, err)
+	require.Empty(t, g.role(testTeamCalendarID, "user1@example.com"))
+	require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user2@example.com"))
+
+	shares, err := cs.ListShares("channel1")
+	require.NoError(t, err)
+	require.Empty(t, shares)
+
+	_, err = cs.Unshare("owner", "Team", "channel1", "")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestCalendarSharingUnshareKeepsChangedRules(t *testing.T) {
+	cs, api, g := newTestCalendarSharing(t)
+	mockChannelMembers(api, map[string][]string{
+		"channel1": {"owner", "user1"},
+	})
+
+	_, err := cs.Share("owner", "Team", AccessRoleReader, "channel1", "")
+	require.NoError(t, err)
+
+	// The owner gave user1 more access in Google Calendar meanwhile
+	g.setRole(testTeamCalendarID, testGoogleEmails["user1"], AccessRoleWriter)
+
+	_, err = cs.Unshare("owner", "Team", "channel1", "")
+	require.NoError(t, err)
+	require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user1@example.com"))
+}
+
+func TestCalendarSharingIndex(t *testing.T) {
+	cs, api, g := newTestCalendarSharing(t)
+	mockChannelMembers(api, map[string][]string{
+		"channel1": {"owner", "user1"},
+		"channel2": {"owner", "user1"},
+	})
+
+	// Shares stored before the share index existed
+	for targetID, role := range map[string]string{"channel1": AccessRoleReader, "channel2": AccessRoleWriter} {
+		err := cs.Store.StoreCalendarShares(targetID, []*CalendarShare{{
+			CalendarID: testTeamCalendarID,
+			Role:       role,
+			ChannelID:  targetID,
+			SharedBy:   "owner",
+			Members:    map[string]string{"user1": "user1@example.com"},
+			PriorRoles: map[string]string{"user1": ""},
+		}})
+		require.NoError(t, err)
+	}
+	g.setRole(testTeamCalendarID, testGoogleEmails["user1"], AccessRoleWriter)
+
+	// channel1 still gives user1 read access
+	_, err := cs.Unshare("owner", "Team", "channel2", "")
+	require.NoError(t, err)
+	require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user1@example.com"))
+
+	targetIDs, err := cs.Store.LoadCalendarShareIndex(testTeamCalendarID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"channel1"}, targetIDs)
+
+	t.Run("the index is kept without listing the keys", func(t *testing.T) {
+		listed := len(api.Calls)
+		countLists := func() int {
+			count := 0
+			for _, call := range api.Calls[listed:] {
+				if call.Method == "KVList" {
+					count++
+				}
+			}
+			return count
+		}
+
+		_, err := cs.Share("owner", "Team", AccessRoleWriter, "channel2", "")
+		require.NoError(t, err)
+		require.Equal(t, AccessRoleWriter, g.role(testTeamCalendarID, "user1@example.com"))
+
+		targetIDs, err := cs.Store.LoadCalendarShareIndex(testTeamCalendarID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"channel1", "channel2"}, targetIDs)
+
+		_, err = cs.Unshare("owner", "Team", "channel1", "")
+		require.NoError(t, err)
+		_, err = cs.Unshare("owner", "Team", "channel2", "")
+		require.NoError(t, err)
+		require.Empty(t, g.role(testTeamCalendarID, "user1@example.com"))
+		require.Zero(t, countLists())
+
+		targetIDs, err = cs.Store.LoadCalendarShareIndex(testTeamCalendarID)
+		require.NoError(t, err)
+		require.Empty(t, targetIDs)
+	})
+}
+
+func TestCalendarSharingMembershipChange(t *testing.T) {
+	cs, api, g := newTestCalendarSharing(t)
+	members := map[string][]string{
+		"channel1": {"owner", "user1"},
+	}
+	mockChannelMembers(api, members)
+
+	_, err := cs.Share("owner", "Team", AccessRoleReader, "channel1", "")
+	require.NoError(t, err)
+	require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user1@example.com"))
+
+	t.Run("joining grants access", func(t *testing.T) {
+		members["channel1"] = []string{"owner", "user1", "user2"}
+		cs.HandleChannelMembershipChange("channel1", "user2", true)
+		require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user2@example.com"))
+	})
+
+	t.Run("leaving removes access", func(t *testing.T) {
+		members["channel1"] = []string{"owner", "user2"}
+		cs.HandleChannelMembershipChange("channel1", "user1", false)
+		require.Empty(t, g.role(testTeamCalendarID, "user1@example.com"))
+	})
+
+	t.Run("resync catches missed changes", func(t *testing.T) {
+		members["channel1"] = []string{"owner", "user3"}
+		cs.ResyncAll()
+		require.Empty(t, g.role(testTeamCalendarID, "user2@example.com"))
+		require.Equal(t, AccessRoleReader, g.role(testTeamCalendarID, "user3@example.com"))
+
+		shares, err := cs.ListShares("channel1")
+		require.NoError(t, err)
+		require.Len(t, shares, 1)
+		require.Equal(t, map[string]string{"user3": "user3@example.com"}, shares[0].Members)
+	})
+}
+
+func TestCalendarSharingResolveTarget(t *testing.T) {
+	cs, api, _ := newTestCalendarSharing(t)
+	notFound := model.NewAppError("test", "not_found", nil, "", http.StatusNotFound)
+
+	api.On("GetChannelMember", "current", "user1").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelByName", "team1", "town-square", false).Return(&model.Channel{Id: "public"}, nil)
+	api.On("GetChannelMember", "public", "user1").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelByName", "team1", "secret", false).Return(&model.Channel{Id: "private"}, nil)
+	api.On("GetChannelMember", "private", "user1").Return(nil, notFound)
+	api.On("GetGroupByName", "everyone").Return(&model.Group{Id: "group1", AllowReference: true}, nil)
+	api.On("GetGroupByName", "hidden").Return(&model.Group{Id: "group2"}, nil)
+	api.On("GetGroupByName", "mine").Return(&model.Group{Id: "group3"}, nil)
+	api.On("GetGroupsForUser", "user1").Return([]*model.Group{{Id: "group3"}}, nil)
+
+	for _, tc := range []struct {
+		Target    string
+		ChannelID string
+		GroupID   string
+		Err       bool
+	}{
+		{Target: "", ChannelID: "current"},
+		{Target: "~town-square", ChannelID: "public"},
+		{Target: "~secret", Err: true},
+		{Target: "@everyone", GroupID: "group1"},
+		{Target: "@hidden", Err: true},
+		{Target: "@mine", GroupID: "group3"},
+		{Target: "town-square", Err: true},
+	} {
+		t.Run(tc.Target, func(t *testing.T) {
+			channelID, groupID, err := cs.ResolveTarget("user1", "team1", "current", tc.Target)
+			if tc.Err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.ChannelID, channelID)
+			require.Equal(t, tc.GroupID, groupID)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func createCalendarList() []*Calendar {
+	return []*Calendar{
+		{Calendar: &remote.Calendar{ID: "primary-id"}, Primary: true, AccessRole: AccessRoleOwner},
+		{Calendar: &remote.Calendar{ID: "team-id"}, AccessRole: AccessRoleWriter},
+		{Calendar: &remote.Calendar{ID: "holidays-id"}, AccessRole: AccessRoleReader},
+	}
+}
+
+func TestFilterSelectedCalendars(t *testing.T) {
+	for _, tc := range []struct {
+		Name        string
+		SelectedIDs []string
+		Expected    []string
+	}{
+		{
+			Name:     "primary calendar is used when nothing is selected",
+			Expected: []string{"primary-id"},
+		},
+		{
+			Name:        "selected calendars are returned in calendar list order",
+			SelectedIDs: []string{"holidays-id", "primary-id"},
+			Expected:    []string{"primary-id", "holidays-id"},
+		},
+		{
+			Name:        "unknown calendars are skipped",
+			SelectedIDs: []string{"team-id", "removed-id"},
+			Expected:    []string{"team-id"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			ids := []string{}
+			for _, cal := range filterSelectedCalendars(createCalendarList(), tc.SelectedIDs) {
+				ids = append(ids, cal.ID)
+			}
+			require.Equal(t, tc.Expected, ids)
+		})
+	}
+}
+
+func TestParseCalendarID(t *testing.T) {
+	const holidays = "en.usa#holiday@group.v.calendar.google.com"
+
+	for _, tc := range []struct {
+		Name     string
+		Input    string
+		Expected string
+	}{
+		{
+			Name:     "calendar ID",
+			Input:    " " + holidays + " ",
+			Expected: holidays,
+		},
+		{
+			Name:     "embed link",
+			Input:    "https://calendar.google.com/calendar/embed?src=en.usa%23holiday%40group.v.calendar.google.com&ctz=America%2FNew_York",
+			Expected: holidays,
+		},
+		{
+			Name:     "subscription link",
+			Input:    "https://calendar.google.com/calendar/u/0?cid=ZW4udXNhI2hvbGlkYXlAZ3JvdXAudi5jYWxlbmRhci5nb29nbGUuY29t",
+			Expected: holidays,
+		},
+		{
+			Name:     "iCal address",
+			Input:    "https://calendar.google.com/calendar/ical/en.usa%23holiday%40group.v.calendar.google.com/public/basic.ics",
+			Expected: holidays,
+		},
+		{
+			Name:     "unrelated URL",
+			Input:    "https://example.com/calendar",
+			Expected: "",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, parseCalendarID(tc.Input))
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
+)
+
+// CalendarDTO is a calendar of the user's calendar list for the frontend
+type CalendarDTO struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Description     string `json:"description,omitempty"`
+	TimeZone        string `json:"timeZone,omitempty"`
+	ColorID         string `json:"colorId,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	ForegroundColor string `json:"foregroundColor,omitempty"`
+	AccessRole      string `json:"accessRole"`
+	Primary         bool   `json:"primary"`
+	Hidden          bool   `json:"hidden"`
+
+	// Selected and Default are the plugin preferences of the user, not the
+	// calendar settings in Google Calendar
+	Selected bool `json:"selected"`
+	Default  bool `json:"default"`
+}
+
+// CalendarsResponse is the response for the calendars API
+type CalendarsResponse struct {
+	Calendars []*CalendarDTO `json:"calendars"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// SelectedCalendarsRequest is the request body for choosing the calendars included in event views
+type SelectedCalendarsRequest struct {
+	CalendarIDs []string `json:"calendar_ids"`
+}
+
+// SelectedCalendarsResponse is the response for the selected calendars API
+type SelectedCalendarsResponse struct {
+	CalendarIDs []string `json:"calendar_ids"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// DefaultCalendarRequest is the request body for choosing the calendar new events are created on
+type DefaultCalendarRequest struct {
+	CalendarID string `json:"calendar_id"`
+}
+
+// DefaultCalendarResponse is the response for the default calendar API
+type DefaultCalendarResponse struct {
+	CalendarID string `json:"calendar_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HandleGetCalendars handles GET /api/v1/calendars
+func (h *EventsAPIHandler) HandleGetCalendars(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		httputils.WriteJSONResponse(w, &CalendarsResponse{Error: "Not authorized"}, http.StatusUnauthorized)
+		return
+	}
+
+	c, err := h.getClient(mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CalendarsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CalendarsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := c.loadUserSettings()
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CalendarsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	selected := map[string]bool{}
+	for _, cal := range filterSelectedCalendars(calendars, settings.SelectedCalendarIDs) {
+		selected[cal.ID] = true
+	}
+
+	defaultCalendar := findCalendar(calendars, defaultCalendarName)
+	if settings.DefaultCalendarID != "" {
+		defaultCalendar = findCalendar(calendars, settings.DefaultCalendarID)
+	}
+
+	dtos := make([]*CalendarDTO, 0, len(calendars))
+	for _, cal := range calendars {
+		dtos = append(dtos, &CalendarDTO{
+			ID:              cal.ID,
+			Name:            cal.Name,
+			Description:     cal.Description,
+			TimeZone:        cal.TimeZone,
+			ColorID:         cal.ColorID,
+			BackgroundColor: cal.BackgroundColor,
+			ForegroundColor: cal.ForegroundColor,
+			AccessRole:      cal.AccessRole,
+			Primary:         cal.Primary,
+			Hidden:          cal.Hidden,
+			Selected:        selected[cal.ID],
+			Default:         cal == defaultCalendar,
+		})
+	}
+
+	httputils.WriteJSONResponse(w, &CalendarsResponse{Calendars: dtos}, http.StatusOK)
+}
+
+// HandleSelectedCalendars handles GET and PUT /api/v1/calendars/selected
+func (h *EventsAPIHandler) HandleSelectedCalendars(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: "Not authorized"}, http.StatusUnauthorized)
+		return
+	}
+
+	c, err := h.getClient(mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req SelectedCalendarsRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+
+		if err = h.storeSelectedCalendars(c, mattermostUserID, req.CalendarIDs); err != nil {
+			httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	default:
+		httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	calendars, err := c.SelectedCalendars()
+	if err != nil {
+		httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]string, 0, len(calendars))
+	for _, cal := range calendars {
+		ids = append(ids, cal.ID)
+	}
+
+	httputils.WriteJSONResponse(w, &SelectedCalendarsResponse{CalendarIDs: ids}, http.StatusOK)
+}
+
+// storeSelectedCalendars saves the calendars to include in the user's event
+// views. An empty list goes back to showing only the primary calendar.
+func (h *EventsAPIHandler) storeSelectedCalendars(c *client, mattermostUserID string, calendarIDs []string) error {
+	if h.Store == nil {
+		return fmt.Errorf("calendar selection is not available")
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, cal := range calendars {
+		known[cal.ID] = true
+	}
+	for _, id := range calendarIDs {
+		if !known[id] {
+			return fmt.Errorf("calendar %s is not in your calendar list", id)
+		}
+	}
+
+	settings, err := h.Store.LoadUserSettings(mattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	settings.SelectedCalendarIDs = calendarIDs
+	err = h.Store.StoreUserSettings(mattermostUserID, settings)
+	if err != nil {
+		return err
+	}
+
+	// Notifications follow the selection, failing to watch a calendar
+	// does not undo the selection
+	err = c.SyncCalendarWatches()
+	if err != nil {
+		h.Env.Logger.Warnf("gcal: failed to sync calendar watches. err=%v", err)
+	}
+	return nil
+}
+
+// HandleDefaultCalendar handles GET and PUT /api/v1/calendars/default
+func (h *EventsAPIHandler) HandleDefaultCalendar(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: "Not authorized"}, http.StatusUnauthorized)
+		return
+	}
+
+	c, err := h.getClient(mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req DefaultCalendarRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+
+		if err = h.storeDefaultCalendar(c, mattermostUserID, req.CalendarID); err != nil {
+			httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	default:
+		httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	calendarID, err := c.DefaultEventCalendarID()
+	if err != nil {
+		httputils.WriteJSONResponse(w, &DefaultCalendarResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	httputils.WriteJSONResponse(w, &DefaultCalendarResponse{CalendarID: calendarID}, http.StatusOK)
+}
+
+// storeDefaultCalendar saves the calendar new events are created on. An
+// empty ID goes back to creating events on the primary calendar.
+func (h *EventsAPIHandler) storeDefaultCalendar(c *client, mattermostUserID, calendarID string) error {
+	if h.Store == nil {
+		return fmt.Errorf("default calendar selection is not available")
+	}
+
+	if calendarID != "" {
+		cal, err := c.GetWritableCalendar(calendarID)
+		if err != nil {
+			return err
+		}
+		calendarID = cal.ID
+	}
+
+	settings, err := h.Store.LoadUserSettings(mattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	settings.DefaultCalendarID = calendarID
+	return h.Store.StoreUserSettings(mattermostUserID, settings)
+}
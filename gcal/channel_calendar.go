@@ -0,0 +1,442 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const (
+	PathChannelCalendarWebhook = "/api/v1/calendars/webhook"
+
+	channelWatchPrefix = "gcal"
+
+	// watchRenewBefore is how long before expiring a watch channel is replaced
+	watchRenewBefore = 24 * time.Hour
+
+	// Events posted about from Mattermost are left out of the calendar syncs
+	// for a while, the change notifications come within seconds
+	postedEventKeyPrefix = "gcal_event_posted_"
+	postedEventExpiry    = time.Hour
+)
+
+// ChannelCalendars links Google calendars to Mattermost channels. Events
+// created from a linked channel land on its calendar, and the channel gets a
+// post when an event of the calendar is added, changed or cancelled.
+type ChannelCalendars struct {
+	Env       engine.Env
+	Store     Store
+	API       plugin.API
+	BotUserID string
+
+	// makeClient is replaced in tests
+	makeClient func(mattermostUserID string) (*client, error)
+}
+
+// NewChannelCalendars creates a new channel calendars handler
+func NewChannelCalendars(env engine.Env, store Store, api plugin.API, botUserID string) *ChannelCalendars {
+	return &ChannelCalendars{
+		Env:       env,
+		Store:     store,
+		API:       api,
+		BotUserID: botUserID,
+
+		makeClient: userClientMaker(env),
+	}
+}
+
+// Link links one of the user's calendars to a channel. The calendar is
+// watched with the Google account of the user, who must be a channel admin
+// and be allowed to create events on the calendar.
+func (cc *ChannelCalendars) Link(mattermostUserID, channelID, nameOrID string) (*ChannelCalendar, error) {
+	if !cc.API.HasPermissionToChannel(mattermostUserID, channelID, model.PermissionManageChannelRoles) {
+		return nil, errors.New("only channel admins can link a calendar to the channel")
+	}
+
+	c, err := cc.makeClient(mattermostUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	var cal *Calendar
+	for _, candidate := range calendars {
+		if candidate.ID == nameOrID || strings.EqualFold(candidate.Name, nameOrID) {
+			cal = candidate
+			break
+		}
+	}
+	if cal == nil {
+		return nil, errors.Errorf("no calendar in your calendar list matches %q", nameOrID)
+	}
+	if !cal.CanWrite() {
+		return nil, errors.Errorf("you are not allowed to create events on calendar %s", cal.Name)
+	}
+
+	previous, err := cc.Store.LoadChannelCalendar(channelID)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	watch, err := c.WatchCalendarEvents(cal.ID, newChannelWatchID(channelID), cc.notificationURL())
+	if err != nil {
+		return nil, err
+	}
+
+	link := &ChannelCalendar{
+		ChannelID:        channelID,
+		CalendarID:       cal.ID,
+		CalendarName:     cal.Name,
+		CalendarTimeZone: cal.TimeZone,
+		LinkedBy:         mattermostUserID,
+		Watch:            watch,
+		LastSyncTime:     time.Now(),
+	}
+	err = cc.Store.StoreChannelCalendar(link)
+	if err != nil {
+		// The previous link stays, with its watch
+		cc.stopWatch(link)
+		return nil, err
+	}
+	if previous != nil {
+		cc.stopWatch(previous)
+	}
+	cc.forgetReminders(channelID)
+
+	cc.postToChannel(channelID, fmt.Sprintf("%s linked calendar **%s** to this channel. Events created from this channel are added to it, and changes to its events are posted here.", cc.mention(mattermostUserID), cal.Name))
+
+	return link, nil
+}
+
+// Unlink removes the calendar linked to a channel
+func (cc *ChannelCalendars) Unlink(mattermostUserID, channelID string) (*ChannelCalendar, error) {
+	if !cc.API.HasPermissionToChannel(mattermostUserID, channelID, model.PermissionManageChannelRoles) {
+		return nil, errors.New("only channel admins can unlink the channel calendar")
+	}
+
+	link, err := cc.Store.LoadChannelCalendar(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.stopWatch(link)
+
+	err = cc.Store.DeleteChannelCalendar(channelID)
+	if err != nil {
+		return nil, err
+	}
+	cc.forgetReminders(channelID)
+
+	cc.postToChannel(channelID, fmt.Sprintf("%s unlinked calendar **%s** from this channel.", cc.mention(mattermostUserID), link.CalendarName))
+
+	return link, nil
+}
+
+// HandleWebhook handles the Google push notifications of linked calendars
+func (cc *ChannelCalendars) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, isSync := parseWebhook(r)
+	if isSync {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	channelID := channelIDFromWatchID(wh.SubscriptionID)
+	link, err := cc.Store.LoadChannelCalendar(channelID)
+	if err != nil || link.Watch == nil || link.Watch.ID != wh.SubscriptionID || link.Watch.Token != wh.ClientState {
+		// Google stops retrying once the channel is unknown
+		http.Error(w, "Unknown channel", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	go cc.syncChannelCalendar(channelID)
+}
+
+// syncChannelCalendar posts the changes made to the calendar of a channel
+// since the last sync. Notifications can arrive in bursts, so syncs of the
+// same channel are serialized across the cluster.
+func (cc *ChannelCalendars) syncChannelCalendar(channelID string) {
+	logger := cc.Env.Logger.With(bot.LogContext{"channelID": channelID})
+
+	unlock, err := cc.lockChannel(channelID)
+	if err != nil {
+		logger.Errorf("gcal: failed to lock channel calendar. err=%v", err)
+		return
+	}
+	defer unlock()
+
+	link, err := cc.Store.LoadChannelCalendar(channelID)
+	if err != nil {
+		logger.Warnf("gcal: failed to load channel calendar. err=%v", err)
+		return
+	}
+
+	c, err := cc.makeClient(link.LinkedBy)
+	if err != nil {
+		logger.Warnf("gcal: failed to make client for channel calendar. err=%v", err)
+		return
+	}
+
+	syncTime := time.Now()
+	changes, err := c.GetEventChanges(link.CalendarID, link.LastSyncTime)
+	if err != nil {
+		logger.Warnf("gcal: failed to get channel calendar changes. err=%v", err)
+		return
+	}
+
+	for _, change := range changes {
+		if cc.wasPosted(link.CalendarID, change.ID) {
+			continue
+		}
+		cc.postToChannel(channelID, formatEventChange(link, change))
+	}
+	if len(changes) > 0 {
+		cc.forgetReminders(channelID)
+	}
+
+	link.LastSyncTime = syncTime
+	err = cc.Store.StoreChannelCalendar(link)
+	if err != nil {
+		logger.Warnf("gcal: failed to store channel calendar. err=%v", err)
+	}
+}
+
+// RenewWatches replaces the watch channels of linked calendars that are about
+// to expire. Google does not renew channels, so this runs periodically.
+func (cc *ChannelCalendars) RenewWatches() {
+	links, err := cc.Store.ListChannelCalendars()
+	if err != nil {
+		cc.Env.Logger.Warnf("gcal: failed to list channel calendars. err=%v", err)
+		return
+	}
+
+	for _, link := range links {
+		if link.Watch != nil && time.Until(link.Watch.ExpiresAt()) > watchRenewBefore {
+			continue
+		}
+
+		err = cc.renewWatch(link)
+		if err != nil {
+			cc.Env.Logger.With(bot.LogContext{
+				"channelID":  link.ChannelID,
+				"calendarID": link.CalendarID,
+			}).Warnf("gcal: failed to renew channel calendar watch. err=%v", err)
+		}
+	}
+}
+
+func (cc *ChannelCalendars) renewWatch(link *ChannelCalendar) error {
+	unlock, err := cc.lockChannel(link.ChannelID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Reload under the lock, a sync may have updated the link meanwhile
+	link, err = cc.Store.LoadChannelCalendar(link.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	c, err := cc.makeClient(link.LinkedBy)
+	if err != nil {
+		return err
+	}
+
+	watch, err := c.WatchCalendarEvents(link.CalendarID, newChannelWatchID(link.ChannelID), cc.notificationURL())
+	if err != nil {
+		return err
+	}
+
+	if link.Watch != nil {
+		_ = c.StopWatch(link.Watch)
+	}
+
+	link.Watch = watch
+	return cc.Store.StoreChannelCalendar(link)
+}
+
+// lockChannel serializes the updates of a channel calendar across the cluster
+func (cc *ChannelCalendars) lockChannel(channelID string) (unlock func(), err error) {
+	return lockCluster(cc.API, "gcal_channel_calendar_lock_"+channelID)
+}
+
+// stopWatch closes the watch channel of a link, with the credentials of the
+// user who opened it
+func (cc *ChannelCalendars) stopWatch(link *ChannelCalendar) {
+	if link.Watch == nil {
+		return
+	}
+
+	c, err := cc.makeClient(link.LinkedBy)
+	if err == nil {
+		err = c.StopWatch(link.Watch)
+	}
+	if err != nil {
+		cc.Env.Logger.With(bot.LogContext{
+			"channelID": link.ChannelID,
+			"watchID":   link.Watch.ID,
+		}).Warnf("gcal: failed to stop channel calendar watch. err=%v", err)
+	}
+}
+
+// PostEventCancellation tells the channels linked to a calendar that a user
+// cancelled one of its events, with the message the user gave
+func (cc *ChannelCalendars) PostEventCancellation(mattermostUserID, calendarID string, event *remote.Event, message string) {
+	links, err := cc.Store.ListChannelCalendars()
+	if err != nil {
+		cc.Env.Logger.Warnf("gcal: failed to list channel calendars. err=%v", err)
+		return
+	}
+
+	for _, link := range links {
+		if link.CalendarID != calendarID {
+			continue
+		}
+
+		subject := event.Subject
+		if subject == "" {
+			subject = "(No title)"
+		}
+		post := fmt.Sprintf("%s cancelled **%s**", cc.mention(mattermostUserID), subject)
+		if when := formatEventTime(event, link.CalendarTimeZone); when != "" {
+			post += " (" + when + ")"
+		}
+		if message != "" {
+			post += "\n> " + strings.ReplaceAll(message, "\n", "\n> ")
+		}
+		cc.postToChannel(link.ChannelID, post)
+	}
+}
+
+// markPosted keeps the changes of an event out of the syncs of the channels
+// linked to its calendar, for an event the channels get a post about from
+// Mattermost. It is marked before being changed, as the sync can run before
+// the change request returns.
+func (cc *ChannelCalendars) markPosted(calendarID, eventID string) {
+	appErr := cc.API.KVSetWithExpiry(postedEventKey(calendarID, eventID), []byte("1"), int64(postedEventExpiry/time.Second))
+	if appErr != nil {
+		cc.Env.Logger.Warnf("gcal: failed to mark event as posted. err=%v", appErr)
+	}
+}
+
+// forgetPosted lets the syncs post the changes of an event again, for when
+// the change marked with markPosted failed
+func (cc *ChannelCalendars) forgetPosted(calendarID, eventID string) {
+	appErr := cc.API.KVDelete(postedEventKey(calendarID, eventID))
+	if appErr != nil {
+		cc.Env.Logger.Warnf("gcal: failed to unmark posted event. err=%v", appErr)
+	}
+}
+
+func (cc *ChannelCalendars) wasPosted(calendarID, eventID string) bool {
+	data, appErr := cc.API.KVGet(postedEventKey(calendarID, eventID))
+	if appErr != nil {
+		cc.Env.Logger.Warnf("gcal: failed to check posted event. err=%v", appErr)
+		return false
+	}
+	return data != nil
+}
+
+func postedEventKey(calendarID, eventID string) string {
+	sum := sha256.Sum256([]byte(calendarID + "/" + eventID))
+	return postedEventKeyPrefix + hex.EncodeToString(sum[:16])
+}
+
+// forgetReminders drops the reminder schedule of a channel, for the next
+// reminder run to read its calendar again
+func (cc *ChannelCalendars) forgetReminders(channelID string) {
+	err := cc.Store.DeleteReminderSchedule(channelID)
+	if err != nil {
+		cc.Env.Logger.With(bot.LogContext{
+			"channelID": channelID,
+		}).Warnf("gcal: failed to delete reminder schedule. err=%v", err)
+	}
+}
+
+func (cc *ChannelCalendars) postToChannel(channelID, message string) {
+	_, appErr := cc.API.CreatePost(&model.Post{
+		UserId:    cc.BotUserID,
+		ChannelId: channelID,
+		Message:   message,
+	})
+	if appErr != nil {
+		cc.Env.Logger.With(bot.LogContext{
+			"channelID": channelID,
+		}).Warnf("gcal: failed to post to channel. err=%v", appErr)
+	}
+}
+
+func (cc *ChannelCalendars) mention(mattermostUserID string) string {
+	user, appErr := cc.API.GetUser(mattermostUserID)
+	if appErr != nil {
+		return "Someone"
+	}
+	return "@" + user.Username
+}
+
+func (cc *ChannelCalendars) notificationURL() string {
+	return cc.Env.Config.PluginURL + PathChannelCalendarWebhook
+}
+
+// newChannelWatchID returns a unique watch channel ID that routes the
+// notifications back to the Mattermost channel
+func newChannelWatchID(channelID string) string {
+	return channelWatchPrefix + "_" + channelID + "_" + model.NewId()
+}
+
+func channelIDFromWatchID(watchID string) string {
+	parts := strings.Split(watchID, "_")
+	if len(parts) != 3 || parts[0] != channelWatchPrefix {
+		return ""
+	}
+	return parts[1]
+}
+
+func formatEventChange(link *ChannelCalendar, change *EventChange) string {
+	subject := change.Subject
+	if subject == "" {
+		subject = "(No title)"
+	}
+	if change.Weblink != "" {
+		subject = fmt.Sprintf("[%s](%s)", subject, change.Weblink)
+	}
+
+	var verb string
+	switch change.Type {
+	case EventChangeAdded:
+		verb = "New event"
+	case EventChangeCancelled:
+		verb = "Cancelled event"
+	default:
+		verb = "Updated event"
+	}
+
+	message := fmt.Sprintf("#### %s on %s\n**%s**", verb, link.CalendarName, subject)
+	if when := formatEventTime(change.Event, link.CalendarTimeZone); when != "" {
+		message += "\n" + when
+	}
+	if change.Location != nil && change.Location.DisplayName != "" {
+		message += "\n" + change.Location.DisplayName
+	}
+	return message
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func newTestChannelCalendars(t *testing.T) (*ChannelCalendars, *plugintest.API, *fakeGoogle) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: testTeamCalendarID, Summary: "Team", AccessRole: AccessRoleWriter, TimeZone: "Europe/Paris"},
+		&calendar.CalendarListEntry{Id: "holidays", Summary: "Holidays", AccessRole: AccessRoleReader},
+	)
+
+	api := newTestAPI(t)
+	api.On("GetUser", mock.Anything).Return(&model.User{Username: "admin"}, nil).Maybe()
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil).Maybe()
+
+	cc := NewChannelCalendars(newTestEnv(nil), NewStore(api), api, "bot")
+	cc.makeClient = g.makeClient(api)
+
+	return cc, api, g
+}
+
+func TestChannelCalendarsLink(t *testing.T) {
+	cc, api, g := newTestChannelCalendars(t)
+	api.On("HasPermissionToChannel", "admin", "channel1", model.PermissionManageChannelRoles).Return(true)
+	api.On("HasPermissionToChannel", "user", "channel1", model.PermissionManageChannelRoles).Return(false)
+
+	_, err := cc.Link("user", "channel1", "Team")
+	require.Error(t, err, "only channel admins link calendars")
+
+	_, err = cc.Link("admin", "channel1", "Holidays")
+	require.Error(t, err, "the calendar must be writable")
+
+	link, err := cc.Link("admin", "channel1", "Team")
+	require.NoError(t, err)
+	require.Equal(t, testTeamCalendarID, link.CalendarID)
+	require.Equal(t, "Europe/Paris", link.CalendarTimeZone)
+	require.Equal(t, "channel1", channelIDFromWatchID(link.Watch.ID))
+	require.Equal(t, testTeamCalendarID, g.watched(link.Watch.ID))
+
+	stored, err := cc.Store.LoadChannelCalendar("channel1")
+	require.NoError(t, err)
+	require.Equal(t, link.Watch.ID, stored.Watch.ID)
+
+	t.Run("linking again replaces the watch", func(t *testing.T) {
+		relinked, err := cc.Link("admin", "channel1", testTeamCalendarID)
+		require.NoError(t, err)
+		require.NotEqual(t, link.Watch.ID, relinked.Watch.ID)
+		require.Contains(t, g.stopped, link.Watch.ID)
+		require.Equal(t, testTeamCalendarID, g.watched(relinked.Watch.ID))
+		link = relinked
+	})
+
+	t.Run("unlinking stops the watch", func(t *testing.T) {
+		_, err := cc.Unlink("admin", "channel1")
+		require.NoError(t, err)
+		require.Contains(t, g.stopped, link.Watch.ID)
+		require.Empty(t, g.watches)
+
+		_, err = cc.Store.LoadChannelCalendar("channel1")
+		require.Equal(t, ErrNotFound, err)
+	})
+}
+
+// failingLinkStore fails to store channel calendars
+type failingLinkStore struct {
+	Store
+}
+
+func (s *failingLinkStore) StoreChannelCalendar(*ChannelCalendar) error {
+	return errors.New("store unavailable")
+}
+
+func TestChannelCalendarsLinkStoreFailure(t *testing.T) {
+	cc, api, g := newTestChannelCalendars(t)
+	api.On("HasPermissionToChannel", "admin", "channel1", model.PermissionManageChannelRoles).Return(true)
+
+	previous, err := cc.Link("admin", "channel1", "Team")
+	require.NoError(t, err)
+
+	cc.Store = &failingLinkStore{Store: cc.Store}
+	_, err = cc.Link("admin", "channel1", "Team")
+	require.Error(t, err)
+
+	require.Equal(t, map[string]string{previous.Watch.ID: testTeamCalendarID}, g.watches, "the new watch is stopped, the previous one kept")
+	require.Len(t, g.stopped, 1)
+
+	stored, err := cc.Store.LoadChannelCalendar("channel1")
+	require.NoError(t, err)
+	require.Equal(t, previous.Watch.ID, stored.Watch.ID)
+}
+
+func TestChannelCalendarsRenewWatches(t *testing.T) {
+	cc, _, g := newTestChannelCalendars(t)
+
+	watchIDs := map[string]string{}
+	for channelID, expiresIn := range map[string]time.Duration{
+		"expiring": time.Hour,
+		"fresh":    5 * 24 * time.Hour,
+	} {
+		watchIDs[channelID] = newChannelWatchID(channelID)
+		err := cc.Store.StoreChannelCalendar(&ChannelCalendar{
+			ChannelID:  channelID,
+			CalendarID: testTeamCalendarID,
+			LinkedBy:   "admin",
+			Watch: &WatchChannel{
+				ID:         watchIDs[channelID],
+				Expiration: time.Now().Add(expiresIn).UnixMilli(),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	cc.RenewWatches()
+
+	require.Equal(t, []string{watchIDs["expiring"]}, g.stopped)
+
+	renewed, err := cc.Store.LoadChannelCalendar("expiring")
+	require.NoError(t, err)
+	require.NotEqual(t, watchIDs["expiring"], renewed.Watch.ID)
+	require.Equal(t, testTeamCalendarID, g.watched(renewed.Watch.ID))
+
+	fresh, err := cc.Store.LoadChannelCalendar("fresh")
+	require.NoError(t, err)
+	require.Equal(t, watchIDs["fresh"], fresh.Watch.ID, "fresh watches are kept")
+}
+
+func TestChannelCalendarsSyncSkipsPostedEvents(t *testing.T) {
+	cc, api, g := newTestChannelCalendars(t)
+	g.handle("GET calendars/"+testTeamCalendarID+"/events", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{
+			{Id: "cancelled-here", Summary: "Standup", Status: googleEventStatusCancelled},
+			{Id: "cancelled-in-google", Summary: "Retro", Status: googleEventStatusCancelled},
+		}})
+	})
+
+	err := cc.Store.StoreChannelCalendar(&ChannelCalendar{
+		ChannelID:    "channel1",
+		CalendarID:   testTeamCalendarID,
+		CalendarName: "Team",
+		LinkedBy:     "admin",
+	})
+	require.NoError(t, err)
+
+	cc.markPosted(testTeamCalendarID, "cancelled-here")
+	cc.markPosted(testTeamCalendarID, "cancelled-in-google")
+	cc.forgetPosted(testTeamCalendarID, "cancelled-in-google")
+
+	cc.syncChannelCalendar("channel1")
+
+	posts := []string{}
+	for _, call := range api.Calls {
+		if call.Method == "CreatePost" {
+			posts = append(posts, call.Arguments.Get(0).(*model.Post).Message)
+		}
+	}
+	require.Len(t, posts, 1)
+	require.Contains(t, posts[0], "Retro")
+}
@@ -24,10 +24,28 @@ type client struct {
 
 	httpClient *http.Client
 
+	// mattermostUserID and store give access to the gcal specific settings
+	// of the user. store is nil when the plugin did not provide one.
+	mattermostUserID string
+	store            Store
+
+	// maxEventsPerCalendar caps the events read from one calendar by one
+	// query, zero is the default cap
+	maxEventsPerCalendar int
+
 	conf *config.Config
 	bot.Logger
 }
 
+// loadUserSettings returns the stored settings of the client's user, or empty
+// settings when there is no store to read them from.
+func (c *client) loadUserSettings() (*UserSettings, error) {
+	if c.store == nil || c.mattermostUserID == "" {
+		return &UserSettings{}, nil
+	}
+	return c.store.LoadUserSettings(c.mattermostUserID)
+}
+
 func (c *client) CallJSON(method, url string, in, out interface{}) (responseData []byte, err error) {
 	contentType := "application/json"
 	buf := &bytes.Buffer{}
@@ -0,0 +1,473 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
+)
+
+const (
+	PathDeleteCalendarAction = "/api/v1/calendars/actions/delete"
+
+	actionConfirm = "confirm"
+	actionCancel  = "cancel"
+)
+
+const calendarCommandHelp = `###### Calendar commands
+* ` + "`/%[1]s calendar create <name>`" + ` - Create a new calendar
+* ` + "`/%[1]s calendar delete <calendar ID or name>`" + ` - Delete a calendar you own, after confirmation
+* ` + "`/%[1]s calendar add <calendar ID or URL>`" + ` - Add a public calendar, such as a holiday calendar, to your calendars
+* ` + "`/%[1]s calendar link <calendar ID or name>`" + ` - Link a calendar to the current channel (channel admins only)
+* ` + "`/%[1]s calendar unlink`" + ` - Unlink the calendar of the current channel (channel admins only)
+* ` + "`/%[1]s calendar share <freeBusyReader|reader|writer> <calendar ID or name> [~channel|@group]`" + ` - Share a calendar you own with the members of a channel or group, the current channel by default
+* ` + "`/%[1]s calendar unshare <calendar ID or name> [~channel|@group]`" + ` - Stop sharing a calendar with a channel or group
+* ` + "`/%[1]s calendar shares [~channel|@group]`" + ` - List the calendars shared with a channel or group`
+
+// CommandHandler handles the slash commands that the base plugin does not know about
+type CommandHandler struct {
+	Env              engine.Env
+	Store            Store
+	ChannelCalendars *ChannelCalendars
+	CalendarSharing  *CalendarSharing
+	QuickAdd         *QuickAdd
+}
+
+// NewCommandHandler creates a new command handler
+func NewCommandHandler(env engine.Env, store Store, channelCalendars *ChannelCalendars, calendarSharing *CalendarSharing, quickAdd *QuickAdd) *CommandHandler {
+	return &CommandHandler{
+		Env:              env,
+		Store:            store,
+		ChannelCalendars: channelCalendars,
+		CalendarSharing:  calendarSharing,
+		QuickAdd:         quickAdd,
+	}
+}
+
+// Handles reports whether the command is handled here rather than by the base plugin
+func (h *CommandHandler) Handles(args *model.CommandArgs) bool {
+	words, _ := splitCommand(args.Command, 2)
+	if len(words) < 2 || words[0] != "/"+h.Env.Config.Provider.CommandTrigger {
+		return false
+	}
+
+	return words[1] == "calendar" || words[1] == "quickadd" || words[1] == "event"
+}
+
+// Execute runs a command accepted by Handles
+func (h *CommandHandler) Execute(args *model.CommandArgs) *model.CommandResponse {
+	words, parameters := splitCommand(args.Command, 2)
+
+	var resp *model.CommandResponse
+	var err error
+	switch words[1] {
+	case "quickadd":
+		resp, err = h.quickAddEvent(args, parameters)
+	case "event":
+		resp, err = h.addEvent(args, parameters)
+	default:
+		resp, err = h.executeCalendarCommand(args, parameters)
+	}
+	if err != nil {
+		return ephemeralResponse("Error: " + err.Error())
+	}
+
+	return resp
+}
+
+func (h *CommandHandler) executeCalendarCommand(args *model.CommandArgs, parameters string) (*model.CommandResponse, error) {
+	help := ephemeralResponse(fmt.Sprintf(calendarCommandHelp, h.Env.Config.Provider.CommandTrigger))
+
+	words, rest := splitCommand(parameters, 1)
+	if len(words) == 0 {
+		return help, nil
+	}
+
+	switch words[0] {
+	case "link":
+		return h.linkCalendar(args, rest)
+	case "unlink":
+		return h.unlinkCalendar(args)
+	case "share":
+		return h.shareCalendar(args, rest)
+	case "unshare":
+		return h.unshareCalendar(args, rest)
+	case "shares":
+		return h.listCalendarShares(args, rest)
+	}
+
+	c, err := makeUserClient(h.Env, args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch words[0] {
+	case "create":
+		return h.createCalendar(c, rest)
+	case "delete":
+		return h.deleteCalendar(c, rest)
+	case "add":
+		return h.addCalendar(c, rest)
+	}
+	return help, nil
+}
+
+// quickAddEvent creates an event from the text of the command, on the
+// calendar linked to the channel or else the user's default calendar
+func (h *CommandHandler) quickAddEvent(args *model.CommandArgs, text string) (*model.CommandResponse, error) {
+	if text == "" {
+		return ephemeralResponse(fmt.Sprintf(quickAddCommandHelp, h.Env.Config.Provider.CommandTrigger)), nil
+	}
+
+	evt, cal, err := h.QuickAdd.Add(args.UserId, "", args.ChannelId, text)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := ephemeralResponse("")
+	resp.Attachments = []*model.SlackAttachment{h.QuickAdd.card(convertGCalEventToRemoteEvent(evt), cal)}
+	return resp, nil
+}
+
+// addEvent creates an event from the time and title given in the command,
+// on the calendar linked to the channel or else the user's default calendar
+func (h *CommandHandler) addEvent(args *model.CommandArgs, text string) (*model.CommandResponse, error) {
+	if text == "" {
+		return ephemeralResponse(fmt.Sprintf(eventCommandHelp, h.Env.Config.Provider.CommandTrigger)), nil
+	}
+
+	evt, cal, err := h.QuickAdd.AddAt(args.UserId, args.ChannelId, text)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := ephemeralResponse("")
+	resp.Attachments = []*model.SlackAttachment{h.QuickAdd.card(evt, cal)}
+	return resp, nil
+}
+
+func (h *CommandHandler) linkCalendar(args *model.CommandArgs, nameOrID string) (*model.CommandResponse, error) {
+	if nameOrID == "" {
+		link, err := h.Store.LoadChannelCalendar(args.ChannelId)
+		if err == ErrNotFound {
+			return ephemeralResponse("No calendar is linked to this channel."), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ephemeralResponse(fmt.Sprintf("Calendar **%s** (`%s`) is linked to this channel.", link.CalendarName, link.CalendarID)), nil
+	}
+
+	link, err := h.ChannelCalendars.Link(args.UserId, args.ChannelId, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Linked calendar **%s** to this channel.", link.CalendarName)), nil
+}
+
+func (h *CommandHandler) unlinkCalendar(args *model.CommandArgs) (*model.CommandResponse, error) {
+	link, err := h.ChannelCalendars.Unlink(args.UserId, args.ChannelId)
+	if err == ErrNotFound {
+		return ephemeralResponse("No calendar is linked to this channel."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Unlinked calendar **%s** from this channel.", link.CalendarName)), nil
+}
+
+func (h *CommandHandler) shareCalendar(args *model.CommandArgs, parameters string) (*model.CommandResponse, error) {
+	words, rest := splitCommand(parameters, 1)
+	nameOrID, target := splitShareTarget(rest)
+	if len(words) == 0 || nameOrID == "" {
+		return nil, fmt.Errorf("please provide a role and a calendar to share")
+	}
+
+	channelID, groupID, err := h.CalendarSharing.ResolveTarget(args.UserId, args.TeamId, args.ChannelId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	share, err := h.CalendarSharing.Share(args.UserId, nameOrID, words[0], channelID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Shared calendar **%s** as %s with %d connected member(s) of %s. Members who connect their Google account later are added automatically.",
+		share.CalendarName, share.Role, len(share.Members), describeShareTarget(target))), nil
+}
+
+func (h *CommandHandler) unshareCalendar(args *model.CommandArgs, parameters string) (*model.CommandResponse, error) {
+	nameOrID, target := splitShareTarget(parameters)
+	if nameOrID == "" {
+		return nil, fmt.Errorf("please provide the calendar to stop sharing")
+	}
+
+	channelID, groupID, err := h.CalendarSharing.ResolveTarget(args.UserId, args.TeamId, args.ChannelId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	share, err := h.CalendarSharing.Unshare(args.UserId, nameOrID, channelID, groupID)
+	if err == ErrNotFound {
+		return ephemeralResponse(fmt.Sprintf("Calendar %q is not shared with %s.", nameOrID, describeShareTarget(target))), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Stopped sharing calendar **%s** with %s.", share.CalendarName, describeShareTarget(target))), nil
+}
+
+func (h *CommandHandler) listCalendarShares(args *model.CommandArgs, target string) (*model.CommandResponse, error) {
+	channelID, groupID, err := h.CalendarSharing.ResolveTarget(args.UserId, args.TeamId, args.ChannelId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	targetID := channelID
+	if groupID != "" {
+		targetID = groupID
+	}
+
+	shares, err := h.CalendarSharing.ListShares(targetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return ephemeralResponse(fmt.Sprintf("No calendar is shared with %s.", describeShareTarget(target))), nil
+	}
+
+	lines := []string{fmt.Sprintf("Calendars shared with %s:", describeShareTarget(target))}
+	for _, share := range shares {
+		lines = append(lines, fmt.Sprintf("* **%s** (`%s`) as %s, %d member(s) with access", share.CalendarName, share.CalendarID, share.Role, len(share.Members)))
+	}
+	return ephemeralResponse(strings.Join(lines, "\n")), nil
+}
+
+func (h *CommandHandler) createCalendar(c *client, name string) (*model.CommandResponse, error) {
+	if name == "" {
+		return nil, fmt.Errorf("please provide a name for the calendar")
+	}
+
+	cal, err := c.CreateCalendar("", &remote.Calendar{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Created calendar **%s** (`%s`).", cal.Name, cal.ID)), nil
+}
+
+// addCalendar adds a calendar to the user's calendar list and includes it in
+// their event views
+func (h *CommandHandler) addCalendar(c *client, idOrURL string) (*model.CommandResponse, error) {
+	if idOrURL == "" {
+		return nil, fmt.Errorf("please provide the ID or URL of the calendar to add")
+	}
+
+	cal, err := c.AddCalendar(idOrURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.SelectCalendar(cal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Added calendar **%s** (`%s`). Its events now show in your calendar views.", cal.Name, cal.ID)), nil
+}
+
+// deleteCalendar asks for confirmation before deleting a calendar, the
+// deletion itself happens in HandleDeleteCalendarAction
+func (h *CommandHandler) deleteCalendar(c *client, nameOrID string) (*model.CommandResponse, error) {
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []*Calendar{}
+	for _, cal := range calendars {
+		if cal.AccessRole == AccessRoleOwner && !cal.Primary {
+			owned = append(owned, cal)
+		}
+	}
+
+	if nameOrID == "" {
+		if len(owned) == 0 {
+			return ephemeralResponse("You don't own any calendar that can be deleted."), nil
+		}
+		lines := []string{"Calendars you can delete:"}
+		for _, cal := range owned {
+			lines = append(lines, fmt.Sprintf("* **%s** (`%s`)", cal.Name, cal.ID))
+		}
+		return ephemeralResponse(strings.Join(lines, "\n")), nil
+	}
+
+	var target *Calendar
+	for _, cal := range owned {
+		if cal.ID == nameOrID || strings.EqualFold(cal.Name, nameOrID) {
+			target = cal
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("no calendar you own matches %q", nameOrID)
+	}
+
+	actionURL := h.Env.Config.PluginURLPath + PathDeleteCalendarAction
+	resp := ephemeralResponse("")
+	resp.Attachments = []*model.SlackAttachment{{
+		Title: "Delete calendar " + target.Name + "?",
+		Text:  "The calendar and all its events will be deleted for everyone it is shared with. This cannot be undone.",
+		Actions: []*model.PostAction{
+			{
+				Name:  "Delete",
+				Type:  model.PostActionTypeButton,
+				Style: "danger",
+				Integration: &model.PostActionIntegration{
+					URL: actionURL,
+					Context: map[string]any{
+						"action":        actionConfirm,
+						"calendar_id":   target.ID,
+						"calendar_name": target.Name,
+					},
+				},
+			},
+			{
+				Name: "Cancel",
+				Type: model.PostActionTypeButton,
+				Integration: &model.PostActionIntegration{
+					URL: actionURL,
+					Context: map[string]any{
+						"action":        actionCancel,
+						"calendar_id":   target.ID,
+						"calendar_name": target.Name,
+					},
+				},
+			},
+		},
+	}}
+
+	return resp, nil
+}
+
+// HandleDeleteCalendarAction handles the buttons of the delete confirmation
+func (h *CommandHandler) HandleDeleteCalendarAction(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if mattermostUserID == "" || req.UserId != mattermostUserID {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	action, _ := req.Context["action"].(string)
+	calendarID, _ := req.Context["calendar_id"].(string)
+	calendarName, _ := req.Context["calendar_name"].(string)
+
+	message := "Calendar deletion cancelled."
+	if action == actionConfirm {
+		message = fmt.Sprintf("Deleted calendar **%s**.", calendarName)
+		if err := h.confirmDeleteCalendar(mattermostUserID, calendarID); err != nil {
+			message = fmt.Sprintf("Failed to delete calendar **%s**: %s", calendarName, err.Error())
+		}
+	}
+
+	httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{
+		Update: &model.Post{
+			Id:      req.PostId,
+			Message: message,
+		},
+	}, http.StatusOK)
+}
+
+func (h *CommandHandler) confirmDeleteCalendar(mattermostUserID, calendarID string) error {
+	c, err := makeUserClient(h.Env, mattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	err = c.DeleteCalendar("", calendarID)
+	if err != nil {
+		return err
+	}
+
+	if h.Store == nil {
+		return nil
+	}
+
+	settings, err := h.Store.LoadUserSettings(mattermostUserID)
+	if err != nil {
+		return err
+	}
+	if !settings.RemoveCalendar(calendarID) {
+		return nil
+	}
+
+	err = h.Store.StoreUserSettings(mattermostUserID, settings)
+	if err != nil {
+		return err
+	}
+	return c.SyncCalendarWatches()
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+// splitShareTarget splits the optional trailing ~channel or @group off the
+// arguments of the share commands
+func splitShareTarget(parameters string) (nameOrID, target string) {
+	i := strings.LastIndexFunc(parameters, unicode.IsSpace)
+	last := parameters[i+1:]
+	if !strings.HasPrefix(last, "~") && !strings.HasPrefix(last, "@") {
+		return parameters, ""
+	}
+	if i < 0 {
+		return "", last
+	}
+	return strings.TrimSpace(parameters[:i]), last
+}
+
+func describeShareTarget(target string) string {
+	if target == "" {
+		return "this channel"
+	}
+	return target
+}
+
+// splitCommand returns the first n words of a command and the remaining text,
+// so that free text arguments such as calendar names keep their spacing
+func splitCommand(command string, n int) (words []string, rest string) {
+	rest = strings.TrimSpace(command)
+	for len(words) < n && rest != "" {
+		i := strings.IndexFunc(rest, unicode.IsSpace)
+		if i < 0 {
+			words = append(words, rest)
+			return words, ""
+		}
+		words = append(words, rest[:i])
+		rest = strings.TrimSpace(rest[i:])
+	}
+	return words, rest
+}
@@ -0,0 +1,677 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	isoDatePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	clockPattern       = regexp.MustCompile(`^(\d{1,2})(?:(?:[:.]|h)(\d{2})|h)?(a\.?m\.?|p\.?m\.?)?$`)
+	meridiemPattern    = regexp.MustCompile(`^(a\.?m\.?|p\.?m\.?)$`)
+	dayOfMonthPattern  = regexp.MustCompile(`^(\d{1,2})(?:st|nd|rd|th|\.)?$`)
+	durationPattern    = regexp.MustCompile(`^(\d+(?:\.\d+)?)([a-z]*)`)
+	dateTimeSeparators = strings.NewReplacer(",", " ", "–", "-", "—", "-", "-feira", "")
+)
+
+// weekdayNames are the weekday names understood in English, German, French,
+// Spanish, Italian, Portuguese and Dutch, with and without accents
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday, "sonntag": time.Sunday, "dimanche": time.Sunday, "domingo": time.Sunday, "domenica": time.Sunday, "zondag": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday, "montag": time.Monday, "lundi": time.Monday, "lunes": time.Monday, "lunedì": time.Monday, "lunedi": time.Monday, "segunda": time.Monday, "maandag": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday, "dienstag": time.Tuesday, "mardi": time.Tuesday, "martes": time.Tuesday, "martedì": time.Tuesday, "martedi": time.Tuesday, "terça": time.Tuesday, "terca": time.Tuesday, "dinsdag": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday, "mittwoch": time.Wednesday, "mercredi": time.Wednesday, "miércoles": time.Wednesday, "miercoles": time.Wednesday, "mercoledì": time.Wednesday, "mercoledi": time.Wednesday, "quarta": time.Wednesday, "woensdag": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "donnerstag": time.Thursday, "jeudi": time.Thursday, "jueves": time.Thursday, "giovedì": time.Thursday, "giovedi": time.Thursday, "quinta": time.Thursday, "donderdag": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday, "freitag": time.Friday, "vendredi": time.Friday, "viernes": time.Friday, "venerdì": time.Friday, "venerdi": time.Friday, "sexta": time.Friday, "vrijdag": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday, "samstag": time.Saturday, "sonnabend": time.Saturday, "samedi": time.Saturday, "sábado": time.Saturday, "sabado": time.Saturday, "sabato": time.Saturday, "zaterdag": time.Saturday,
+}
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+var durationUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// Words that only join the parts of an expression, such as "on Tue at 3pm"
+// or "am Dienstag um 15 Uhr". An am following a time is read as its am.
+var dateTimeFillers = map[string]bool{
+	"on": true, "at": true, "@": true, "from": true,
+	"am": true, "um": true, "uhr": true, "le": true, "à": true, "el": true, "las": true, "il": true, "op": true,
+}
+
+var rangeSeparators = map[string]bool{"-": true, "to": true, "until": true, "till": true}
+
+// DateTimeParser reads the dates and times of events the way people write
+// them, such as "tomorrow 9:30", "next Tue 2-3pm", "in 2 days for 45m" or
+// "viernes 10h". Everything is resolved in Location against the time Now
+// returns, which tests replace with a fixed clock.
+type DateTimeParser struct {
+	Location *time.Location
+	Now      func() time.Time
+
+	// WeekStart is the first day of the week, "next Tue" is the Tuesday of
+	// the week after the current one
+	WeekStart time.Weekday
+
+	// DefaultDuration is the length of events given with a start time only
+	DefaultDuration time.Duration
+}
+
+// EventTime is the time of an event read by a DateTimeParser. All-day events
+// start at midnight of their first day, and end at midnight after their last
+// day.
+type EventTime struct {
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+}
+
+// clockTime is a time of day as written, Meridiem is am, pm or empty
+type clockTime struct {
+	Hour     int
+	Minute   int
+	Meridiem string
+}
+
+// dateTimeExpr holds the parts of an expression found so far
+type dateTimeExpr struct {
+	Date     *time.Time
+	Start    *clockTime
+	End      *clockTime
+	Instant  *time.Time
+	Duration time.Duration
+}
+
+// NewDateTimeParser creates a parser for the given timezone, on the real
+// clock
+func NewDateTimeParser(loc *time.Location) *DateTimeParser {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &DateTimeParser{
+		Location:        loc,
+		Now:             time.Now,
+		WeekStart:       time.Sunday,
+		DefaultDuration: time.Hour,
+	}
+}
+
+// Parse reads the date, times and duration of an event. Without a date the
+// event is today, and without a time it lasts all day. Events given with a
+// start time only last DefaultDuration.
+func (p *DateTimeParser) Parse(text string) (*EventTime, error) {
+	expr, err := p.parseExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	if expr.End != nil && expr.Duration > 0 {
+		return nil, errors.New("give either an end time or a duration")
+	}
+
+	today := startOfDay(p.now())
+	date := today
+	if expr.Date != nil {
+		date = *expr.Date
+	}
+
+	if expr.Instant != nil {
+		if expr.Date != nil || expr.Start != nil {
+			return nil, errors.New("give either a date and time or a time from now")
+		}
+		return p.timedEvent(*expr.Instant, nil, expr.Duration), nil
+	}
+
+	if expr.Start == nil {
+		days := 1
+		if expr.Duration > 0 {
+			if expr.Duration%(24*time.Hour) != 0 {
+				return nil, errors.New("give a start time for durations shorter than days")
+			}
+			days = int(expr.Duration / (24 * time.Hour))
+		}
+		return &EventTime{Start: date, End: date.AddDate(0, 0, days), AllDay: true}, nil
+	}
+
+	start, end := resolveClockRange(*expr.Start, expr.End)
+	startTime := atClock(date, start)
+	var endTime *time.Time
+	if end != nil {
+		t := atClock(date, *end)
+		if !t.After(startTime) {
+			t = atClock(date.AddDate(0, 0, 1), *end)
+		}
+		endTime = &t
+	}
+	return p.timedEvent(startTime, endTime, expr.Duration), nil
+}
+
+// ParseDateTime reads a single date, or a date and time. A date alone is
+// returned at midnight, with allDay set.
+func (p *DateTimeParser) ParseDateTime(text string) (t time.Time, allDay bool, err error) {
+	expr, err := p.parseExpr(text)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if expr.End != nil || expr.Duration > 0 {
+		return time.Time{}, false, errors.New("expected a single date or time")
+	}
+
+	if expr.Instant != nil {
+		if expr.Date != nil || expr.Start != nil {
+			return time.Time{}, false, errors.New("give either a date and time or a time from now")
+		}
+		return *expr.Instant, false, nil
+	}
+
+	date := startOfDay(p.now())
+	if expr.Date != nil {
+		date = *expr.Date
+	}
+	if expr.Start == nil {
+		return date, true, nil
+	}
+	start, _ := resolveClockRange(*expr.Start, nil)
+	return atClock(date, start), false, nil
+}
+
+// ParseDate reads a date without a time, returned at midnight
+func (p *DateTimeParser) ParseDate(text string) (time.Time, error) {
+	t, allDay, err := p.ParseDateTime(text)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !allDay {
+		return time.Time{}, errors.New("expected a date without a time")
+	}
+	return t, nil
+}
+
+// ParseClock reads a time of day, such as "15:04", "3:04 PM" or "3pm"
+func (p *DateTimeParser) ParseClock(text string) (hour, minute int, err error) {
+	tokens := tokenizeDateTime(text)
+	clock, n, err := readClock(tokens)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n == 0 || n != len(tokens) {
+		return 0, 0, errors.Errorf("cannot read time %q", text)
+	}
+	resolved, _ := resolveClockRange(clock, nil)
+	return resolved.Hour, resolved.Minute, nil
+}
+
+func (p *DateTimeParser) now() time.Time {
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+	return now().In(p.location())
+}
+
+func (p *DateTimeParser) location() *time.Location {
+	if p.Location == nil {
+		return time.UTC
+	}
+	return p.Location
+}
+
+func (p *DateTimeParser) timedEvent(start time.Time, end *time.Time, duration time.Duration) *EventTime {
+	switch {
+	case end != nil:
+		return &EventTime{Start: start, End: *end}
+	case duration > 0:
+		return &EventTime{Start: start, End: start.Add(duration)}
+	default:
+		return &EventTime{Start: start, End: start.Add(p.DefaultDuration)}
+	}
+}
+
+// parseExpr finds the date, time and duration parts of an expression, in
+// any order
+func (p *DateTimeParser) parseExpr(text string) (*dateTimeExpr, error) {
+	tokens := tokenizeDateTime(text)
+	if len(tokens) == 0 {
+		return nil, errors.New("no date or time given")
+	}
+
+	now := p.now()
+	expr := &dateTimeExpr{}
+	for i := 0; i < len(tokens); {
+		rest := tokens[i:]
+		if dateTimeFillers[rest[0]] {
+			i++
+			continue
+		}
+
+		if rest[0] == "in" {
+			n, err := p.readRelative(rest[1:], now, expr)
+			if err != nil {
+				return nil, err
+			}
+			i += 1 + n
+			continue
+		}
+
+		if rest[0] == "for" {
+			duration, _, n, err := readDuration(rest[1:])
+			if err != nil {
+				return nil, err
+			}
+			if expr.Duration > 0 {
+				return nil, errors.New("the duration is given twice")
+			}
+			expr.Duration = duration
+			i += 1 + n
+			continue
+		}
+
+		date, n, err := p.readDate(rest, now)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if expr.Date != nil {
+				return nil, errors.New("the date is given twice")
+			}
+			expr.Date = &date
+			i += n
+			continue
+		}
+
+		n, err = readTimeRange(rest, expr)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			i += n
+			continue
+		}
+
+		return nil, errors.Errorf("cannot read %q", rest[0])
+	}
+
+	return expr, nil
+}
+
+// readDate reads a date at the start of tokens, returning the number of
+// tokens read, zero when there is no date
+func (p *DateTimeParser) readDate(tokens []string, now time.Time) (time.Time, int, error) {
+	today := startOfDay(now)
+
+	switch tokens[0] {
+	case "today", "tonight":
+		return today, 1, nil
+	case "tomorrow", "tmrw", "tmr":
+		return today.AddDate(0, 0, 1), 1, nil
+	case "day":
+		if len(tokens) >= 3 && tokens[1] == "after" && tokens[2] == "tomorrow" {
+			return today.AddDate(0, 0, 2), 3, nil
+		}
+	case "next", "this":
+		if len(tokens) < 2 {
+			return time.Time{}, 0, errors.Errorf("expected a weekday after %q", tokens[0])
+		}
+		weekday, ok := weekdayNames[tokens[1]]
+		if !ok {
+			return time.Time{}, 0, errors.Errorf("expected a weekday after %q", tokens[0])
+		}
+		if tokens[0] == "this" {
+			return nextWeekday(today, weekday), 2, nil
+		}
+		weekFirst := today.AddDate(0, 0, 7-int((7+today.Weekday()-p.WeekStart)%7))
+		return weekFirst.AddDate(0, 0, int((7+weekday-p.WeekStart)%7)), 2, nil
+	}
+
+	if weekday, ok := weekdayNames[tokens[0]]; ok {
+		return nextWeekday(today, weekday), 1, nil
+	}
+
+	if isoDatePattern.MatchString(tokens[0]) {
+		date, err := time.ParseInLocation("2006-01-02", tokens[0], p.location())
+		if err != nil {
+			return time.Time{}, 0, errors.Errorf("invalid date %q", tokens[0])
+		}
+		return date, 1, nil
+	}
+
+	// Oct 20, or 20 Oct
+	if len(tokens) >= 2 {
+		month, day, ok := readMonthDay(tokens[0], tokens[1])
+		if !ok {
+			month, day, ok = readMonthDay(tokens[1], tokens[0])
+		}
+		if ok {
+			date := time.Date(today.Year(), month, day, 0, 0, 0, 0, today.Location())
+			if date.Day() != day {
+				return time.Time{}, 0, errors.Errorf("invalid date %q", tokens[0]+" "+tokens[1])
+			}
+			if date.Before(today) {
+				date = date.AddDate(1, 0, 0)
+			}
+			return date, 2, nil
+		}
+	}
+
+	return time.Time{}, 0, nil
+}
+
+// readRelative reads the "2 days" of "in 2 days". Days and weeks set the
+// date, hours and minutes the start time, even when they add up to days as
+// in "in 48 hours".
+func (p *DateTimeParser) readRelative(tokens []string, now time.Time, expr *dateTimeExpr) (int, error) {
+	duration, unit, n, err := readDuration(tokens)
+	if err != nil {
+		return 0, err
+	}
+
+	if unit >= 24*time.Hour && duration%(24*time.Hour) == 0 {
+		if expr.Date != nil {
+			return 0, errors.New("the date is given twice")
+		}
+		date := startOfDay(now).AddDate(0, 0, int(duration/(24*time.Hour)))
+		expr.Date = &date
+		return n, nil
+	}
+
+	if expr.Instant != nil {
+		return 0, errors.New("the time is given twice")
+	}
+	instant := now.Add(duration).Truncate(time.Minute)
+	expr.Instant = &instant
+	return n, nil
+}
+
+// readTimeRange reads a time or a range of times, such as "3pm", "2-3pm" or
+// "14:00 to 15:30"
+func readTimeRange(tokens []string, expr *dateTimeExpr) (int, error) {
+	start, n, err := readClock(tokens)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if expr.Start != nil {
+		return 0, errors.New("the time is given twice")
+	}
+	expr.Start = &start
+
+	if n < len(tokens) && rangeSeparators[tokens[n]] {
+		end, m, err := readClock(tokens[n+1:])
+		if err != nil {
+			return 0, err
+		}
+		if m == 0 {
+			return 0, errors.Errorf("expected a time after %q", tokens[n])
+		}
+		expr.End = &end
+		n += 1 + m
+	}
+
+	return n, nil
+}
+
+// readClock reads a time of day at the start of tokens, with the am or pm
+// that may follow it
+func readClock(tokens []string) (clockTime, int, error) {
+	if len(tokens) == 0 {
+		return clockTime{}, 0, nil
+	}
+
+	switch tokens[0] {
+	case "noon", "midday":
+		return clockTime{Hour: 12}, 1, nil
+	case "midnight":
+		return clockTime{}, 1, nil
+	}
+
+	match := clockPattern.FindStringSubmatch(tokens[0])
+	if match == nil {
+		return clockTime{}, 0, nil
+	}
+
+	clock := clockTime{Meridiem: normalizeMeridiem(match[3])}
+	clock.Hour, _ = strconv.Atoi(match[1])
+	if match[2] != "" {
+		clock.Minute, _ = strconv.Atoi(match[2])
+	}
+
+	n := 1
+	if clock.Meridiem == "" && len(tokens) > 1 && meridiemPattern.MatchString(tokens[1]) {
+		clock.Meridiem = normalizeMeridiem(tokens[1])
+		n++
+	}
+
+	maxHour := 23
+	if clock.Meridiem != "" {
+		maxHour = 12
+	}
+	if clock.Hour > maxHour || clock.Minute > 59 || (clock.Meridiem != "" && clock.Hour == 0) {
+		return clockTime{}, 0, errors.Errorf("invalid time %q", strings.Join(tokens[:n], " "))
+	}
+
+	return clock, n, nil
+}
+
+// readDuration reads a duration such as "45m", "1h30", "1.5 hours", "2 days"
+// or "an hour", and the smallest unit it is written in
+func readDuration(tokens []string) (time.Duration, time.Duration, int, error) {
+	var total time.Duration
+	var smallest time.Duration
+	n := 0
+	addUnit := func(unit time.Duration) {
+		if smallest == 0 || unit < smallest {
+			smallest = unit
+		}
+	}
+
+	for n < len(tokens) {
+		tok := tokens[n]
+
+		if (tok == "an" || tok == "a") && n+1 < len(tokens) {
+			if unit, ok := durationUnits[tokens[n+1]]; ok {
+				total += unit
+				addUnit(unit)
+				n += 2
+				continue
+			}
+		}
+		if tok == "half" && n+2 < len(tokens) && (tokens[n+1] == "an" || tokens[n+1] == "a") && durationUnits[tokens[n+2]] == time.Hour {
+			total += 30 * time.Minute
+			addUnit(time.Minute)
+			n += 3
+			continue
+		}
+
+		value, unit, ok := readDurationToken(tok)
+		if !ok {
+			// A number whose unit is the next token, "45 minutes"
+			number, err := strconv.ParseFloat(tok, 64)
+			if err != nil || n+1 >= len(tokens) {
+				break
+			}
+			unit, ok = durationUnits[tokens[n+1]]
+			if !ok {
+				break
+			}
+			value = time.Duration(number * float64(unit))
+			n++
+		}
+		total += value
+		addUnit(unit)
+		n++
+	}
+
+	if n == 0 || total <= 0 {
+		return 0, 0, 0, errors.New("expected a duration, such as 45m or 2 hours")
+	}
+	return total, smallest, n, nil
+}
+
+// readDurationToken reads a duration written as one token, "45m", "1.5h" or
+// "1h30", and its smallest unit
+func readDurationToken(tok string) (time.Duration, time.Duration, bool) {
+	var total time.Duration
+	var previous, smallest time.Duration
+	for tok != "" {
+		match := durationPattern.FindStringSubmatch(tok)
+		if match == nil {
+			return 0, 0, false
+		}
+		number, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, 0, false
+		}
+
+		unit, ok := durationUnits[match[2]]
+		if match[2] == "" {
+			// The minutes of "1h30"
+			if previous != time.Hour {
+				return 0, 0, false
+			}
+			unit, ok = time.Minute, true
+		}
+		if !ok {
+			return 0, 0, false
+		}
+
+		total += time.Duration(number * float64(unit))
+		previous = unit
+		if smallest == 0 || unit < smallest {
+			smallest = unit
+		}
+		tok = tok[len(match[0]):]
+	}
+	return total, smallest, total > 0
+}
+
+// readMonthDay reads a month name and a day of the month
+func readMonthDay(monthTok, dayTok string) (time.Month, int, bool) {
+	month, ok := monthNames[strings.TrimSuffix(monthTok, ".")]
+	if !ok {
+		return 0, 0, false
+	}
+	match := dayOfMonthPattern.FindStringSubmatch(dayTok)
+	if match == nil {
+		return 0, 0, false
+	}
+	day, _ := strconv.Atoi(match[1])
+	if day < 1 || day > 31 {
+		return 0, 0, false
+	}
+	return month, day, true
+}
+
+// resolveClockRange converts the times of a range to the 24 hour clock. A
+// time without am or pm takes the one of the other time when that keeps the
+// start before the end, so that "2-3pm" is 14:00 to 15:00 and "11-1pm" is
+// 11:00 to 13:00.
+func resolveClockRange(start clockTime, end *clockTime) (clockTime, *clockTime) {
+	if end == nil {
+		return to24Hour(start), nil
+	}
+
+	s, e := start, *end
+	switch {
+	case s.Meridiem == "" && e.Meridiem != "" && s.Hour >= 1 && s.Hour <= 12:
+		s.Meridiem = e.Meridiem
+		if !clockBefore(to24Hour(s), to24Hour(e)) {
+			s.Meridiem = otherMeridiem(e.Meridiem)
+		}
+	case e.Meridiem == "" && s.Meridiem != "" && e.Hour >= 1 && e.Hour <= 12:
+		e.Meridiem = s.Meridiem
+		if !clockBefore(to24Hour(s), to24Hour(e)) {
+			e.Meridiem = otherMeridiem(s.Meridiem)
+		}
+	}
+
+	resolvedEnd := to24Hour(e)
+	return to24Hour(s), &resolvedEnd
+}
+
+func clockBefore(a, b clockTime) bool {
+	return a.Hour*60+a.Minute < b.Hour*60+b.Minute
+}
+
+func to24Hour(clock clockTime) clockTime {
+	switch clock.Meridiem {
+	case "am":
+		if clock.Hour == 12 {
+			clock.Hour = 0
+		}
+	case "pm":
+		if clock.Hour < 12 {
+			clock.Hour += 12
+		}
+	}
+	clock.Meridiem = ""
+	return clock
+}
+
+func normalizeMeridiem(meridiem string) string {
+	switch {
+	case meridiem == "":
+		return ""
+	case meridiem[0] == 'a':
+		return "am"
+	default:
+		return "pm"
+	}
+}
+
+func otherMeridiem(meridiem string) string {
+	if meridiem == "am" {
+		return "pm"
+	}
+	return "am"
+}
+
+// nextWeekday returns the first day on or after day that falls on weekday
+func nextWeekday(day time.Time, weekday time.Weekday) time.Time {
+	return day.AddDate(0, 0, int((7+weekday-day.Weekday())%7))
+}
+
+func atClock(date time.Time, clock clockTime) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), clock.Hour, clock.Minute, 0, 0, date.Location())
+}
+
+// tokenizeDateTime splits an expression into lower case words, with the
+// dashes of ranges as words of their own
+func tokenizeDateTime(text string) []string {
+	tokens := []string{}
+	for _, field := range strings.Fields(dateTimeSeparators.Replace(strings.ToLower(text))) {
+		if isoDatePattern.MatchString(field) {
+			tokens = append(tokens, field)
+			continue
+		}
+		for i, part := range strings.Split(field, "-") {
+			if i > 0 {
+				tokens = append(tokens, "-")
+			}
+			if part != "" {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+	return tokens
+}
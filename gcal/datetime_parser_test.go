@@ -0,0 +1,138 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDateTimeParser(t *testing.T) (*DateTimeParser, *time.Location) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	p := NewDateTimeParser(berlin)
+	p.WeekStart = time.Monday
+	p.Now = func() time.Time {
+		// A Friday
+		return time.Date(2026, time.October, 16, 10, 15, 30, 0, berlin)
+	}
+	return p, berlin
+}
+
+func TestDateTimeParserParse(t *testing.T) {
+	p, berlin := newTestDateTimeParser(t)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+
+	for _, tc := range []struct {
+		Text           string
+		ExpectedStart  time.Time
+		ExpectedEnd    time.Time
+		ExpectedAllDay bool
+	}{
+		{Text: "tomorrow 9:30", ExpectedStart: at(17, 9, 30), ExpectedEnd: at(17, 10, 30)},
+		{Text: "3pm tomorrow", ExpectedStart: at(17, 15, 0), ExpectedEnd: at(17, 16, 0)},
+		{Text: "next Tue 2-3pm", ExpectedStart: at(20, 14, 0), ExpectedEnd: at(20, 15, 0)},
+		{Text: "this wed at 10am", ExpectedStart: at(21, 10, 0), ExpectedEnd: at(21, 11, 0)},
+		{Text: "on Friday from 2pm to 3:30", ExpectedStart: at(16, 14, 0), ExpectedEnd: at(16, 15, 30)},
+		{Text: "in 2 days at 9 for 45m", ExpectedStart: at(18, 9, 0), ExpectedEnd: at(18, 9, 45)},
+		{Text: "in 90 minutes", ExpectedStart: at(16, 11, 45), ExpectedEnd: at(16, 12, 45)},
+		{Text: "in 24h", ExpectedStart: at(17, 10, 15), ExpectedEnd: at(17, 11, 15)},
+		{Text: "in 48 hours", ExpectedStart: at(18, 10, 15), ExpectedEnd: at(18, 11, 15)},
+		{Text: "in a week", ExpectedStart: at(23, 0, 0), ExpectedEnd: at(24, 0, 0), ExpectedAllDay: true},
+		{Text: "11-1pm", ExpectedStart: at(16, 11, 0), ExpectedEnd: at(16, 13, 0)},
+		{Text: "22:00–01:00", ExpectedStart: at(16, 22, 0), ExpectedEnd: at(17, 1, 0)},
+		{Text: "tomorrow 9 a.m. for 1h30", ExpectedStart: at(17, 9, 0), ExpectedEnd: at(17, 10, 30)},
+		{Text: "Oct 20, 3:30 PM for an hour", ExpectedStart: at(20, 15, 30), ExpectedEnd: at(20, 16, 30)},
+		{Text: "viernes 10h", ExpectedStart: at(16, 10, 0), ExpectedEnd: at(16, 11, 0)},
+		{Text: "am Dienstag um 15 Uhr", ExpectedStart: at(20, 15, 0), ExpectedEnd: at(20, 16, 0)},
+		{Text: "mercredi 14h30 for 1.5 hours", ExpectedStart: at(21, 14, 30), ExpectedEnd: at(21, 16, 0)},
+		{Text: "segunda-feira noon", ExpectedStart: at(19, 12, 0), ExpectedEnd: at(19, 13, 0)},
+		{Text: "20th oct", ExpectedStart: at(20, 0, 0), ExpectedEnd: at(21, 0, 0), ExpectedAllDay: true},
+		{Text: "2026-10-24 for 2 days", ExpectedStart: at(24, 0, 0), ExpectedEnd: at(26, 0, 0), ExpectedAllDay: true},
+		{Text: "today", ExpectedStart: at(16, 0, 0), ExpectedEnd: at(17, 0, 0), ExpectedAllDay: true},
+	} {
+		t.Run(tc.Text, func(t *testing.T) {
+			eventTime, err := p.Parse(tc.Text)
+			require.NoError(t, err)
+			require.True(t, tc.ExpectedStart.Equal(eventTime.Start), "start %s", eventTime.Start)
+			require.True(t, tc.ExpectedEnd.Equal(eventTime.End), "end %s", eventTime.End)
+			require.Equal(t, tc.ExpectedAllDay, eventTime.AllDay)
+		})
+	}
+}
+
+func TestDateTimeParserParseErrors(t *testing.T) {
+	p, _ := newTestDateTimeParser(t)
+
+	for _, text := range []string{
+		"",
+		"someday",
+		"next week",
+		"25:00",
+		"13pm",
+		"tomorrow today",
+		"2-3pm for 1h",
+		"tomorrow for 45m",
+		"tomorrow in 2 hours",
+		"feb 30",
+	} {
+		t.Run(text, func(t *testing.T) {
+			_, err := p.Parse(text)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDateTimeParserParseClock(t *testing.T) {
+	p, _ := newTestDateTimeParser(t)
+
+	for _, tc := range []struct {
+		Text           string
+		ExpectedHour   int
+		ExpectedMinute int
+		Error          bool
+	}{
+		{Text: "15:04", ExpectedHour: 15, ExpectedMinute: 4},
+		{Text: "3:04 PM", ExpectedHour: 15, ExpectedMinute: 4},
+		{Text: "3:04PM", ExpectedHour: 15, ExpectedMinute: 4},
+		{Text: "12am", ExpectedHour: 0},
+		{Text: "12 pm", ExpectedHour: 12},
+		{Text: "noon", ExpectedHour: 12},
+		{Text: "9h15", ExpectedHour: 9, ExpectedMinute: 15},
+		{Text: "tomorrow 9:00", Error: true},
+		{Text: "9:75", Error: true},
+	} {
+		t.Run(tc.Text, func(t *testing.T) {
+			hour, minute, err := p.ParseClock(tc.Text)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.ExpectedHour, hour)
+			require.Equal(t, tc.ExpectedMinute, minute)
+		})
+	}
+}
+
+func TestDateTimeParserNextWeekday(t *testing.T) {
+	p, berlin := newTestDateTimeParser(t)
+
+	// With weeks starting on Sunday, the next week of a Friday starts
+	// in two days
+	p.WeekStart = time.Sunday
+	date, err := p.ParseDate("next Sunday")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, time.October, 18, 0, 0, 0, 0, berlin), date)
+
+	p.WeekStart = time.Monday
+	date, err = p.ParseDate("next Sunday")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, time.October, 25, 0, 0, 0, 0, berlin), date)
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
@@ -14,22 +15,160 @@ import (
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
-func (c *client) GetEvent(remoteUserID, eventID string) (*remote.Event, error) {
-	return nil, errors.New("gcal GetEvent not implemented")
+const (
+	EventChangeAdded     = "added"
+	EventChangeUpdated   = "updated"
+	EventChangeCancelled = "cancelled"
+
+	googleEventStatusCancelled = "cancelled"
+)
+
+// Who Google notifies about a change of an event
+const (
+	SendUpdatesAll          = "all"
+	SendUpdatesExternalOnly = "externalOnly"
+	SendUpdatesNone         = "none"
+)
+
+// isValidSendUpdates reports whether a value is accepted for sendUpdates
+func isValidSendUpdates(sendUpdates string) bool {
+	return sendUpdates == SendUpdatesAll || sendUpdates == SendUpdatesExternalOnly || sendUpdates == SendUpdatesNone
+}
+
+// EventChange is an event that was added, changed or cancelled on a calendar
+type EventChange struct {
+	*remote.Event
+	Type    string
+	Updated time.Time
+}
+
+// GetEvent returns an event of the user's primary or selected calendars
+func (c *client) GetEvent(_, eventID string) (*remote.Event, error) {
+	evt, _, err := c.FindEvent("", eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertGCalEventToRemoteEvent(evt), nil
+}
+
+// FindEvent returns an event with the calendar list entry of its calendar.
+// Event IDs are only unique within a calendar, so without a calendar ID the
+// event is looked up in the primary calendar, then in the selected calendars.
+func (c *client) FindEvent(calendarID, eventID string) (*calendar.Event, *Calendar, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "gcal FindEvent, error creating service")
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	candidates := []*Calendar{}
+	if calendarID != "" {
+		cal := findCalendar(calendars, calendarID)
+		if cal == nil {
+			return nil, nil, errors.Errorf("calendar %s is not in your calendar list", calendarID)
+		}
+		candidates = append(candidates, cal)
+	} else {
+		settings, err := c.loadUserSettings()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "gcal FindEvent, error loading user settings")
+		}
+		if primary := findCalendar(calendars, defaultCalendarName); primary != nil {
+			candidates = append(candidates, primary)
+		}
+		for _, cal := range filterSelectedCalendars(calendars, settings.SelectedCalendarIDs) {
+			if !cal.Primary {
+				candidates = append(candidates, cal)
+			}
+		}
+	}
+
+	for _, cal := range candidates {
+		evt, err := service.Events.Get(cal.ID, eventID).Do()
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "gcal FindEvent, error getting event")
+		}
+		return evt, cal, nil
+	}
+
+	return nil, nil, ErrNotFound
 }
 
-// CreateEvent creates a calendar event
+// getRecurrence returns the recurrence rules of an event, which instances of
+// recurring events only carry on their series
+func (c *client) getRecurrence(calendarID string, evt *calendar.Event) ([]string, error) {
+	if evt.RecurringEventId == "" {
+		return evt.Recurrence, nil
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal getRecurrence, error creating service")
+	}
+
+	series, err := service.Events.Get(calendarID, evt.RecurringEventId).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal getRecurrence, error getting recurring event")
+	}
+
+	return series.Recurrence, nil
+}
+
+// CreateEvent creates a calendar event on the user's default calendar
 func (c *client) CreateEvent(_ string, in *remote.Event) (*remote.Event, error) {
+	calendarID, err := c.DefaultEventCalendarID()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateEvent")
+	}
+
+	return c.CreateEventInCalendar(calendarID, in, nil)
+}
+
+// EventOptions are the Google event fields that remote.Event has no place for
+type EventOptions struct {
+	// Recurrence holds the RRULE, EXRULE, RDATE and EXDATE lines of a
+	// recurring event
+	Recurrence []string
+
+	// AddGoogleMeet creates a Google Meet conference for the event
+	AddGoogleMeet bool
+
+	// Reminders are the minutes before start the event reminds of itself.
+	// The calendar defaults are used when nil, and none when empty.
+	Reminders []int
+}
+
+// CreateEventInCalendar creates an event on the given calendar, opts may be nil
+func (c *client) CreateEventInCalendar(calendarID string, in *remote.Event, opts *EventOptions) (*remote.Event, error) {
 	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateEvent, error creating service")
 	}
 
-	evt := convertRemoteEventToGcalEvent(in)
+	evt := convertRemoteEventToGcalEvent(in, opts)
+
+	// Google needs a timezone to expand a recurring event
+	if len(evt.Recurrence) > 0 && evt.Start.DateTime != "" && evt.Start.TimeZone == "" {
+		settings, err := c.GetMailboxSettings("")
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal CreateEvent, error getting timezone of recurring event")
+		}
+		evt.Start.TimeZone = settings.TimeZone
+		evt.End.TimeZone = settings.TimeZone
+	}
 
 	resultEvent, err := service.Events.
-		Insert(defaultCalendarName, evt).
-		SendUpdates("all"). // Send notifications to all attendees.
+		Insert(calendarID, evt).
+		SendUpdates(SendUpdatesAll). // Send notifications to all attendees.
+		ConferenceDataVersion(1).    // Keep and create conference data.
 		Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateEvent")
@@ -38,52 +177,357 @@ func (c *client) CreateEvent(_ string, in *remote.Event) (*remote.Event, error)
 	return convertGCalEventToRemoteEvent(resultEvent), nil
 }
 
-func (c *client) AcceptEvent(remoteUserID, eventID string) error {
-	return errors.New("gcal AcceptEvent not implemented")
+// QuickAddEvent creates an event on the given calendar from a one line
+// description, such as "Lunch with Ana tomorrow 12:30 at Café", which Google
+// parses in the calendar's timezone
+func (c *client) QuickAddEvent(calendarID, text string) (*calendar.Event, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal QuickAddEvent, error creating service")
+	}
+
+	evt, err := service.Events.QuickAdd(calendarID, text).SendUpdates(SendUpdatesAll).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal QuickAddEvent")
+	}
+
+	return evt, nil
 }
 
-func (c *client) DeclineEvent(remoteUserID, eventID string) error {
-	return errors.New("gcal DeclineEvent not implemented")
+// ErrEventChanged is returned when an event changed since the ETag a change
+// was based on
+var ErrEventChanged = errors.New("the event was changed by someone else, reload it and try again")
+
+// PatchEvent changes the fields set in patch, leaving the others as they
+// are. With an ETag, the change is refused with ErrEventChanged when the
+// event changed since.
+func (c *client) PatchEvent(calendarID, eventID string, patch *calendar.Event, etag, sendUpdates string) (*calendar.Event, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal PatchEvent, error creating service")
+	}
+
+	call := service.Events.Patch(calendarID, eventID, patch).SendUpdates(sendUpdates)
+	if etag != "" {
+		call.Header().Set("If-Match", etag)
+	}
+
+	updated, err := call.Do()
+	if isPreconditionFailed(err) {
+		return nil, ErrEventChanged
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal PatchEvent, error updating event")
+	}
+
+	return updated, nil
 }
 
-func (c *client) TentativelyAcceptEvent(remoteUserID, eventID string) error {
-	return errors.New("gcal TentativelyAcceptEvent not implemented")
+// CancelEvent deletes an event, which cancels it for its guests. Deleting an
+// instance of a recurring event only cancels that occurrence.
+func (c *client) CancelEvent(calendarID, eventID, sendUpdates string) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelEvent, error creating service")
+	}
+
+	err = service.Events.Delete(calendarID, eventID).SendUpdates(sendUpdates).Do()
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelEvent, error deleting event")
+	}
+
+	return nil
 }
 
-func (c *client) GetEventsBetweenDates(_ string, start, end time.Time) (events []*remote.Event, err error) {
+// SplitRecurringEvent changes the occurrences of a series from one of its
+// instances on. The series is ended before the instance, and the following
+// occurrences become a new series with the patch applied. Changed instances
+// after the split are lost, as they are in Google Calendar. When the new
+// series cannot be created, the series gets its recurrence back.
+func (c *client) SplitRecurringEvent(calendarID string, series, instance, patch *calendar.Event, sendUpdates string) (*calendar.Event, error) {
 	ctx := context.Background()
 	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal CreateEvent, error creating service")
+		return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error creating service")
 	}
 
-	result, err := service.Events.
-		List(defaultCalendarName).
-		EventTypes("default").
-		TimeMin(start.Format(time.RFC3339)).
-		TimeMax(end.Format(time.RFC3339)).
-		OrderBy("startTime").
-		SingleEvents(true).
-		ShowDeleted(false).
-		ShowHiddenInvitations(false).
-		Context(ctx).
+	until, err := untilBefore(instance.OriginalStartTime)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error reading original start")
+	}
+
+	// A series with a count keeps its total number of occurrences
+	recurrence := series.Recurrence
+	if count := recurrenceCount(recurrence); count > 0 {
+		originalStart, err := parseEventDateTime(instance.OriginalStartTime)
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error reading original start")
+		}
+
+		before := 0
+		err = service.Events.
+			Instances(calendarID, series.Id).
+			ShowDeleted(true).
+			TimeMax(originalStart.Format(time.RFC3339)).
+			Pages(ctx, func(page *calendar.Events) error {
+				before += len(page.Items)
+				return nil
+			})
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error counting instances")
+		}
+		recurrence = withRecurrenceCount(recurrence, count-before)
+	}
+
+	next := newSeriesFrom(series, instance, patch)
+	next.Recurrence = recurrence
+
+	// The series is ended first, so that an event changed meanwhile fails the
+	// split before anything is created
+	truncated := &calendar.Event{Recurrence: truncateRecurrence(series.Recurrence, until)}
+	ended, err := c.PatchEvent(calendarID, series.Id, truncated, series.Etag, sendUpdates)
+	if err == ErrEventChanged {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error ending series")
+	}
+
+	created, err := service.Events.
+		Insert(calendarID, next).
+		SendUpdates(sendUpdates).
+		ConferenceDataVersion(1).
 		Do()
+	if err != nil {
+		// Give the series its following occurrences back
+		restored := &calendar.Event{Recurrence: series.Recurrence}
+		_, restoreErr := c.PatchEvent(calendarID, series.Id, restored, ended.Etag, sendUpdates)
+		if restoreErr != nil {
+			return nil, errors.Wrapf(err, "gcal SplitRecurringEvent, error creating following series, and error restoring series: %v", restoreErr)
+		}
+		return nil, errors.Wrap(err, "gcal SplitRecurringEvent, error creating following series")
+	}
+
+	return created, nil
+}
+
+// newSeriesFrom returns a copy of a series starting at one of its instances,
+// with the fields set in patch changed
+func newSeriesFrom(series, instance, patch *calendar.Event) *calendar.Event {
+	next := &calendar.Event{
+		Summary:      series.Summary,
+		Description:  series.Description,
+		Location:     series.Location,
+		ColorId:      series.ColorId,
+		Transparency: series.Transparency,
+		Visibility:   series.Visibility,
+		Reminders:    series.Reminders,
+		Start:        instance.Start,
+		End:          instance.End,
+
+		GuestsCanInviteOthers:   series.GuestsCanInviteOthers,
+		GuestsCanModify:         series.GuestsCanModify,
+		GuestsCanSeeOtherGuests: series.GuestsCanSeeOtherGuests,
+
+		// The following events keep the conference of the series
+		ConferenceData:     series.ConferenceData,
+		ExtendedProperties: series.ExtendedProperties,
+	}
+
+	for _, attendee := range series.Attendees {
+		next.Attendees = append(next.Attendees, &calendar.EventAttendee{
+			Email:    attendee.Email,
+			Optional: attendee.Optional,
+			Resource: attendee.Resource,
+		})
+	}
+
+	if patch.Summary != "" {
+		next.Summary = patch.Summary
+	}
+	for _, field := range patch.ForceSendFields {
+		switch field {
+		case "Location":
+			next.Location = patch.Location
+		case "Description":
+			next.Description = patch.Description
+		case "Attendees":
+			next.Attendees = patch.Attendees
+		}
+	}
+	if patch.Start != nil {
+		next.Start = patch.Start
+		next.End = patch.End
+	}
+
+	// Google needs a timezone to expand a recurring event
+	if next.Start != nil && next.Start.DateTime != "" && next.Start.TimeZone == "" && series.Start != nil {
+		next.Start.TimeZone = series.Start.TimeZone
+		next.End.TimeZone = series.Start.TimeZone
+	}
+
+	return next
+}
+
+// AcceptEvent accepts an invitation, notifying the organizer
+func (c *client) AcceptEvent(_, eventID string) error {
+	_, _, err := c.RespondToEvent("", eventID, GoogleResponseStatusYes, "", SendUpdatesAll)
+	return err
+}
+
+// DeclineEvent declines an invitation, notifying the organizer
+func (c *client) DeclineEvent(_, eventID string) error {
+	_, _, err := c.RespondToEvent("", eventID, GoogleResponseStatusNo, "", SendUpdatesAll)
+	return err
+}
+
+// TentativelyAcceptEvent answers maybe to an invitation, notifying the organizer
+func (c *client) TentativelyAcceptEvent(_, eventID string) error {
+	_, _, err := c.RespondToEvent("", eventID, GoogleResponseStatusMaybe, "", SendUpdatesAll)
+	return err
+}
+
+// respondToEventAttempts is how often a response is tried when the event
+// keeps changing between reading and patching it
+const respondToEventAttempts = 3
+
+// RespondToEvent sets the user's response to an invitation, with an optional
+// comment for the organizer. An empty comment keeps the previous one.
+// sendUpdates is one of the SendUpdates values. A patch replaces the
+// attendees as a whole, so it is made against the ETag of the event read,
+// and the event is read again when someone else changed it meanwhile.
+func (c *client) RespondToEvent(calendarID, eventID, responseStatus, comment, sendUpdates string) (*calendar.Event, *Calendar, error) {
+	switch responseStatus {
+	case GoogleResponseStatusYes, GoogleResponseStatusNo, GoogleResponseStatusMaybe:
+	default:
+		return nil, nil, errors.Errorf("gcal RespondToEvent, invalid response %q", responseStatus)
+	}
+
+	for attempt := 0; attempt < respondToEventAttempts; attempt++ {
+		evt, cal, err := c.FindEvent(calendarID, eventID)
+		if err != nil {
+			return nil, nil, err
+		}
+		calendarID = cal.ID
+
+		var self *calendar.EventAttendee
+		for _, attendee := range evt.Attendees {
+			if attendee.Self {
+				self = attendee
+				break
+			}
+		}
+		if self == nil {
+			return nil, nil, errors.New("gcal RespondToEvent, you are not a guest of this event")
+		}
+		self.ResponseStatus = responseStatus
+		if comment != "" {
+			self.Comment = comment
+		}
+
+		updated, err := c.PatchEvent(cal.ID, evt.Id, &calendar.Event{Attendees: evt.Attendees}, evt.Etag, sendUpdates)
+		if err == ErrEventChanged {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "gcal RespondToEvent, error updating response")
+		}
+
+		return updated, cal, nil
+	}
+
+	return nil, nil, ErrEventChanged
+}
+
+// GetEventsBetweenDates returns the events of the user's primary calendar,
+// which the base plugin sets the user's status and sends its reminders from.
+// The other selected calendars hold the events of teams or of other people,
+// the events the user is invited to are on the primary calendar.
+func (c *client) GetEventsBetweenDates(_ string, start, end time.Time) (events []*remote.Event, err error) {
+	calendars, err := c.cachedCalendars()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventsBetweenDates, error listing calendars")
+	}
+	primary := findCalendar(calendars, defaultCalendarName)
+	if primary == nil {
+		return nil, errors.New("gcal GetEventsBetweenDates, no primary calendar")
+	}
+
+	calendarEvents, _, err := c.GetCalendarEvents([]*Calendar{primary}, start, end)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting list of events")
 	}
 
-	for _, evt := range result.Items {
-		events = append(events, convertGCalEventToRemoteEvent(evt))
+	now := time.Now()
+	for _, evt := range calendarEvents {
+		// The events with their own reminders are reminded of by
+		// EventReminders, the base plugin only gets them for its status sync
+		if evt.HasOwnReminders && evt.Start != nil && evt.Start.Time().After(now.Add(ownRemindersListedBefore)) {
+			continue
+		}
+		events = append(events, evt.Event)
 	}
 
 	return events, nil
 }
 
-func convertRemoteEventToGcalEvent(in *remote.Event) *calendar.Event {
+// GetEventChanges returns the events of a calendar that were added, changed or
+// cancelled since the given time
+func (c *client) GetEventChanges(calendarID string, since time.Time) ([]*EventChange, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventChanges, error creating service")
+	}
+
+	changes := []*EventChange{}
+	err = service.Events.
+		List(calendarID).
+		EventTypes("default").
+		UpdatedMin(since.Format(time.RFC3339)).
+		ShowDeleted(true).
+		Pages(ctx, func(page *calendar.Events) error {
+			for _, evt := range page.Items {
+				updated, _ := time.Parse(time.RFC3339, evt.Updated)
+				changes = append(changes, &EventChange{
+					Event:   convertGCalEventToRemoteEvent(evt),
+					Type:    getEventChangeType(evt, since),
+					Updated: updated,
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventChanges, error listing events")
+	}
+
+	return changes, nil
+}
+
+// getEventChangeType tells new events from updated ones by their creation time
+func getEventChangeType(evt *calendar.Event, since time.Time) string {
+	if evt.Status == googleEventStatusCancelled {
+		return EventChangeCancelled
+	}
+
+	created, err := time.Parse(time.RFC3339, evt.Created)
+	if err == nil && !created.Before(since) {
+		return EventChangeAdded
+	}
+
+	return EventChangeUpdated
+}
+
+func convertRemoteEventToGcalEvent(in *remote.Event, opts *EventOptions) *calendar.Event {
 	out := &calendar.Event{}
 	out.Summary = in.Subject
-	out.Start = convertRemoteDateTimeToGcalEventDateTime(in.Start)
-	out.End = convertRemoteDateTimeToGcalEventDateTime(in.End)
+	if in.IsAllDay {
+		out.Start = convertRemoteDateToGcalEventDate(in.Start)
+		out.End = convertRemoteDateToGcalEventDate(in.End)
+	} else {
+		out.Start = convertRemoteDateTimeToGcalEventDateTime(in.Start)
+		out.End = convertRemoteDateTimeToGcalEventDateTime(in.End)
+	}
 	if in.Body != nil {
 		out.Description = in.Body.Content
 	}
@@ -92,6 +536,20 @@ func convertRemoteEventToGcalEvent(in *remote.Event) *calendar.Event {
 		out.Location = in.Location.DisplayName
 	}
 
+	// Google only creates the conferences of Meet and of its add-ons, the
+	// link of another call is kept in the extended properties. It is also
+	// the location when there is none, which calendar apps show as a link.
+	if in.Conference != nil && in.Conference.URL != "" {
+		out.ExtendedProperties = videoCallProperties(in.Conference)
+		if out.Location == "" {
+			out.Location = in.Conference.URL
+		}
+	}
+
+	if in.ReminderMinutesBeforeStart > 0 {
+		out.Reminders = newEventReminders([]int{in.ReminderMinutesBeforeStart})
+	}
+
 	for _, attendee := range in.Attendees {
 		outAttendee := &calendar.EventAttendee{
 			Id: attendee.RemoteID,
@@ -102,6 +560,25 @@ func convertRemoteEventToGcalEvent(in *remote.Event) *calendar.Event {
 		out.Attendees = append(out.Attendees, outAttendee)
 	}
 
+	if opts != nil {
+		out.Recurrence = opts.Recurrence
+
+		if opts.Reminders != nil {
+			out.Reminders = newEventReminders(opts.Reminders)
+		}
+
+		if opts.AddGoogleMeet {
+			out.ConferenceData = &calendar.ConferenceData{
+				CreateRequest: &calendar.CreateConferenceRequest{
+					RequestId: model.NewId(),
+					ConferenceSolutionKey: &calendar.ConferenceSolutionKey{
+						Type: googleMeetConferenceType,
+					},
+				},
+			}
+		}
+	}
+
 	return out
 }
 
@@ -118,3 +595,28 @@ func convertRemoteDateTimeToGcalEventDateTime(in *remote.DateTime) *calendar.Eve
 
 	return out
 }
+
+// convertRemoteDateToGcalEventDate returns the date of an all-day event,
+// which carries no time or timezone
+func convertRemoteDateToGcalEventDate(in *remote.DateTime) *calendar.EventDateTime {
+	return &calendar.EventDateTime{
+		Date: in.Time().Format("2006-01-02"),
+	}
+}
+
+// newEventReminders returns the reminders of an event reminding of itself
+// the given minutes before start, in place of the calendar defaults
+func newEventReminders(minutes []int) *calendar.EventReminders {
+	reminders := &calendar.EventReminders{
+		Overrides:       []*calendar.EventReminder{},
+		ForceSendFields: []string{"UseDefault", "Overrides"},
+	}
+	for _, m := range minutes {
+		reminders.Overrides = append(reminders.Overrides, &calendar.EventReminder{
+			Method:          googleReminderPopup,
+			Minutes:         int64(m),
+			ForceSendFields: []string{"Minutes"},
+		})
+	}
+	return reminders
+}
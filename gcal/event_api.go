@@ -0,0 +1,737 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
+)
+
+// PathEventPrefix starts the paths of the single event API, /api/v1/events/{id}
+const PathEventPrefix = "/api/v1/events/"
+
+// EventDetailDTO is an event with everything the detail view shows
+type EventDetailDTO struct {
+	*EventDTO
+
+	Status     string   `json:"status,omitempty"`
+	ETag       string   `json:"etag,omitempty"`
+	Created    string   `json:"created,omitempty"`
+	Updated    string   `json:"updated,omitempty"`
+	Recurrence []string `json:"recurrence,omitempty"`
+
+	// ResponseStatus is the user's own response, empty when the user is
+	// not an attendee
+	ResponseStatus string `json:"responseStatus,omitempty"`
+
+	Attendees      []*AttendeeDTO          `json:"attendees"`
+	ConferenceName string                  `json:"conferenceName,omitempty"`
+	EntryPoints    []*ConferenceEntryPoint `json:"entryPoints,omitempty"`
+	Attachments    []*EventAttachmentDTO   `json:"attachments,omitempty"`
+}
+
+// AttendeeDTO is an event guest with their response
+type AttendeeDTO struct {
+	Email          string `json:"email"`
+	Name           string `json:"name,omitempty"`
+	ResponseStatus string `json:"responseStatus"`
+	Comment        string `json:"comment,omitempty"`
+	Optional       bool   `json:"optional,omitempty"`
+	Organizer      bool   `json:"organizer,omitempty"`
+	Resource       bool   `json:"resource,omitempty"`
+	Self           bool   `json:"self,omitempty"`
+}
+
+// ConferenceEntryPoint is a way to join the conference of an event
+type ConferenceEntryPoint struct {
+	Type     string `json:"type"`
+	URI      string `json:"uri"`
+	Label    string `json:"label,omitempty"`
+	PIN      string `json:"pin,omitempty"`
+	Passcode string `json:"passcode,omitempty"`
+}
+
+// EventAttachmentDTO is a file attached to an event
+type EventAttachmentDTO struct {
+	Title    string `json:"title"`
+	FileURL  string `json:"fileUrl"`
+	MimeType string `json:"mimeType,omitempty"`
+	IconLink string `json:"iconLink,omitempty"`
+}
+
+// RSVPRequest is the request body for responding to an invitation
+type RSVPRequest struct {
+	// Response is one of accepted, declined or tentative
+	Response string `json:"response"`
+	Comment  string `json:"comment,omitempty"`
+
+	// SendUpdates is one of all (the default), externalOnly or none
+	SendUpdates string `json:"send_updates,omitempty"`
+}
+
+// UpdateEventRequest is the request body for changing an event. Fields left
+// out are not changed, and an empty string clears the location or description.
+type UpdateEventRequest struct {
+	Subject     *string `json:"subject,omitempty"`
+	Location    *string `json:"location,omitempty"`
+	Description *string `json:"description,omitempty"`
+
+	// Start and End are RFC3339 times, or dates (2006-01-02) for all-day
+	// events with an exclusive end date. Moving only the start keeps the
+	// duration of the event.
+	Start *string `json:"start,omitempty"`
+	End   *string `json:"end,omitempty"`
+
+	// Attendees replaces the guest list, keeping the responses of the
+	// guests who stay
+	Attendees *[]string `json:"attendees,omitempty"`
+
+	// ETag is the version of the event the change is based on, the If-Match
+	// header can be used instead
+	ETag string `json:"etag,omitempty"`
+
+	// SendUpdates is one of all (the default), externalOnly or none
+	SendUpdates string `json:"send_updates,omitempty"`
+
+	// Scope is instance (the default), following or series for instances
+	// of recurring events. Following ends the series before the instance
+	// and starts a new series with the changes.
+	Scope string `json:"scope,omitempty"`
+}
+
+// Which occurrences of a recurring event are changed or cancelled
+const (
+	RecurringScopeInstance  = "instance"
+	RecurringScopeFollowing = "following"
+	RecurringScopeSeries    = "series"
+)
+
+// CancelEventRequest is the request body for cancelling an event, all the
+// fields are optional
+type CancelEventRequest struct {
+	// Notify sends a cancellation email to the guests, the default
+	Notify *bool `json:"notify,omitempty"`
+
+	// Message is added to the event description before the guests are
+	// notified, and to the channel post
+	Message string `json:"message,omitempty"`
+
+	// PostToChannel posts the cancellation to the channels linked to the
+	// calendar of the event
+	PostToChannel bool `json:"post_to_channel,omitempty"`
+
+	// Scope is instance (the default) or series for recurring events
+	Scope string `json:"scope,omitempty"`
+}
+
+// CancelEventResponse is the response for cancelling an event
+type CancelEventResponse struct {
+	EventID string `json:"eventId,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EventDetailResponse is the response for the single event API
+type EventDetailResponse struct {
+	Event *EventDetailDTO `json:"event,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// HandleEvent handles the requests on a single event, /api/v1/events/{id}.
+// The calendar_id query parameter avoids looking the event up in all the
+// selected calendars.
+func (h *EventsAPIHandler) HandleEvent(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Not authorized"}, http.StatusUnauthorized)
+		return
+	}
+
+	eventID, action := parseEventPath(r.URL.Path)
+	if eventID == "" {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Event ID is required"}, http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.getClient(mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	calendarID := r.URL.Query().Get("calendar_id")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.getEventDetail(w, c, calendarID, eventID)
+	case action == "rsvp" && r.Method == http.MethodPost:
+		h.respondToEvent(w, r, c, calendarID, eventID)
+	case action == "" && r.Method == http.MethodPatch:
+		h.updateEvent(w, r, c, calendarID, eventID)
+	case action == "" && r.Method == http.MethodDelete:
+		h.cancelEvent(w, r, c, mattermostUserID, calendarID, eventID)
+	default:
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *EventsAPIHandler) getEventDetail(w http.ResponseWriter, c *client, calendarID, eventID string) {
+	evt, cal, err := c.FindEvent(calendarID, eventID)
+	if err == ErrNotFound {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Event not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	httputils.WriteJSONResponse(w, &EventDetailResponse{Event: h.convertEventToDetailDTO(c, cal, evt)}, http.StatusOK)
+}
+
+func (h *EventsAPIHandler) respondToEvent(w http.ResponseWriter, r *http.Request, c *client, calendarID, eventID string) {
+	var req RSVPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+
+	if req.SendUpdates == "" {
+		req.SendUpdates = SendUpdatesAll
+	}
+	if !isValidSendUpdates(req.SendUpdates) {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "send_updates must be all, externalOnly or none"}, http.StatusBadRequest)
+		return
+	}
+	switch req.Response {
+	case GoogleResponseStatusYes, GoogleResponseStatusNo, GoogleResponseStatusMaybe:
+	default:
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "response must be accepted, declined or tentative"}, http.StatusBadRequest)
+		return
+	}
+
+	evt, cal, err := c.RespondToEvent(calendarID, eventID, req.Response, req.Comment, req.SendUpdates)
+	if err == ErrNotFound {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Event not found"}, http.StatusNotFound)
+		return
+	}
+	if err == ErrEventChanged {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	httputils.WriteJSONResponse(w, &EventDetailResponse{Event: h.convertEventToDetailDTO(c, cal, evt)}, http.StatusOK)
+}
+
+func (h *EventsAPIHandler) updateEvent(w http.ResponseWriter, r *http.Request, c *client, calendarID, eventID string) {
+	var req UpdateEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+
+	if req.ETag == "" {
+		req.ETag = r.Header.Get("If-Match")
+	}
+	if req.SendUpdates == "" {
+		req.SendUpdates = SendUpdatesAll
+	}
+	if !isValidSendUpdates(req.SendUpdates) {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "send_updates must be all, externalOnly or none"}, http.StatusBadRequest)
+		return
+	}
+
+	current, cal, err := c.FindEvent(calendarID, eventID)
+	if err == ErrNotFound {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "Event not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	if !cal.CanWrite() {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: "You are not allowed to change events of calendar " + cal.Name}, http.StatusForbidden)
+		return
+	}
+
+	if req.Scope == "" || current.RecurringEventId == "" {
+		req.Scope = RecurringScopeInstance
+	}
+
+	var updated *calendar.Event
+	switch req.Scope {
+	case RecurringScopeInstance:
+		updated, err = h.updateEventInstance(c, cal, current, &req)
+	case RecurringScopeSeries:
+		updated, err = h.updateEventSeries(c, cal, current, &req)
+	case RecurringScopeFollowing:
+		updated, err = h.updateFollowingEvents(c, cal, current, &req)
+	default:
+		err = errInvalidRequest{errors.New("scope must be instance, following or series")}
+	}
+	if err == ErrEventChanged {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusPreconditionFailed)
+		return
+	}
+	if _, ok := err.(errInvalidRequest); ok {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventDetailResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	httputils.WriteJSONResponse(w, &EventDetailResponse{Event: h.convertEventToDetailDTO(c, cal, updated)}, http.StatusOK)
+}
+
+// errInvalidRequest marks the errors caused by the request rather than by Google
+type errInvalidRequest struct {
+	error
+}
+
+func (h *EventsAPIHandler) updateEventInstance(c *client, cal *Calendar, current *calendar.Event, req *UpdateEventRequest) (*calendar.Event, error) {
+	patch, err := buildEventPatch(current, req)
+	if err != nil {
+		return nil, errInvalidRequest{err}
+	}
+
+	// Without an ETag from the client, the guest list merge is still
+	// protected against changes made since the event was read here
+	etag := req.ETag
+	if etag == "" {
+		etag = current.Etag
+	}
+
+	return c.PatchEvent(cal.ID, current.Id, patch, etag, req.SendUpdates)
+}
+
+// updateEventSeries applies the changes to the recurring event an instance
+// belongs to. Times move every occurrence by as much as the instance moves.
+func (h *EventsAPIHandler) updateEventSeries(c *client, cal *Calendar, instance *calendar.Event, req *UpdateEventRequest) (*calendar.Event, error) {
+	if req.ETag != "" && req.ETag != instance.Etag {
+		return nil, ErrEventChanged
+	}
+
+	series, _, err := c.FindEvent(cal.ID, instance.RecurringEventId)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := buildEventPatch(instance, req)
+	if err != nil {
+		return nil, errInvalidRequest{err}
+	}
+
+	if patch.Start != nil {
+		patch.Start, patch.End, err = shiftSeriesTimes(series, instance, patch.Start, patch.End)
+		if err != nil {
+			return nil, errInvalidRequest{err}
+		}
+	}
+
+	return c.PatchEvent(cal.ID, series.Id, patch, series.Etag, req.SendUpdates)
+}
+
+// updateFollowingEvents ends the series of an instance before it, and starts
+// a new series with the changes from the instance on
+func (h *EventsAPIHandler) updateFollowingEvents(c *client, cal *Calendar, instance *calendar.Event, req *UpdateEventRequest) (*calendar.Event, error) {
+	if req.ETag != "" && req.ETag != instance.Etag {
+		return nil, ErrEventChanged
+	}
+
+	series, _, err := c.FindEvent(cal.ID, instance.RecurringEventId)
+	if err != nil {
+		return nil, err
+	}
+
+	// From the first occurrence on is the whole series
+	seriesStart, err := parseEventDateTime(series.Start)
+	if err != nil {
+		return nil, err
+	}
+	originalStart, err := parseEventDateTime(instance.OriginalStartTime)
+	if err != nil {
+		return nil, err
+	}
+	if !originalStart.After(seriesStart) {
+		return h.updateEventSeries(c, cal, instance, req)
+	}
+
+	patch, err := buildEventPatch(instance, req)
+	if err != nil {
+		return nil, errInvalidRequest{err}
+	}
+
+	return c.SplitRecurringEvent(cal.ID, series, instance, patch, req.SendUpdates)
+}
+
+// shiftSeriesTimes moves the start and end of a series by as much as the new
+// times of one of its instances move it
+func shiftSeriesTimes(series, instance *calendar.Event, start, end *calendar.EventDateTime) (*calendar.EventDateTime, *calendar.EventDateTime, error) {
+	instanceAllDay := instance.Start != nil && instance.Start.Date != ""
+	if (start.Date != "") != instanceAllDay {
+		return nil, nil, errors.New("a whole series cannot switch between all-day and timed events, change the following events instead")
+	}
+
+	times := map[string]time.Time{}
+	for name, dt := range map[string]*calendar.EventDateTime{
+		"instanceStart": instance.Start,
+		"instanceEnd":   instance.End,
+		"seriesStart":   series.Start,
+		"seriesEnd":     series.End,
+		"start":         start,
+		"end":           end,
+	} {
+		t, err := parseEventDateTime(dt)
+		if err != nil {
+			return nil, nil, err
+		}
+		times[name] = t
+	}
+
+	timeZone := ""
+	if series.Start != nil {
+		timeZone = series.Start.TimeZone
+	}
+	newStart := times["seriesStart"].Add(times["start"].Sub(times["instanceStart"]))
+	newEnd := times["seriesEnd"].Add(times["end"].Sub(times["instanceEnd"]))
+	return toEventDateTime(newStart, instanceAllDay, timeZone), toEventDateTime(newEnd, instanceAllDay, timeZone), nil
+}
+
+func (h *EventsAPIHandler) cancelEvent(w http.ResponseWriter, r *http.Request, c *client, mattermostUserID, calendarID, eventID string) {
+	var req CancelEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+
+	if req.Scope == "" {
+		req.Scope = RecurringScopeInstance
+	}
+	if req.Scope != RecurringScopeInstance && req.Scope != RecurringScopeSeries {
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: "scope must be instance or series"}, http.StatusBadRequest)
+		return
+	}
+	sendUpdates := SendUpdatesAll
+	if req.Notify != nil && !*req.Notify {
+		sendUpdates = SendUpdatesNone
+	}
+
+	evt, cal, err := c.FindEvent(calendarID, eventID)
+	if err == ErrNotFound {
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: "Event not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	if !cal.CanWrite() || isGuest(evt) {
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: "Only the organizer can cancel this event, decline it instead"}, http.StatusForbidden)
+		return
+	}
+
+	target := evt
+	if req.Scope == RecurringScopeSeries && evt.RecurringEventId != "" {
+		target, _, err = c.FindEvent(cal.ID, evt.RecurringEventId)
+		if err != nil {
+			httputils.WriteJSONResponse(w, &CancelEventResponse{Error: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+	targetID := target.Id
+
+	// The linked channels get the cancellation with its message from here,
+	// their calendar syncs leave it out
+	postToChannels := req.PostToChannel && h.ChannelCalendars != nil
+	cancelled := false
+	if postToChannels {
+		h.ChannelCalendars.markPosted(cal.ID, targetID)
+		defer func() {
+			if !cancelled {
+				h.ChannelCalendars.forgetPosted(cal.ID, targetID)
+			}
+		}()
+	}
+
+	// Google has no cancellation note, the guests see it in the
+	// description of the cancelled event
+	var noted *calendar.Event
+	if req.Message != "" && sendUpdates != SendUpdatesNone {
+		description := "Cancelled: " + req.Message
+		if target.Description != "" {
+			description += "\n\n" + target.Description
+		}
+		noted, err = c.PatchEvent(cal.ID, targetID, &calendar.Event{Description: description}, target.Etag, SendUpdatesNone)
+		if err == ErrEventChanged {
+			httputils.WriteJSONResponse(w, &CancelEventResponse{Error: err.Error()}, http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			httputils.WriteJSONResponse(w, &CancelEventResponse{Error: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = c.CancelEvent(cal.ID, targetID, sendUpdates)
+	if err != nil {
+		// The event stays, without the note of a cancellation that did
+		// not happen
+		if noted != nil {
+			restore := &calendar.Event{Description: target.Description, ForceSendFields: []string{"Description"}}
+			if _, restoreErr := c.PatchEvent(cal.ID, targetID, restore, noted.Etag, SendUpdatesNone); restoreErr != nil {
+				h.Env.Logger.Warnf("gcal: failed to restore the description of an event that was not cancelled. err=%v", restoreErr)
+			}
+		}
+		httputils.WriteJSONResponse(w, &CancelEventResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	cancelled = true
+
+	if postToChannels {
+		h.ChannelCalendars.PostEventCancellation(mattermostUserID, cal.ID, convertGCalEventToRemoteEvent(evt), req.Message)
+	}
+
+	httputils.WriteJSONResponse(w, &CancelEventResponse{EventID: targetID}, http.StatusOK)
+}
+
+// isGuest reports whether the user is invited to an event organized by
+// someone else
+func isGuest(evt *calendar.Event) bool {
+	for _, attendee := range evt.Attendees {
+		if attendee.Self {
+			return !attendee.Organizer
+		}
+	}
+	return false
+}
+
+// buildEventPatch returns the patch that applies a change request to an event
+func buildEventPatch(current *calendar.Event, req *UpdateEventRequest) (*calendar.Event, error) {
+	patch := &calendar.Event{}
+
+	if req.Subject != nil {
+		if *req.Subject == "" {
+			return nil, errors.New("subject cannot be empty")
+		}
+		patch.Summary = *req.Subject
+	}
+	if req.Location != nil {
+		patch.Location = *req.Location
+		patch.ForceSendFields = append(patch.ForceSendFields, "Location")
+	}
+	if req.Description != nil {
+		patch.Description = *req.Description
+		patch.ForceSendFields = append(patch.ForceSendFields, "Description")
+	}
+
+	if req.Start != nil || req.End != nil {
+		start, end, err := patchEventTimes(current, req.Start, req.End)
+		if err != nil {
+			return nil, err
+		}
+		patch.Start = start
+		patch.End = end
+	}
+
+	if req.Attendees != nil {
+		existing := map[string]*calendar.EventAttendee{}
+		for _, attendee := range current.Attendees {
+			existing[strings.ToLower(attendee.Email)] = attendee
+		}
+
+		attendees := []*calendar.EventAttendee{}
+		for _, email := range *req.Attendees {
+			if attendee, ok := existing[strings.ToLower(email)]; ok {
+				attendees = append(attendees, attendee)
+				continue
+			}
+			attendees = append(attendees, &calendar.EventAttendee{Email: email})
+		}
+		patch.Attendees = attendees
+		patch.ForceSendFields = append(patch.ForceSendFields, "Attendees")
+	}
+
+	return patch, nil
+}
+
+// patchEventTimes returns the new start and end of an event. A value is
+// either an RFC3339 time or a date for all-day events.
+func patchEventTimes(current *calendar.Event, startValue, endValue *string) (*calendar.EventDateTime, *calendar.EventDateTime, error) {
+	currentStart, err := parseEventDateTime(current.Start)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentEnd, err := parseEventDateTime(current.End)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allDay := current.Start != nil && current.Start.Date != ""
+	start, end := currentStart, currentEnd
+
+	if startValue != nil {
+		start, allDay, err = parsePatchTime(*startValue)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid start")
+		}
+		end = start.Add(currentEnd.Sub(currentStart))
+	}
+	if endValue != nil {
+		var endAllDay bool
+		end, endAllDay, err = parsePatchTime(*endValue)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid end")
+		}
+		if endAllDay != allDay {
+			return nil, nil, errors.New("start and end must both be dates or both be times")
+		}
+	}
+	if !end.After(start) {
+		return nil, nil, errors.New("end must be after start")
+	}
+
+	timeZone := ""
+	if current.Start != nil {
+		timeZone = current.Start.TimeZone
+	}
+	return toEventDateTime(start, allDay, timeZone), toEventDateTime(end, allDay, timeZone), nil
+}
+
+func parsePatchTime(value string) (t time.Time, allDay bool, err error) {
+	t, err = time.Parse("2006-01-02", value)
+	if err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+func parseEventDateTime(dt *calendar.EventDateTime) (time.Time, error) {
+	if dt == nil {
+		return time.Time{}, errors.New("the event has no time")
+	}
+	if dt.Date != "" {
+		return time.Parse("2006-01-02", dt.Date)
+	}
+	return time.Parse(time.RFC3339, dt.DateTime)
+}
+
+// toEventDateTime converts a time to its Google form. Only one of date and
+// date time can be set, the other is nulled for the patch to switch between
+// timed and all-day events.
+func toEventDateTime(t time.Time, allDay bool, timeZone string) *calendar.EventDateTime {
+	if allDay {
+		return &calendar.EventDateTime{
+			Date:       t.Format("2006-01-02"),
+			NullFields: []string{"DateTime"},
+		}
+	}
+	return &calendar.EventDateTime{
+		DateTime:   t.Format(time.RFC3339),
+		TimeZone:   timeZone,
+		NullFields: []string{"Date"},
+	}
+}
+
+func (h *EventsAPIHandler) convertEventToDetailDTO(c *client, cal *Calendar, evt *calendar.Event) *EventDetailDTO {
+	dto := convertEventToDTO(convertGCalEventToRemoteEvent(evt))
+	setEventDTOCalendar(dto, cal)
+	if evt.ColorId != "" {
+		colors, err := c.GetEventColors()
+		if err != nil {
+			h.Env.Logger.Warnf("gcal: failed to get event colors. err=%v", err)
+		}
+		setEventDTOColor(dto, evt.ColorId, colors)
+	}
+	dto.RecurringEventID = evt.RecurringEventId
+
+	detail := &EventDetailDTO{
+		EventDTO:  dto,
+		Status:    evt.Status,
+		ETag:      evt.Etag,
+		Created:   evt.Created,
+		Updated:   evt.Updated,
+		Attendees: []*AttendeeDTO{},
+	}
+
+	recurrence, err := c.getRecurrence(cal.ID, evt)
+	if err != nil {
+		h.Env.Logger.Warnf("gcal: failed to get event recurrence. err=%v", err)
+	}
+	detail.Recurrence = recurrence
+
+	for _, attendee := range evt.Attendees {
+		detail.Attendees = append(detail.Attendees, &AttendeeDTO{
+			Email:          attendee.Email,
+			Name:           attendee.DisplayName,
+			ResponseStatus: attendee.ResponseStatus,
+			Comment:        attendee.Comment,
+			Optional:       attendee.Optional,
+			Organizer:      attendee.Organizer,
+			Resource:       attendee.Resource,
+			Self:           attendee.Self,
+		})
+		if attendee.Self {
+			detail.ResponseStatus = attendee.ResponseStatus
+		}
+	}
+
+	if evt.ConferenceData != nil {
+		if evt.ConferenceData.ConferenceSolution != nil {
+			detail.ConferenceName = evt.ConferenceData.ConferenceSolution.Name
+		}
+		for _, entryPoint := range evt.ConferenceData.EntryPoints {
+			detail.EntryPoints = append(detail.EntryPoints, &ConferenceEntryPoint{
+				Type:     entryPoint.EntryPointType,
+				URI:      entryPoint.Uri,
+				Label:    entryPoint.Label,
+				PIN:      entryPoint.Pin,
+				Passcode: entryPoint.Passcode,
+			})
+		}
+	} else if call := videoCallFromProperties(evt); call != nil {
+		detail.ConferenceName = call.Application
+		detail.EntryPoints = []*ConferenceEntryPoint{{
+			Type:  conferenceEntryPointVideo,
+			URI:   call.URL,
+			Label: call.URL,
+		}}
+	}
+
+	for _, attachment := range evt.Attachments {
+		detail.Attachments = append(detail.Attachments, &EventAttachmentDTO{
+			Title:    attachment.Title,
+			FileURL:  attachment.FileUrl,
+			MimeType: attachment.MimeType,
+			IconLink: attachment.IconLink,
+		})
+	}
+
+	return detail
+}
+
+// parseEventPath returns the event ID and the optional action of a single
+// event API path, such as /api/v1/events/{id}/rsvp
+func parseEventPath(path string) (eventID, action string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, PathEventPrefix), "/", 2)
+	eventID = parts[0]
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	return eventID, action
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestBuildEventPatch(t *testing.T) {
+	current := &calendar.Event{
+		Start: &calendar.EventDateTime{DateTime: "2024-05-06T10:00:00+02:00", TimeZone: "Europe/Paris"},
+		End:   &calendar.EventDateTime{DateTime: "2024-05-06T11:30:00+02:00", TimeZone: "Europe/Paris"},
+		Attendees: []*calendar.EventAttendee{
+			{Email: "alice@example.com", ResponseStatus: GoogleResponseStatusYes},
+			{Email: "bob@example.com", ResponseStatus: GoogleResponseStatusNo},
+		},
+	}
+
+	str := func(s string) *string { return &s }
+
+	t.Run("moving the start keeps the duration", func(t *testing.T) {
+		patch, err := buildEventPatch(current, &UpdateEventRequest{Start: str("2024-05-07T14:00:00+02:00")})
+		require.NoError(t, err)
+		require.Equal(t, "2024-05-07T14:00:00+02:00", patch.Start.DateTime)
+		require.Equal(t, "2024-05-07T15:30:00+02:00", patch.End.DateTime)
+		require.Equal(t, "Europe/Paris", patch.Start.TimeZone)
+	})
+
+	t.Run("dates turn the event into an all-day event", func(t *testing.T) {
+		patch, err := buildEventPatch(current, &UpdateEventRequest{Start: str("2024-05-07"), End: str("2024-05-09")})
+		require.NoError(t, err)
+		require.Equal(t, "2024-05-07", patch.Start.Date)
+		require.Equal(t, "2024-05-09", patch.End.Date)
+		require.Equal(t, []string{"DateTime"}, patch.Start.NullFields)
+	})
+
+	t.Run("end before start is rejected", func(t *testing.T) {
+		_, err := buildEventPatch(current, &UpdateEventRequest{End: str("2024-05-06T09:00:00+02:00")})
+		require.Error(t, err)
+	})
+
+	t.Run("remaining guests keep their response", func(t *testing.T) {
+		patch, err := buildEventPatch(current, &UpdateEventRequest{Attendees: &[]string{"Alice@example.com", "carol@example.com"}})
+		require.NoError(t, err)
+		require.Len(t, patch.Attendees, 2)
+		require.Equal(t, GoogleResponseStatusYes, patch.Attendees[0].ResponseStatus)
+		require.Equal(t, "carol@example.com", patch.Attendees[1].Email)
+		require.Empty(t, patch.Attendees[1].ResponseStatus)
+	})
+
+	t.Run("empty strings clear the location", func(t *testing.T) {
+		patch, err := buildEventPatch(current, &UpdateEventRequest{Location: str("")})
+		require.NoError(t, err)
+		require.Contains(t, patch.ForceSendFields, "Location")
+		require.Nil(t, patch.Start)
+	})
+}
+
+func TestCancelEventRestoresDescription(t *testing.T) {
+	g := newFakeGoogle(t, &calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner})
+	c, err := g.makeClient(newTestAPI(t))("user1")
+	require.NoError(t, err)
+
+	g.handle("GET calendars/me@example.com/events/evt", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Event{Id: "evt", Etag: `"etag-0"`, Description: "Agenda"})
+	})
+
+	type patchCall struct {
+		IfMatch     string
+		Description string
+	}
+	patches := []patchCall{}
+	g.handle("PATCH calendars/me@example.com/events/evt", func(w http.ResponseWriter, r *http.Request) {
+		patch := &calendar.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(patch))
+		patches = append(patches, patchCall{IfMatch: r.Header.Get("If-Match"), Description: patch.Description})
+		_ = json.NewEncoder(w).Encode(&calendar.Event{Id: "evt", Etag: fmt.Sprintf(`"etag-%d"`, len(patches)), Description: patch.Description})
+	})
+	g.handle("DELETE calendars/me@example.com/events/evt", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error":{"code":500,"message":"Backend Error"}}`, http.StatusInternalServerError)
+	})
+
+	h := &EventsAPIHandler{Env: newTestEnv(nil)}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"message":"Moved to next week"}`))
+	h.cancelEvent(rec, r, c, "user1", "", "evt")
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	require.Equal(t, []patchCall{
+		{IfMatch: `"etag-0"`, Description: "Cancelled: Moved to next week\n\nAgenda"},
+		{IfMatch: `"etag-1"`, Description: "Agenda"},
+	}, patches, "the note is made against the event read, and removed when the event is not cancelled")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	// DefaultMaxEventsPerCalendar is the cap on the events read from one
+	// calendar by one query, when the admin did not set one
+	DefaultMaxEventsPerCalendar = 2500
+
+	// defaultEventListPageSize keeps the pages small enough to be
+	// converted and released one at a time
+	defaultEventListPageSize = 250
+)
+
+// errEventLimitReached stops the paging of an event list once the cap is read
+var errEventLimitReached = errors.New("event limit reached")
+
+// EventListConfig is the event list part of the plugin settings
+type EventListConfig struct {
+	// MaxEventsPerCalendar caps the events read from one calendar by one
+	// query, so that a huge calendar or range does not exhaust the plugin
+	MaxEventsPerCalendar int
+}
+
+// LoadEventListConfig reads the event list settings of the plugin
+func LoadEventListConfig(api plugin.API) (*EventListConfig, error) {
+	conf := &EventListConfig{}
+	err := api.LoadPluginConfiguration(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal LoadEventListConfig, error loading configuration")
+	}
+	if conf.MaxEventsPerCalendar <= 0 {
+		conf.MaxEventsPerCalendar = DefaultMaxEventsPerCalendar
+	}
+	return conf, nil
+}
+
+// eachCalendarEvent calls fn with the events of a calendar between the two
+// dates in start time order, one page at a time, so that only one page of
+// the list is held in memory. It stops after limit events and reports
+// whether events were left out because of it.
+func eachCalendarEvent(ctx context.Context, service *calendar.Service, cal *Calendar, start, end time.Time, limit int, fn func(*CalendarEvent) error) (bool, error) {
+	if limit <= 0 {
+		limit = DefaultMaxEventsPerCalendar
+	}
+
+	read := 0
+	err := service.Events.
+		List(cal.ID).
+		EventTypes("default").
+		TimeMin(start.Format(time.RFC3339)).
+		TimeMax(end.Format(time.RFC3339)).
+		SingleEvents(true).
+		ShowDeleted(false).
+		ShowHiddenInvitations(false).
+		OrderBy("startTime").
+		MaxResults(eventListPageSize(limit)).
+		Pages(ctx, func(page *calendar.Events) error {
+			for _, event := range page.Items {
+				if read == limit {
+					return errEventLimitReached
+				}
+				read++
+
+				err := fn(convertGCalEventToCalendarEvent(event, cal))
+				if err != nil {
+					return err
+				}
+			}
+			if read == limit && page.NextPageToken != "" {
+				return errEventLimitReached
+			}
+			return nil
+		})
+	if err == errEventLimitReached {
+		return true, nil
+	}
+	return false, err
+}
+
+// eventListPageSize returns the page size to read up to limit events with
+func eventListPageSize(limit int) int64 {
+	if limit < defaultEventListPageSize {
+		return int64(limit)
+	}
+	return defaultEventListPageSize
+}
+
+func convertGCalEventToCalendarEvent(event *calendar.Event, cal *Calendar) *CalendarEvent {
+	calendarEvent := &CalendarEvent{
+		Event:    convertGCalEventToRemoteEvent(event),
+		Calendar: cal,
+		ColorID:  event.ColorId,
+
+		RecurringEventID: event.RecurringEventId,
+		ReminderMinutes:  cal.DefaultReminders,
+	}
+	if event.Reminders != nil && !event.Reminders.UseDefault {
+		calendarEvent.ReminderMinutes = popupReminderMinutes(event.Reminders.Overrides)
+		calendarEvent.HasOwnReminders = true
+	}
+	return calendarEvent
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestEventListPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Limit    int
+		Expected int64
+	}{
+		{Name: "limit below a page", Limit: 10, Expected: 10},
+		{Name: "default limit", Limit: DefaultMaxEventsPerCalendar, Expected: defaultEventListPageSize},
+		{Name: "limit of many pages", Limit: 100000, Expected: defaultEventListPageSize},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, eventListPageSize(tc.Limit))
+		})
+	}
+}
+
+func TestGetEventsForUserPages(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleWriter},
+	)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+	c.maxEventsPerCalendar = 2
+	require.NoError(t, c.store.StoreUserSettings("user1", &UserSettings{SelectedCalendarIDs: []string{"me@example.com", "team"}}))
+
+	// serveEvents lists the events ending after timeMin, as Google does
+	serveEvents := func(events ...*calendar.Event) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			timeMin, err := time.Parse(time.RFC3339, r.URL.Query().Get("timeMin"))
+			require.NoError(t, err)
+			items := []*calendar.Event{}
+			for _, event := range events {
+				if event.End.DateTime > timeMin.UTC().Format(time.RFC3339) {
+					items = append(items, event)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(&calendar.Events{Items: items})
+		}
+	}
+	event := func(id, start, end string) *calendar.Event {
+		return &calendar.Event{
+			Id:    id,
+			Start: &calendar.EventDateTime{DateTime: "2024-03-04T" + start + ":00Z"},
+			End:   &calendar.EventDateTime{DateTime: "2024-03-04T" + end + ":00Z"},
+		}
+	}
+	g.handle("GET calendars/me@example.com/events", serveEvents(
+		event("nine", "09:00", "09:30"),
+		event("ten", "10:00", "10:30"),
+		event("eleven", "11:00", "11:30"),
+	))
+	g.handle("GET calendars/team/events", serveEvents(
+		event("offsite", "08:00", "12:00"),
+		event("standup", "10:30", "10:45"),
+	))
+
+	h := &EventsAPIHandler{Env: newTestEnv(nil)}
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	ids := func(events []*EventDTO) []string {
+		out := []string{}
+		for _, event := range events {
+			out = append(out, event.ID)
+		}
+		return out
+	}
+
+	events, failed, next, err := h.getEventsForUser(c, from, to, false)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.Equal(t, []string{"offsite", "nine"}, ids(events), "the page ends before the last event read of the truncated calendar")
+	require.Equal(t, time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC), next.UTC())
+
+	events, _, next, err = h.getEventsForUser(c, next, to, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ten", "standup", "eleven"}, ids(events), "the next page leaves out the events shown before")
+	require.True(t, next.IsZero())
+}
+
+func TestGetEventsForUserFailedCalendars(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Summary: "Me", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", Summary: "Team", AccessRole: AccessRoleWriter},
+	)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+	require.NoError(t, c.store.StoreUserSettings("user1", &UserSettings{SelectedCalendarIDs: []string{"me@example.com", "team"}}))
+
+	forbidden := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":403,"message":"Forbidden"}}`, http.StatusForbidden)
+	}
+	g.handle("GET calendars/team/events", forbidden)
+	g.handle("GET calendars/me@example.com/events", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{{
+			Id:    "nine",
+			Start: &calendar.EventDateTime{DateTime: "2024-03-04T09:00:00Z"},
+			End:   &calendar.EventDateTime{DateTime: "2024-03-04T09:30:00Z"},
+		}}})
+	})
+
+	h := &EventsAPIHandler{Env: newTestEnv(nil)}
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	events, failed, _, err := h.getEventsForUser(c, from, to, false)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, failed, 1)
+	require.Equal(t, "Team", failed[0].Name)
+
+	t.Run("all calendars failing is an error", func(t *testing.T) {
+		g.handle("GET calendars/me@example.com/events", forbidden)
+
+		_, _, _, err := h.getEventsForUser(c, from, to, false)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Named ranges of the events API, in the user's timezone
+const (
+	RangeToday    = "today"
+	RangeTomorrow = "tomorrow"
+	RangeWeek     = "week" // The next seven days
+	RangeThisWeek = "this_week"
+	RangeNextWeek = "next_week"
+	RangeMonth    = "month"
+)
+
+// namedEventRange returns the bounds of a named range around now, in the
+// location of now. Calendar weeks start on weekStart.
+func namedEventRange(name string, now time.Time, weekStart time.Weekday) (time.Time, time.Time, error) {
+	today := startOfDay(now)
+
+	switch name {
+	case RangeToday:
+		return today, endOfDay(now), nil
+	case RangeTomorrow:
+		tomorrow := today.AddDate(0, 0, 1)
+		return tomorrow, endOfDay(tomorrow), nil
+	case RangeWeek:
+		return today, endOfDay(now.AddDate(0, 0, 7)), nil
+	case RangeThisWeek, RangeNextWeek:
+		first := today.AddDate(0, 0, -int((7+now.Weekday()-weekStart)%7))
+		if name == RangeNextWeek {
+			first = first.AddDate(0, 0, 7)
+		}
+		return first, endOfDay(first.AddDate(0, 0, 6)), nil
+	case RangeMonth:
+		first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return first, endOfDay(first.AddDate(0, 1, -1)), nil
+	}
+
+	return time.Time{}, time.Time{}, errors.Errorf("unknown range %q", name)
+}
+
+// parseEventRange parses the from and to query parameters. Each is an
+// RFC3339 time or a date in loc; a to date includes the whole day. A missing
+// bound is one day away from the other.
+func parseEventRange(fromParam, toParam string, loc *time.Location) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+
+	if fromParam != "" {
+		from, err = parseRangeBound(fromParam, loc, false)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.Wrap(err, "invalid from")
+		}
+	}
+	if toParam != "" {
+		to, err = parseRangeBound(toParam, loc, true)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.Wrap(err, "invalid to")
+		}
+	}
+
+	switch {
+	case fromParam == "":
+		from = to.AddDate(0, 0, -1)
+	case toParam == "":
+		to = from.AddDate(0, 0, 1)
+	}
+
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("to must be after from")
+	}
+	return from, to, nil
+}
+
+func parseRangeBound(value string, loc *time.Location, isEnd bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, errors.New("expected an RFC3339 time or a YYYY-MM-DD date")
+	}
+	if isEnd {
+		return endOfDay(day), nil
+	}
+	return day, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedEventRange(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Wednesday, late in the evening in Berlin and already Thursday in UTC
+	now := time.Date(2024, time.January, 31, 23, 30, 0, 0, berlin)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, berlin)
+	}
+
+	for _, tc := range []struct {
+		Name      string
+		Range     string
+		WeekStart time.Weekday
+		From      time.Time
+		LastDay   time.Time
+		Error     bool
+	}{
+		{Name: "today", Range: RangeToday, From: day(time.January, 31), LastDay: day(time.January, 31)},
+		{Name: "tomorrow", Range: RangeTomorrow, From: day(time.February, 1), LastDay: day(time.February, 1)},
+		{Name: "week", Range: RangeWeek, From: day(time.January, 31), LastDay: day(time.February, 7)},
+		{Name: "this week from sunday", Range: RangeThisWeek, WeekStart: time.Sunday, From: day(time.January, 28), LastDay: day(time.February, 3)},
+		{Name: "this week from monday", Range: RangeThisWeek, WeekStart: time.Monday, From: day(time.January, 29), LastDay: day(time.February, 4)},
+		{Name: "this week starting today", Range: RangeThisWeek, WeekStart: time.Wednesday, From: day(time.January, 31), LastDay: day(time.February, 6)},
+		{Name: "next week from saturday", Range: RangeNextWeek, WeekStart: time.Saturday, From: day(time.February, 3), LastDay: day(time.February, 9)},
+		{Name: "month", Range: RangeMonth, From: day(time.January, 1), LastDay: day(time.January, 31)},
+		{Name: "unknown", Range: "fortnight", Error: true},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			from, to, err := namedEventRange(tc.Range, now, tc.WeekStart)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.From, from)
+			require.Equal(t, endOfDay(tc.LastDay), to)
+		})
+	}
+}
+
+func TestParseEventRange(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	from, to, err := parseEventRange("2024-03-01", "2024-03-03", berlin)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, time.March, 1, 0, 0, 0, 0, berlin), from)
+	require.Equal(t, endOfDay(time.Date(2024, time.March, 3, 0, 0, 0, 0, berlin)), to)
+
+	from, to, err = parseEventRange("2024-03-01T09:00:00Z", "", berlin)
+	require.NoError(t, err)
+	require.True(t, from.Equal(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)))
+	require.True(t, to.Equal(time.Date(2024, time.March, 2, 9, 0, 0, 0, time.UTC)))
+
+	_, _, err = parseEventRange("2024-03-03", "2024-03-01", berlin)
+	require.Error(t, err)
+
+	_, _, err = parseEventRange("tomorrow", "", berlin)
+	require.Error(t, err)
+}
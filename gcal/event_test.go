@@ -0,0 +1,134 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSplitRecurringEventRestoresSeries(t *testing.T) {
+	g := newFakeGoogle(t)
+	c, err := g.makeClient(newTestAPI(t))("user1")
+	require.NoError(t, err)
+
+	type patchCall struct {
+		IfMatch    string
+		Recurrence []string
+	}
+	patches := []patchCall{}
+	g.handle("PATCH calendars/cal/events/series", func(w http.ResponseWriter, r *http.Request) {
+		patch := &calendar.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(patch))
+		patches = append(patches, patchCall{IfMatch: r.Header.Get("If-Match"), Recurrence: patch.Recurrence})
+		_ = json.NewEncoder(w).Encode(&calendar.Event{Id: "series", Etag: fmt.Sprintf(`"etag-%d"`, len(patches))})
+	})
+	g.handle("POST calendars/cal/events", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error":{"code":500,"message":"Backend Error"}}`, http.StatusInternalServerError)
+	})
+
+	series := &calendar.Event{
+		Id:         "series",
+		Etag:       `"etag-0"`,
+		Recurrence: []string{"RRULE:FREQ=DAILY"},
+		Start:      &calendar.EventDateTime{DateTime: "2024-01-08T10:00:00Z"},
+		End:        &calendar.EventDateTime{DateTime: "2024-01-08T11:00:00Z"},
+	}
+	instance := &calendar.Event{
+		Id:                "series_20240110T100000Z",
+		OriginalStartTime: &calendar.EventDateTime{DateTime: "2024-01-10T10:00:00Z"},
+		Start:             &calendar.EventDateTime{DateTime: "2024-01-10T10:00:00Z"},
+		End:               &calendar.EventDateTime{DateTime: "2024-01-10T11:00:00Z"},
+	}
+
+	_, err = c.SplitRecurringEvent("cal", series, instance, &calendar.Event{Summary: "Moved"}, "none")
+	require.Error(t, err)
+
+	require.Len(t, patches, 2)
+	require.Equal(t, `"etag-0"`, patches[0].IfMatch)
+	require.NotEqual(t, series.Recurrence, patches[0].Recurrence, "the series is ended first")
+	require.Equal(t, `"etag-1"`, patches[1].IfMatch)
+	require.Equal(t, series.Recurrence, patches[1].Recurrence, "the series gets its recurrence back")
+}
+
+func TestGetEventsBetweenDates(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleWriter},
+	)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+	require.NoError(t, c.store.StoreUserSettings("user1", &UserSettings{SelectedCalendarIDs: []string{"me@example.com", "team"}}))
+
+	listed := 0
+	g.handle("GET users/me/calendarList", func(w http.ResponseWriter, _ *http.Request) {
+		listed++
+		_ = json.NewEncoder(w).Encode(&calendar.CalendarList{Items: g.calendars})
+	})
+
+	now := time.Now()
+	at := func(d time.Duration) *calendar.EventDateTime {
+		return &calendar.EventDateTime{DateTime: now.Add(d).Format(time.RFC3339)}
+	}
+	ownReminders := &calendar.EventReminders{Overrides: []*calendar.EventReminder{{Method: "popup", Minutes: 30}}}
+	g.handle("GET calendars/me@example.com/events", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{
+			{Id: "default-reminders", Start: at(time.Hour), End: at(2 * time.Hour)},
+			{Id: "own-reminders", Start: at(time.Hour), End: at(2 * time.Hour), Reminders: ownReminders},
+			{Id: "own-reminders-started", Start: at(-time.Minute), End: at(time.Hour), Reminders: ownReminders},
+		}})
+	})
+
+	for i := 0; i < 2; i++ {
+		events, err := c.GetEventsBetweenDates("", now.Add(-time.Hour), now.Add(3*time.Hour))
+		require.NoError(t, err)
+
+		ids := []string{}
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		require.ElementsMatch(t, []string{"default-reminders", "own-reminders-started"}, ids, "only the primary calendar is read, the events with their own reminders once started")
+	}
+	require.Equal(t, 1, listed, "the calendar list is cached")
+}
+
+func TestRespondToEventRetriesChangedEvent(t *testing.T) {
+	g := newFakeGoogle(t, &calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner})
+	c, err := g.makeClient(newTestAPI(t))("user1")
+	require.NoError(t, err)
+
+	// Someone else answers between the first read and patch
+	etag := `"etag-1"`
+	guests := []*calendar.EventAttendee{{Email: "me@example.com", Self: true}, {Email: "ana@example.com"}}
+	g.handle("GET calendars/me@example.com/events/evt", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Event{Id: "evt", Etag: etag, Attendees: guests})
+		etag = `"etag-2"`
+		guests = []*calendar.EventAttendee{{Email: "me@example.com", Self: true}, {Email: "ana@example.com", ResponseStatus: GoogleResponseStatusYes}}
+	})
+
+	ifMatch := []string{}
+	g.handle("PATCH calendars/me@example.com/events/evt", func(w http.ResponseWriter, r *http.Request) {
+		ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+		if r.Header.Get("If-Match") != etag {
+			http.Error(w, `{"error":{"code":412,"message":"Precondition Failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		patch := &calendar.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(patch))
+		_ = json.NewEncoder(w).Encode(&calendar.Event{Id: "evt", Attendees: patch.Attendees})
+	})
+
+	updated, _, err := c.RespondToEvent("", "evt", GoogleResponseStatusNo, "", SendUpdatesAll)
+	require.NoError(t, err)
+	require.Equal(t, []string{`"etag-1"`, `"etag-2"`}, ifMatch)
+	require.Equal(t, GoogleResponseStatusNo, updated.Attendees[0].ResponseStatus)
+	require.Equal(t, GoogleResponseStatusYes, updated.Attendees[1].ResponseStatus, "the other guest's response is kept")
+}
@@ -9,6 +9,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
@@ -19,6 +23,22 @@ type EventsResponse struct {
 	Events   []*EventDTO `json:"events"`
 	Timezone string      `json:"timezone,omitempty"`
 	Error    string      `json:"error,omitempty"`
+
+	// Truncated is set when the range has more events than one response
+	// holds. The request repeated with NextCursor as its cursor returns the
+	// next ones.
+	Truncated  bool   `json:"truncated,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+
+	// FailedCalendars are the selected calendars whose events could not be
+	// read, and are missing from Events
+	FailedCalendars []*FailedCalendarDTO `json:"failed_calendars,omitempty"`
+}
+
+// FailedCalendarDTO is a calendar whose events could not be read
+type FailedCalendarDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 // EventDTO is a simplified event for the frontend
@@ -33,6 +53,19 @@ type EventDTO struct {
 	Organizer   string `json:"organizer,omitempty"`
 	Description string `json:"description,omitempty"`
 	Conference  string `json:"conference,omitempty"`
+
+	CalendarID    string `json:"calendarId,omitempty"`
+	CalendarName  string `json:"calendarName,omitempty"`
+	CalendarColor string `json:"calendarColor,omitempty"`
+
+	// ColorID is the event color, the colors are resolved from it or from
+	// the calendar when the event has no color of its own
+	ColorID         string `json:"colorId,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	ForegroundColor string `json:"foregroundColor,omitempty"`
+
+	// RecurringEventID is set on the instances of recurring events
+	RecurringEventID string `json:"recurringEventId,omitempty"`
 }
 
 // CreateEventRequest is the request body for creating an event
@@ -47,23 +80,56 @@ type CreateEventRequest struct {
 	Location          string   `json:"location"`
 	ChannelID         string   `json:"channel_id"`
 	AddMattermostCall bool     `json:"add_mattermost_call"`
+
+	// Timezone is the IANA timezone the date and times are in, the user's
+	// Google Calendar timezone is used when empty
+	Timezone string `json:"timezone,omitempty"`
+
+	// EndDate is the last day of events spanning several days, the event
+	// ends on its start date when empty
+	EndDate string `json:"end_date,omitempty"`
+
+	// When describes the date and times in one expression, such as "next
+	// Tue 2-3pm" or "tomorrow 9:30 for 45m", instead of the date, end date,
+	// times and all day fields
+	When string `json:"when,omitempty"`
+
+	// AddVideoCall adds a call of the provider the admin configured,
+	// AddMattermostCall is its former name
+	AddVideoCall  bool `json:"add_video_call"`
+	AddGoogleMeet bool `json:"add_google_meet"`
+
+	// CalendarID overrides the user's default calendar, it must be writable
+	CalendarID string `json:"calendar_id,omitempty"`
+
+	// Recurrence makes the event repeat, starting on the event date
+	Recurrence *RecurrenceRequest `json:"recurrence,omitempty"`
+
+	// Reminders are the minutes before start the event reminds of itself.
+	// The calendar defaults are used when missing, and none when empty.
+	Reminders []int `json:"reminders"`
 }
 
 // CreateEventResponse is the response for creating an event
 type CreateEventResponse struct {
 	Event    *EventDTO `json:"event,omitempty"`
 	CallLink string    `json:"call_link,omitempty"`
+	MeetLink string    `json:"meet_link,omitempty"`
 	Error    string    `json:"error,omitempty"`
 }
 
 // EventsAPIHandler handles the events API requests
 type EventsAPIHandler struct {
-	Env engine.Env
+	Env              engine.Env
+	Store            Store
+	API              plugin.API
+	ChannelCalendars *ChannelCalendars
+	VideoCall        *VideoCallConfig
 }
 
 // NewEventsAPIHandler creates a new events API handler
-func NewEventsAPIHandler(env engine.Env) *EventsAPIHandler {
-	return &EventsAPIHandler{Env: env}
+func NewEventsAPIHandler(env engine.Env, store Store, api plugin.API, channelCalendars *ChannelCalendars, videoCall *VideoCallConfig) *EventsAPIHandler {
+	return &EventsAPIHandler{Env: env, Store: store, API: api, ChannelCalendars: channelCalendars, VideoCall: videoCall}
 }
 
 // RegisterRoutes registers the events API routes
@@ -73,6 +139,11 @@ func (h *EventsAPIHandler) RegisterRoutes(handler *httputils.Handler) {
 	apiRouter.HandleFunc("/events/today", h.HandleGetTodayEvents).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/events/tomorrow", h.HandleGetTomorrowEvents).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/events/week", h.HandleGetWeekEvents).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/events/{id}", h.HandleEvent).Methods(http.MethodGet, http.MethodPatch, http.MethodDelete)
+	apiRouter.HandleFunc("/events/{id}/rsvp", h.HandleEvent).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/calendars", h.HandleGetCalendars).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/calendars/selected", h.HandleSelectedCalendars).Methods(http.MethodGet, http.MethodPut)
+	apiRouter.HandleFunc("/calendars/default", h.HandleDefaultCalendar).Methods(http.MethodGet, http.MethodPut)
 }
 
 // HandleGetEvents handles GET /api/v1/events
@@ -83,76 +154,216 @@ func (h *EventsAPIHandler) HandleGetEvents(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rangeParam := r.URL.Query().Get("range")
-	if rangeParam == "" {
-		rangeParam = "today"
+	c, err := h.getClient(mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventsResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
 	}
 
+	query := r.URL.Query()
+	loc := h.userLocation(c, mattermostUserID)
+	if tz := query.Get("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			httputils.WriteJSONResponse(w, &EventsResponse{Error: "Invalid timezone " + tz}, http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now().In(loc)
+
 	var from, to time.Time
-	now := time.Now()
-
-	switch rangeParam {
-	case "today":
-		from = startOfDay(now)
-		to = endOfDay(now)
-	case "tomorrow":
-		tomorrow := now.AddDate(0, 0, 1)
-		from = startOfDay(tomorrow)
-		to = endOfDay(tomorrow)
-	case "week":
-		from = startOfDay(now)
-		to = endOfDay(now.AddDate(0, 0, 7))
-	default:
-		from = startOfDay(now)
-		to = endOfDay(now)
-	}
-
-	events, err := h.getEventsForUser(mattermostUserID, from, to)
+	if query.Get("from") != "" || query.Get("to") != "" {
+		from, to, err = parseEventRange(query.Get("from"), query.Get("to"), loc)
+	} else {
+		rangeParam := query.Get("range")
+		if rangeParam == "" {
+			rangeParam = RangeToday
+		}
+
+		weekStart := time.Sunday
+		if rangeParam == RangeThisWeek || rangeParam == RangeNextWeek {
+			weekStart = userWeekStart(c)
+		}
+		from, to, err = namedEventRange(rangeParam, now, weekStart)
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &EventsResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// The cursor of a truncated response is where the next page begins
+	start := from
+	if value := query.Get("cursor"); value != "" {
+		start, err = time.Parse(time.RFC3339, value)
+		if err != nil || start.Before(from) || !start.Before(to) {
+			httputils.WriteJSONResponse(w, &EventsResponse{Error: "Invalid cursor " + value}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, failed, next, err := h.getEventsForUser(c, start, to, start.After(from))
 	if err != nil {
 		httputils.WriteJSONResponse(w, &EventsResponse{Error: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
-	httputils.WriteJSONResponse(w, &EventsResponse{
-		Events: events,
-	}, http.StatusOK)
+	resp := &EventsResponse{
+		Events:   events,
+		Timezone: loc.String(),
+	}
+	for _, cal := range failed {
+		resp.FailedCalendars = append(resp.FailedCalendars, &FailedCalendarDTO{ID: cal.ID, Name: cal.Name})
+	}
+	if !next.IsZero() {
+		resp.Truncated = true
+		resp.NextCursor = next.Format(time.RFC3339)
+	}
+	httputils.WriteJSONResponse(w, resp, http.StatusOK)
 }
 
 // HandleGetTodayEvents handles GET /api/v1/events/today
 func (h *EventsAPIHandler) HandleGetTodayEvents(w http.ResponseWriter, r *http.Request) {
-	r.URL.RawQuery = "range=today"
+	setRangeQuery(r, RangeToday)
 	h.HandleGetEvents(w, r)
 }
 
 // HandleGetTomorrowEvents handles GET /api/v1/events/tomorrow
 func (h *EventsAPIHandler) HandleGetTomorrowEvents(w http.ResponseWriter, r *http.Request) {
-	r.URL.RawQuery = "range=tomorrow"
+	setRangeQuery(r, RangeTomorrow)
 	h.HandleGetEvents(w, r)
 }
 
 // HandleGetWeekEvents handles GET /api/v1/events/week
 func (h *EventsAPIHandler) HandleGetWeekEvents(w http.ResponseWriter, r *http.Request) {
-	r.URL.RawQuery = "range=week"
+	setRangeQuery(r, RangeWeek)
 	h.HandleGetEvents(w, r)
 }
 
-func (h *EventsAPIHandler) getEventsForUser(mattermostUserID string, from, to time.Time) ([]*EventDTO, error) {
-	user := engine.NewUser(mattermostUserID)
-	mscal := engine.New(h.Env, mattermostUserID)
+// setRangeQuery sets the range of an events request, keeping its other parameters
+func setRangeQuery(r *http.Request, rangeName string) {
+	query := r.URL.Query()
+	query.Set("range", rangeName)
+	r.URL.RawQuery = query.Encode()
+}
+
+// getEventsForUser returns a page of the events of the user's selected
+// calendars, the calendars that could not be read, and the start of the next
+// page when there are more. The pages after the first one leave out the
+// events starting before them, which the pages before showed.
+func (h *EventsAPIHandler) getEventsForUser(c *client, from, to time.Time, nextPage bool) ([]*EventDTO, []*Calendar, time.Time, error) {
+	calendars, err := c.SelectedCalendars()
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
 
-	events, err := mscal.ViewCalendar(user, from, to)
+	events, failed, next, err := c.GetCalendarEventsPage(calendars, from, to)
 	if err != nil {
-		return nil, err
+		return nil, nil, time.Time{}, err
+	}
+	if nextPage {
+		page := []*CalendarEvent{}
+		for _, event := range events {
+			if !event.Start.Time().Before(from) {
+				page = append(page, event)
+			}
+		}
+		events = page
 	}
 
+	colors := h.getEventColors(c, events)
+
 	// Convert to DTO
 	dtos := make([]*EventDTO, 0, len(events))
 	for _, event := range events {
-		dto := convertEventToDTO(event)
+		dto := convertEventToDTO(event.Event)
+		setEventDTOCalendar(dto, event.Calendar)
+		setEventDTOColor(dto, event.ColorID, colors)
+		dto.RecurringEventID = event.RecurringEventID
 		dtos = append(dtos, dto)
 	}
 
-	return dtos, nil
+	return dtos, failed, next, nil
+}
+
+// getEventColors returns the event color palette when some of the events have
+// their own color. Events fall back to the color of their calendar when the
+// palette cannot be read.
+func (h *EventsAPIHandler) getEventColors(c *client, events []*CalendarEvent) map[string]calendar.ColorDefinition {
+	for _, event := range events {
+		if event.ColorID == "" {
+			continue
+		}
+
+		colors, err := c.GetEventColors()
+		if err != nil {
+			h.Env.Logger.Warnf("gcal: failed to get event colors. err=%v", err)
+			return nil
+		}
+		return colors
+	}
+	return nil
+}
+
+// userLocation returns the timezone of a user, the one of their Google
+// Calendar settings or else the one of their Mattermost profile
+func (h *EventsAPIHandler) userLocation(c *client, mattermostUserID string) *time.Location {
+	settings, err := c.GetMailboxSettings("")
+	if err == nil && settings.TimeZone != "" {
+		if loc, err := time.LoadLocation(settings.TimeZone); err == nil {
+			return loc
+		}
+	}
+
+	if h.API != nil {
+		user, appErr := h.API.GetUser(mattermostUserID)
+		if appErr == nil && user.GetPreferredTimezone() != "" {
+			if loc, err := time.LoadLocation(user.GetPreferredTimezone()); err == nil {
+				return loc
+			}
+		}
+	}
+
+	return time.UTC
+}
+
+// userWeekStart returns the first day of the week in the user's Google
+// Calendar settings, Sunday when it cannot be read
+func userWeekStart(c *client) time.Weekday {
+	weekStart, err := c.GetWeekStart()
+	if err != nil {
+		c.Logger.Warnf("gcal: failed to get week start. err=%v", err)
+		return time.Sunday
+	}
+	return weekStart
+}
+
+// getClient returns the Google client of a connected user
+func (h *EventsAPIHandler) getClient(mattermostUserID string) (*client, error) {
+	return makeUserClient(h.Env, mattermostUserID)
+}
+
+// makeUserClient returns the Google client of a connected user, for the gcal
+// specific calls that the engine does not expose
+func makeUserClient(env engine.Env, mattermostUserID string) (*client, error) {
+	remoteClient, err := engine.New(env, mattermostUserID).MakeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := remoteClient.(*client)
+	if !ok {
+		return nil, errors.New("unexpected remote client, the user is not connected to Google Calendar")
+	}
+
+	return c, nil
+}
+
+// userClientMaker binds makeUserClient to an environment, for the handlers
+// that hold the function so that tests can replace it
+func userClientMaker(env engine.Env) func(mattermostUserID string) (*client, error) {
+	return func(mattermostUserID string) (*client, error) {
+		return makeUserClient(env, mattermostUserID)
+	}
 }
 
 func startOfDay(t time.Time) time.Time {
@@ -193,6 +404,25 @@ func convertEventToDTO(event *remote.Event) *EventDTO {
 	return dto
 }
 
+func setEventDTOCalendar(dto *EventDTO, cal *Calendar) {
+	if cal == nil {
+		return
+	}
+	dto.CalendarID = cal.ID
+	dto.CalendarName = cal.Name
+	dto.CalendarColor = cal.BackgroundColor
+	dto.BackgroundColor = cal.BackgroundColor
+	dto.ForegroundColor = cal.ForegroundColor
+}
+
+func setEventDTOColor(dto *EventDTO, colorID string, colors map[string]calendar.ColorDefinition) {
+	dto.ColorID = colorID
+	if color, ok := colors[colorID]; ok {
+		dto.BackgroundColor = color.Background
+		dto.ForegroundColor = color.Foreground
+	}
+}
+
 // HandleCreateEvent handles POST /api/v1/events/create
 func (h *EventsAPIHandler) HandleCreateEvent(w http.ResponseWriter, r *http.Request) {
 	mattermostUserID := r.Header.Get("Mattermost-User-Id")
@@ -212,39 +442,69 @@ func (h *EventsAPIHandler) HandleCreateEvent(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Parse date and times
-	startTime, endTime, err := parseDateTimes(req.Date, req.StartTime, req.EndTime, req.AllDay)
+	c, err := h.getClient(mattermostUserID)
 	if err != nil {
-		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
-	// Build description with Mattermost Calls link if requested
-	description := req.Description
-	callLink := ""
-	if req.AddMattermostCall {
-		// Get site URL from config
-		siteURL := h.Env.Config.PluginURL
-		if siteURL == "" {
-			// Fallback to a relative URL
-			callLink = "/plugins/com.fambear.calls/new"
-		} else {
-			callLink = fmt.Sprintf("%s/plugins/com.fambear.calls/new", siteURL)
+	loc := h.userLocation(c, mattermostUserID)
+	if req.Timezone != "" {
+		loc, err = time.LoadLocation(req.Timezone)
+		if err != nil {
+			httputils.WriteJSONResponse(w, &CreateEventResponse{Error: "Invalid timezone " + req.Timezone}, http.StatusBadRequest)
+			return
 		}
+	}
 
-		// Add call link to description
-		if description != "" {
-			description = description + "\n\n"
-		}
-		description = description + "📞 Join Mattermost Call: " + callLink
+	parser := NewDateTimeParser(loc)
+	parser.WeekStart = userWeekStart(c)
+
+	// Parse date and times
+	var startTime, endTime time.Time
+	if req.When != "" {
+		startTime, endTime, req.AllDay, err = parseWhen(parser, req.When)
+	} else {
+		startTime, endTime, err = parseDateTimes(parser, req.Date, req.EndDate, req.StartTime, req.EndTime, req.AllDay)
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// All-day events have dates without a timezone
+	timeZone := loc.String()
+	if req.AllDay {
+		timeZone = ""
 	}
 
 	// Create event
 	event := &remote.Event{
 		Subject:  req.Subject,
 		IsAllDay: req.AllDay,
-		Start:    remote.NewDateTime(startTime, ""),
-		End:      remote.NewDateTime(endTime, ""),
+		Start:    remote.NewDateTime(startTime, timeZone),
+		End:      remote.NewDateTime(endTime, timeZone),
+	}
+
+	opts := &EventOptions{AddGoogleMeet: req.AddGoogleMeet}
+	if req.AddVideoCall || req.AddMattermostCall {
+		err = h.addVideoCall(mattermostUserID, event, opts, req.ChannelID)
+		if err != nil {
+			httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The call link is also added to the description, for the calendar
+	// apps that do not read the call from the extended properties
+	description := req.Description
+	callLink := ""
+	if event.Conference != nil {
+		callLink = event.Conference.URL
+		if description != "" {
+			description = description + "\n\n"
+		}
+		description = description + "📞 Join " + event.Conference.Application + ": " + callLink
 	}
 
 	if req.Location != "" {
@@ -266,79 +526,168 @@ func (h *EventsAPIHandler) HandleCreateEvent(w http.ResponseWriter, r *http.Requ
 		event.Attendees = attendees
 	}
 
-	// Create the event using the engine
-	mscal := engine.New(h.Env, mattermostUserID)
-	user := engine.NewUser(mattermostUserID)
+	if req.Reminders != nil {
+		if err = validateReminders(req.Reminders); err != nil {
+			httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		opts.Reminders = req.Reminders
+	}
+
+	if req.Recurrence != nil {
+		opts.Recurrence, err = req.Recurrence.Lines(startTime, req.AllDay)
+		if err != nil {
+			httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	cal, err := getTargetCalendar(c, h.Store, req.CalendarID, req.ChannelID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
 
-	// Pass attendee Mattermost user IDs for notifications
-	createdEvent, err := mscal.CreateEvent(user, event, req.Attendees)
+	createdEvent, err := c.CreateEventInCalendar(cal.ID, event, opts)
 	if err != nil {
 		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
+	dto := convertEventToDTO(createdEvent)
+	setEventDTOCalendar(dto, cal)
+
+	meetLink := ""
+	if opts.AddGoogleMeet && createdEvent.Conference != nil {
+		meetLink = createdEvent.Conference.URL
+		if callLink == "" {
+			callLink = meetLink
+		}
+	}
+
 	httputils.WriteJSONResponse(w, &CreateEventResponse{
-		Event:    convertEventToDTO(createdEvent),
+		Event:    dto,
 		CallLink: callLink,
+		MeetLink: meetLink,
 	}, http.StatusOK)
 }
 
-func parseDateTimes(dateStr, startTimeStr, endTimeStr string, allDay bool) (time.Time, time.Time, error) {
+// getTargetCalendar returns the calendar a new event is created on: the one
+// requested, the calendar linked to the channel the event is created from
+// when the user can write to it, or else the user's default calendar
+func getTargetCalendar(c *client, store Store, calendarID, channelID string) (*Calendar, error) {
+	if calendarID == "" && channelID != "" && store != nil {
+		link, err := store.LoadChannelCalendar(channelID)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if link != nil {
+			calendars, err := c.ListCalendars()
+			if err != nil {
+				return nil, err
+			}
+			// Channel members who only see the linked calendar, or do not
+			// have it at all, create their events on their own calendar
+			if cal := findCalendar(calendars, link.CalendarID); cal != nil && cal.CanWrite() {
+				return cal, nil
+			}
+		}
+	}
+	if calendarID == "" {
+		var err error
+		calendarID, err = c.DefaultEventCalendarID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.GetWritableCalendar(calendarID)
+}
+
+// validateReminders checks reminders against the limits of Google Calendar
+func validateReminders(minutes []int) error {
+	if len(minutes) > maxReminders {
+		return errors.Errorf("an event can have at most %d reminders", maxReminders)
+	}
+	for _, m := range minutes {
+		if m < 0 || m > maxReminderMinutes {
+			return errors.Errorf("reminders must be between 0 and %d minutes before the event", maxReminderMinutes)
+		}
+	}
+	return nil
+}
+
+// parseDateTimes returns the start and end of a new event, in the parser's
+// timezone. The date and times are read by the parser, so that "next Tue" or
+// "3pm" are accepted next to 2006-01-02 and 15:04. All-day events start at
+// midnight UTC of their first day and end at midnight UTC after their last
+// day, as Google's all-day end dates are exclusive.
+func parseDateTimes(p *DateTimeParser, dateStr, endDateStr, startTimeStr, endTimeStr string, allDay bool) (time.Time, time.Time, error) {
 	if dateStr == "" {
 		return time.Time{}, time.Time{}, fmt.Errorf("date is required")
 	}
 
 	// Parse date
-	date, err := time.Parse("2006-01-02", dateStr)
+	date, err := p.ParseDate(dateStr)
 	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("invalid date format: %v", err)
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date: %v", err)
+	}
+
+	endDate := date
+	if endDateStr != "" {
+		endDate, err = p.ParseDate(endDateStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end_date: %v", err)
+		}
+		if endDate.Before(date) {
+			return time.Time{}, time.Time{}, fmt.Errorf("end_date is before date")
+		}
 	}
 
 	if allDay {
-		// All day event
-		start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
-		end := time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 59, 0, time.Local)
-		return start, end, nil
+		return utcDate(date), utcDate(endDate).AddDate(0, 0, 1), nil
 	}
 
 	if startTimeStr == "" || endTimeStr == "" {
 		return time.Time{}, time.Time{}, fmt.Errorf("start_time and end_time are required for non-all-day events")
 	}
 
-	// Parse times (format: "15:04" or "3:04 PM")
-	startTime, err := parseTimeString(startTimeStr)
+	// Parse times (format: "15:04", "3:04 PM" or "3pm")
+	startHour, startMinute, err := p.ParseClock(startTimeStr)
 	if err != nil {
 		return time.Time{}, time.Time{}, fmt.Errorf("invalid start_time: %v", err)
 	}
 
-	endTime, err := parseTimeString(endTimeStr)
+	endHour, endMinute, err := p.ParseClock(endTimeStr)
 	if err != nil {
 		return time.Time{}, time.Time{}, fmt.Errorf("invalid end_time: %v", err)
 	}
 
-	start := time.Date(date.Year(), date.Month(), date.Day(), startTime.Hour(), startTime.Minute(), 0, 0, time.Local)
-	end := time.Date(date.Year(), date.Month(), date.Day(), endTime.Hour(), endTime.Minute(), 0, 0, time.Local)
+	loc := p.location()
+	start := time.Date(date.Year(), date.Month(), date.Day(), startHour, startMinute, 0, 0, loc)
+	end := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), endHour, endMinute, 0, 0, loc)
+	if endDateStr != "" && !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the event ends before it starts")
+	}
 
 	return start, end, nil
 }
 
-func parseTimeString(timeStr string) (time.Time, error) {
-	// Try 24-hour format first
-	t, err := time.Parse("15:04", timeStr)
-	if err == nil {
-		return t, nil
-	}
-
-	// Try 12-hour format
-	t, err = time.Parse("3:04 PM", timeStr)
-	if err == nil {
-		return t, nil
+// parseWhen returns the start and end of a new event described in one
+// expression, such as "next Tue 2-3pm", converting all-day events as
+// parseDateTimes does
+func parseWhen(p *DateTimeParser, when string) (time.Time, time.Time, bool, error) {
+	eventTime, err := p.Parse(when)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("invalid when: %v", err)
 	}
-
-	t, err = time.Parse("3:04PM", timeStr)
-	if err == nil {
-		return t, nil
+	if eventTime.AllDay {
+		return utcDate(eventTime.Start), utcDate(eventTime.End), true, nil
 	}
+	return eventTime.Start, eventTime.End, false, nil
+}
 
-	return time.Time{}, fmt.Errorf("cannot parse time: %s", timeStr)
+// utcDate returns the day of t at midnight UTC, the form of all-day dates
+func utcDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
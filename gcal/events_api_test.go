@@ -0,0 +1,143 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestParseDateTimes(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		Name          string
+		Date, EndDate string
+		Start, End    string
+		AllDay        bool
+		ExpectedStart time.Time
+		ExpectedEnd   time.Time
+		Error         bool
+	}{
+		{
+			Name:          "all-day event ends the next day",
+			Date:          "2024-01-09",
+			AllDay:        true,
+			ExpectedStart: time.Date(2024, time.January, 9, 0, 0, 0, 0, time.UTC),
+			ExpectedEnd:   time.Date(2024, time.January, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:          "multi-day all-day event ends after its last day",
+			Date:          "2024-02-27",
+			EndDate:       "2024-03-01",
+			AllDay:        true,
+			ExpectedStart: time.Date(2024, time.February, 27, 0, 0, 0, 0, time.UTC),
+			ExpectedEnd:   time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:          "timed event in the given timezone",
+			Date:          "2024-07-01",
+			Start:         "09:00",
+			End:           "10:30",
+			ExpectedStart: time.Date(2024, time.July, 1, 7, 0, 0, 0, time.UTC),
+			ExpectedEnd:   time.Date(2024, time.July, 1, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			Name:          "multi-day timed event",
+			Date:          "2024-01-09",
+			EndDate:       "2024-01-10",
+			Start:         "22:00",
+			End:           "02:00",
+			ExpectedStart: time.Date(2024, time.January, 9, 22, 0, 0, 0, berlin),
+			ExpectedEnd:   time.Date(2024, time.January, 10, 2, 0, 0, 0, berlin),
+		},
+		{
+			Name:    "end date before date",
+			Date:    "2024-01-09",
+			EndDate: "2024-01-08",
+			AllDay:  true,
+			Error:   true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			start, end, err := parseDateTimes(NewDateTimeParser(berlin), tc.Date, tc.EndDate, tc.Start, tc.End, tc.AllDay)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tc.ExpectedStart.Equal(start), "start %s", start)
+			require.True(t, tc.ExpectedEnd.Equal(end), "end %s", end)
+		})
+	}
+}
+
+func TestConvertAllDayEventToGcalEvent(t *testing.T) {
+	start, end, err := parseDateTimes(NewDateTimeParser(time.UTC), "2024-02-27", "2024-03-01", "", "", true)
+	require.NoError(t, err)
+
+	evt := convertRemoteEventToGcalEvent(&remote.Event{
+		Subject:  "Offsite",
+		IsAllDay: true,
+		Start:    remote.NewDateTime(start, ""),
+		End:      remote.NewDateTime(end, ""),
+	}, nil)
+
+	require.Equal(t, "2024-02-27", evt.Start.Date)
+	require.Empty(t, evt.Start.DateTime)
+	require.Equal(t, "2024-03-02", evt.End.Date)
+	require.Empty(t, evt.End.DateTime)
+}
+
+func TestGetTargetCalendar(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleWriter},
+		&calendar.CalendarListEntry{Id: "holidays", AccessRole: AccessRoleReader},
+	)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+
+	store := NewStore(api)
+	for channelID, calendarID := range map[string]string{
+		"team-channel":     "team",
+		"holidays-channel": "holidays",
+		"other-channel":    "other",
+	} {
+		require.NoError(t, store.StoreChannelCalendar(&ChannelCalendar{ChannelID: channelID, CalendarID: calendarID}))
+	}
+
+	for _, tc := range []struct {
+		Name       string
+		CalendarID string
+		ChannelID  string
+		Expected   string
+		Error      bool
+	}{
+		{Name: "default calendar", Expected: "me@example.com"},
+		{Name: "unlinked channel", ChannelID: "channel", Expected: "me@example.com"},
+		{Name: "linked calendar", ChannelID: "team-channel", Expected: "team"},
+		{Name: "read-only linked calendar", ChannelID: "holidays-channel", Expected: "me@example.com"},
+		{Name: "linked calendar not in the list", ChannelID: "other-channel", Expected: "me@example.com"},
+		{Name: "requested calendar", CalendarID: "team", ChannelID: "holidays-channel", Expected: "team"},
+		{Name: "read-only requested calendar", CalendarID: "holidays", Error: true},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			cal, err := getTargetCalendar(c, store, tc.CalendarID, tc.ChannelID)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, cal.ID)
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/config"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const (
+	testPluginURLPath = "/plugins/gcal"
+	testPluginURL     = "https://mattermost.example.com" + testPluginURLPath
+
+	// calendarListResource is what the fake watches for the calendar list
+	calendarListResource = "calendarList"
+)
+
+// newTestAPI returns a plugin API mock with an in-memory KV store, which
+// also serves the cluster locks. Tests add the other calls they expect.
+func newTestAPI(t *testing.T) *plugintest.API {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	var mu sync.Mutex
+	kv := map[string][]byte{}
+
+	api.On("KVGet", mock.Anything).Return(func(key string) ([]byte, *model.AppError) {
+		mu.Lock()
+		defer mu.Unlock()
+		return kv[key], nil
+	}).Maybe()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		mu.Lock()
+		defer mu.Unlock()
+		kv[key] = value
+		return nil
+	}).Maybe()
+	api.On("KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, _ int64) *model.AppError {
+		mu.Lock()
+		defer mu.Unlock()
+		kv[key] = value
+		return nil
+	}).Maybe()
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(kv, key)
+		return nil
+	}).Maybe()
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) ([]string, *model.AppError) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys := []string{}
+		for key := range kv {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		start := min(page*perPage, len(keys))
+		return keys[start:min(start+perPage, len(keys))], nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if options.Atomic && !bytes.Equal(kv[key], options.OldValue) {
+			return false, nil
+		}
+		if value == nil {
+			delete(kv, key)
+		} else {
+			kv[key] = value
+		}
+		return true, nil
+	}).Maybe()
+
+	return api
+}
+
+// mockChannelMembers makes the API return the channel members from the map,
+// which tests change to have people join and leave
+func mockChannelMembers(api *plugintest.API, members map[string][]string) {
+	api.On("GetChannelMembers", mock.Anything, 0, membersPerPage).Return(func(channelID string, _, _ int) (model.ChannelMembers, *model.AppError) {
+		out := model.ChannelMembers{}
+		for _, mattermostUserID := range members[channelID] {
+			out = append(out, model.ChannelMember{ChannelId: channelID, UserId: mattermostUserID})
+		}
+		return out, nil
+	}).Maybe()
+}
+
+func newTestEnv(poster bot.Poster) engine.Env {
+	return engine.Env{
+		Config: &config.Config{
+			PluginURL:     testPluginURL,
+			PluginURLPath: testPluginURLPath,
+		},
+		Dependencies: &engine.Dependencies{
+			Logger: &testLogger{},
+			Poster: poster,
+		},
+	}
+}
+
+// testLogger discards the logs
+type testLogger struct {
+	bot.Logger
+}
+
+func (l *testLogger) With(bot.LogContext) bot.Logger { return l }
+func (l *testLogger) Debugf(string, ...interface{})  {}
+func (l *testLogger) Errorf(string, ...interface{})  {}
+func (l *testLogger) Infof(string, ...interface{})   {}
+func (l *testLogger) Warnf(string, ...interface{})   {}
+
+// testPoster records the direct messages with attachments sent by the bot
+type testPoster struct {
+	bot.Poster
+
+	mu  sync.Mutex
+	dms map[string][]*model.SlackAttachment
+}
+
+func (p *testPoster) DMWithAttachments(mattermostUserID string, attachments ...*model.SlackAttachment) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dms == nil {
+		p.dms = map[string][]*model.SlackAttachment{}
+	}
+	p.dms[mattermostUserID] = append(p.dms[mattermostUserID], attachments...)
+	return model.NewId(), nil
+}
+
+// fakeGoogle serves the Google Calendar API from memory. It holds a calendar
+// list and the access rules of the calendars, opens watch channels, and
+// records the channels that were stopped.
+type fakeGoogle struct {
+	t  *testing.T
+	mu sync.Mutex
+
+	calendars []*calendar.CalendarListEntry
+
+	// acl maps calendar IDs to the role of each email with an access rule
+	acl map[string]map[string]string
+
+	// watches maps the open watch channels to the calendar they watch
+	watches map[string]string
+	stopped []string
+
+	// handlers serve the other calls, by method and path
+	handlers map[string]http.HandlerFunc
+}
+
+func newFakeGoogle(t *testing.T, calendars ...*calendar.CalendarListEntry) *fakeGoogle {
+	return &fakeGoogle{
+		t:         t,
+		calendars: calendars,
+		acl:       map[string]map[string]string{},
+		watches:   map[string]string{},
+		handlers:  map[string]http.HandlerFunc{},
+	}
+}
+
+// handle serves a call, such as "GET calendars/primary/events", with a
+// handler. Handlers run one at a time.
+func (g *fakeGoogle) handle(route string, handler http.HandlerFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers[route] = handler
+}
+
+// makeClient returns a client maker for the handlers, whose clients keep
+// the gcal settings in the store of the API and call the fake
+func (g *fakeGoogle) makeClient(api plugin.API) func(string) (*client, error) {
+	return func(mattermostUserID string) (*client, error) {
+		return &client{
+			ctx:              context.Background(),
+			httpClient:       &http.Client{Transport: g},
+			mattermostUserID: mattermostUserID,
+			store:            NewStore(api),
+			conf:             &config.Config{PluginURL: testPluginURL},
+			Logger:           &testLogger{},
+		}, nil
+	}
+}
+
+func (g *fakeGoogle) setRole(calendarID, email, role string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.acl[calendarID] == nil {
+		g.acl[calendarID] = map[string]string{}
+	}
+	g.acl[calendarID][email] = role
+}
+
+func (g *fakeGoogle) role(calendarID, email string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.acl[calendarID][email]
+}
+
+func (g *fakeGoogle) watched(channelID string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.watches[channelID]
+}
+
+func (g *fakeGoogle) RoundTrip(r *http.Request) (*http.Response, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/calendar/v3/")
+	parts := strings.Split(path, "/")
+
+	if handler := g.handlers[r.Method+" "+path]; handler != nil {
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		resp := rec.Result()
+		resp.Request = r
+		return resp, nil
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "users/me/calendarList":
+		return respondJSON(r, http.StatusOK, &calendar.CalendarList{Items: g.calendars})
+
+	case r.Method == http.MethodPost && path == "users/me/calendarList/watch":
+		return g.watch(r, calendarListResource)
+
+	case r.Method == http.MethodPost && path == "channels/stop":
+		channel := &calendar.Channel{}
+		if err := json.NewDecoder(r.Body).Decode(channel); err != nil {
+			return nil, err
+		}
+		delete(g.watches, channel.Id)
+		g.stopped = append(g.stopped, channel.Id)
+		return respondJSON(r, http.StatusNoContent, nil)
+
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[0] == "calendars" && parts[2] == "events" && parts[3] == "watch":
+		return g.watch(r, parts[1])
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "calendars" && parts[2] == "acl":
+		rule := &calendar.AclRule{}
+		if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+			return nil, err
+		}
+		if g.acl[parts[1]] == nil {
+			g.acl[parts[1]] = map[string]string{}
+		}
+		g.acl[parts[1]][rule.Scope.Value] = rule.Role
+		rule.Id = aclScopeTypeUser + ":" + rule.Scope.Value
+		return respondJSON(r, http.StatusOK, rule)
+
+	case len(parts) == 4 && parts[0] == "calendars" && parts[2] == "acl":
+		email := strings.TrimPrefix(parts[3], aclScopeTypeUser+":")
+		role, ok := g.acl[parts[1]][email]
+		switch {
+		case !ok:
+			return respondJSON(r, http.StatusNotFound, nil)
+		case r.Method == http.MethodGet:
+			return respondJSON(r, http.StatusOK, &calendar.AclRule{
+				Id:    parts[3],
+				Role:  role,
+				Scope: &calendar.AclRuleScope{Type: aclScopeTypeUser, Value: email},
+			})
+		case r.Method == http.MethodDelete:
+			delete(g.acl[parts[1]], email)
+			return respondJSON(r, http.StatusNoContent, nil)
+		}
+	}
+
+	g.t.Errorf("unexpected Google API call %s %s", r.Method, r.URL.Path)
+	return respondJSON(r, http.StatusNotFound, nil)
+}
+
+func (g *fakeGoogle) watch(r *http.Request, resource string) (*http.Response, error) {
+	channel := &calendar.Channel{}
+	if err := json.NewDecoder(r.Body).Decode(channel); err != nil {
+		return nil, err
+	}
+	g.watches[channel.Id] = resource
+	channel.ResourceId = "resource-" + resource
+	channel.Expiration = time.Now().Add(7 * 24 * time.Hour).UnixMilli()
+	return respondJSON(r, http.StatusOK, channel)
+}
+
+func respondJSON(r *http.Request, status int, body interface{}) (*http.Response, error) {
+	data := []byte{}
+	switch {
+	case status == http.StatusNotFound:
+		data = []byte(`{"error":{"code":404,"message":"Not Found"}}`)
+	case body != nil:
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    r,
+	}, nil
+}
@@ -5,6 +5,8 @@ package gcal
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
@@ -32,3 +34,24 @@ func (c *client) GetMailboxSettings(remoteUserID string) (*remote.MailboxSetting
 	}
 	return out, nil
 }
+
+// GetWeekStart returns the first day of the week in the user's Google
+// Calendar settings
+func (c *client) GetWeekStart() (time.Weekday, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return time.Sunday, errors.Wrap(err, "gcal GetWeekStart, error creating service")
+	}
+
+	setting, err := service.Settings.Get("weekStart").Do()
+	if err != nil {
+		return time.Sunday, errors.Wrap(err, "gcal GetWeekStart, error getting week start setting")
+	}
+
+	// The setting is the number of the weekday, 0 being Sunday
+	day, err := strconv.Atoi(setting.Value)
+	if err != nil || day < 0 || day > 6 {
+		return time.Sunday, errors.Errorf("gcal GetWeekStart, invalid week start %q", setting.Value)
+	}
+	return time.Weekday(day), nil
+}
@@ -4,39 +4,117 @@
 package gcal
 
 import (
-	"context"
+	"slices"
+	"time"
 
 	"github.com/pkg/errors"
-	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
-func (c *client) GetNotificationData(orig *remote.Notification) (*remote.Notification, error) {
-	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
-	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetNotificationData, error creating service")
-	}
+// notificationLookback is how far back changes are fetched for a calendar
+// that has no recorded sync time
+const notificationLookback = time.Minute
 
+// GetNotificationData fetches the event a notification is about. Google only
+// sends the ID of the watched calendar, and sends a notification for each
+// change, so this is the oldest change of the calendar no notification
+// delivered yet. The sync time of the calendar only moves past a change once
+// it is delivered, the changes of a burst are delivered one at a time.
+func (c *client) GetNotificationData(orig *remote.Notification) (*remote.Notification, error) {
 	n := *orig
 	wh := n.Webhook.(*webhook)
 
-	cal, err := c.GetDefaultCalendar()
-	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetNotificationData, error getting default calendar")
+	calendarID := wh.CalendarID
+	if calendarID == "" {
+		calendarID = defaultCalendarName
 	}
 
-	reqBody := service.Events.Get(cal.ID, wh.Resource)
-	googleEvent, err := reqBody.Do()
+	var watches *CalendarWatches
+	since := time.Now().Add(-notificationLookback)
+	delivered := []string{}
+	if c.store != nil {
+		// Notifications of the same user can be handled at once on
+		// different nodes, each must find the changes the others delivered
+		unlock, err := c.store.LockCalendarWatches(c.mattermostUserID)
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal GetNotificationData, error locking calendar watches")
+		}
+		defer unlock()
+
+		watches, err = c.store.LoadCalendarWatches(c.mattermostUserID)
+		if err != nil {
+			watches = nil
+		} else if !watches.LastSyncTimes[calendarID].IsZero() {
+			since = watches.LastSyncTimes[calendarID]
+			delivered = watches.DeliveredEventIDs[calendarID]
+		}
+	}
+
+	changes, err := c.GetEventChanges(calendarID, since)
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal GetNotificationData, error fetching event data")
 	}
 
-	event := convertGCalEventToRemoteEvent(googleEvent)
+	var next *EventChange
+	if watches == nil {
+		// Without a record of the delivered changes, the latest one is
+		next = latestChange(changes)
+	} else {
+		next = nextUndeliveredChange(changes, since, delivered)
+	}
+	if next == nil {
+		return nil, errors.New("gcal GetNotificationData, no changed event found")
+	}
+
+	if watches != nil {
+		if !next.Updated.Equal(since) {
+			delivered = nil
+		}
+		watches.LastSyncTimes[calendarID] = next.Updated
+		watches.DeliveredEventIDs[calendarID] = append(delivered, next.ID)
+		if err = c.store.StoreCalendarWatches(c.mattermostUserID, watches); err != nil {
+			c.Logger.Warnf("gcal: failed to store calendar sync time. err=%v", err)
+		}
+	}
 
-	n.Event = event
+	// The reminders are read again with the changes
+	if c.store != nil {
+		if err = c.store.DeleteReminderSchedule(c.mattermostUserID); err != nil {
+			c.Logger.Warnf("gcal: failed to delete reminder schedule. err=%v", err)
+		}
+	}
+
+	n.Event = next.Event
 	n.IsBare = false
 
 	return &n, nil
 }
+
+// nextUndeliveredChange returns the oldest change updated after since, or at
+// since and not delivered yet
+func nextUndeliveredChange(changes []*EventChange, since time.Time, delivered []string) *EventChange {
+	var next *EventChange
+	for _, change := range changes {
+		if change.Updated.Before(since) {
+			continue
+		}
+		if change.Updated.Equal(since) && slices.Contains(delivered, change.ID) {
+			continue
+		}
+		if next == nil || change.Updated.Before(next.Updated) {
+			next = change
+		}
+	}
+	return next
+}
+
+func latestChange(changes []*EventChange) *EventChange {
+	var latest *EventChange
+	for _, change := range changes {
+		if latest == nil || change.Updated.After(latest.Updated) {
+			latest = change
+		}
+	}
+	return latest
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestGetNotificationData(t *testing.T) {
+	g := newFakeGoogle(t)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+
+	lastSync := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	err = c.store.StoreCalendarWatches("user1", &CalendarWatches{
+		SubscriptionID: "sub1",
+		Watches:        map[string]*WatchChannel{"team": {ID: "watch-team", CalendarID: "team"}},
+		LastSyncTimes:  map[string]time.Time{"team": lastSync},
+	})
+	require.NoError(t, err)
+
+	// Three events changed in a burst, two of them at once
+	changes := []*calendar.Event{
+		{Id: "moved", Updated: "2024-03-04T10:00:05.000Z"},
+		{Id: "added", Updated: "2024-03-04T10:00:02.000Z"},
+		{Id: "renamed", Updated: "2024-03-04T10:00:02.000Z"},
+		{Id: "old", Updated: "2024-03-04T09:59:59.000Z"},
+	}
+	g.handle("GET calendars/team/events", func(w http.ResponseWriter, r *http.Request) {
+		// Slow enough for notifications handled at once to overlap
+		time.Sleep(10 * time.Millisecond)
+
+		updatedMin, err := time.Parse(time.RFC3339, r.URL.Query().Get("updatedMin"))
+		require.NoError(t, err)
+		items := []*calendar.Event{}
+		for _, change := range changes {
+			updated, _ := time.Parse(time.RFC3339, change.Updated)
+			if !updated.Before(updatedMin) {
+				items = append(items, change)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(&calendar.Events{Items: items})
+	})
+
+	notification := &remote.Notification{Webhook: &webhook{SubscriptionID: "sub1", CalendarID: "team"}, IsBare: true}
+	delivered := []string{}
+	for i := 0; i < 3; i++ {
+		n, err := c.GetNotificationData(notification)
+		require.NoError(t, err)
+		delivered = append(delivered, n.Event.ID)
+	}
+	require.ElementsMatch(t, []string{"added", "renamed"}, delivered[:2])
+	require.Equal(t, "moved", delivered[2])
+
+	_, err = c.GetNotificationData(notification)
+	require.Error(t, err, "every change was delivered")
+
+	watches, err := c.store.LoadCalendarWatches("user1")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 3, 4, 10, 0, 5, 0, time.UTC), watches.LastSyncTimes["team"].UTC())
+
+	t.Run("notifications handled at once deliver every change", func(t *testing.T) {
+		watches.LastSyncTimes["team"] = lastSync
+		watches.DeliveredEventIDs = nil
+		require.NoError(t, c.store.StoreCalendarWatches("user1", watches))
+
+		var wg sync.WaitGroup
+		ids := make(chan string, 3)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := c.GetNotificationData(notification)
+				if err == nil {
+					ids <- n.Event.ID
+				}
+			}()
+		}
+		wg.Wait()
+		close(ids)
+
+		delivered := []string{}
+		for id := range ids {
+			delivered = append(delivered, id)
+		}
+		require.ElementsMatch(t, []string{"added", "renamed", "moved"}, delivered)
+	})
+}
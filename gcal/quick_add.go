@@ -0,0 +1,546 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/httputils"
+)
+
+const (
+	PathQuickAddEvent  = "/api/v1/events/quickadd"
+	PathQuickAddAction = "/api/v1/events/actions/quickadd"
+	PathQuickAddDialog = "/api/v1/events/actions/quickadd/edit"
+
+	actionEdit = "edit"
+	actionUndo = "undo"
+
+	// dialogTimeFormat is how times are entered in the edit dialog, dates
+	// alone are entered for all-day events
+	dialogTimeFormat = "2006-01-02 15:04"
+	dialogDateFormat = "2006-01-02"
+)
+
+const quickAddCommandHelp = "Please describe the event, for example `/%s quickadd Lunch with Ana tomorrow 12:30 at Café`."
+
+const eventCommandHelp = "Please give the time of the event followed by its title, for example `/%s event next Tue 2-3pm Sprint planning`."
+
+// QuickAddRequest is the request body for quickly adding an event
+type QuickAddRequest struct {
+	// Text describes the event in one line, such as "Lunch with Ana
+	// tomorrow 12:30 at Café"
+	Text string `json:"text"`
+
+	// CalendarID overrides the calendar linked to the channel and the
+	// user's default calendar
+	CalendarID string `json:"calendar_id,omitempty"`
+
+	// ChannelID is the channel the confirmation is shown in, none is shown
+	// when empty
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// quickAddDialogState is the state of the edit dialog
+type quickAddDialogState struct {
+	CalendarID string `json:"calendar_id"`
+	EventID    string `json:"event_id"`
+	PostID     string `json:"post_id"`
+	ChannelID  string `json:"channel_id"`
+}
+
+// QuickAdd creates events from one line of text with Google's quick add, and
+// offers to edit or undo them from the confirmation
+type QuickAdd struct {
+	Env       engine.Env
+	Store     Store
+	API       plugin.API
+	BotUserID string
+}
+
+// NewQuickAdd creates a new quick add handler
+func NewQuickAdd(env engine.Env, store Store, api plugin.API, botUserID string) *QuickAdd {
+	return &QuickAdd{
+		Env:       env,
+		Store:     store,
+		API:       api,
+		BotUserID: botUserID,
+	}
+}
+
+// Add creates an event from text on the requested calendar, the calendar
+// linked to the channel, or else the user's default calendar
+func (q *QuickAdd) Add(mattermostUserID, calendarID, channelID, text string) (*calendar.Event, *Calendar, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil, errors.New("the event description is required")
+	}
+
+	c, err := makeUserClient(q.Env, mattermostUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cal, err := getTargetCalendar(c, q.Store, calendarID, channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	evt, err := c.QuickAddEvent(cal.ID, text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return evt, cal, nil
+}
+
+// AddAt creates an event from text that starts with its time, such as "next
+// Tue 2-3pm Sprint planning", on the calendar linked to the channel or else
+// the user's default calendar. Unlike Add, the time is read by
+// DateTimeParser, in the calendar's timezone and with the user's week
+// start, and the rest of the text is the title.
+func (q *QuickAdd) AddAt(mattermostUserID, channelID, text string) (*remote.Event, *Calendar, error) {
+	c, err := makeUserClient(q.Env, mattermostUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cal, err := getTargetCalendar(c, q.Store, "", channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc := calendarLocation(cal)
+	parser := NewDateTimeParser(loc)
+	parser.WeekStart = userWeekStart(c)
+
+	when, subject := splitWhen(parser, text)
+	if when == "" {
+		return nil, nil, errors.New("the text must start with the time of the event, such as \"tomorrow 9:30\", followed by its title")
+	}
+	start, end, allDay, err := parseWhen(parser, when)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// All-day events have dates without a timezone
+	timeZone := loc.String()
+	if allDay {
+		timeZone = ""
+	}
+
+	evt, err := c.CreateEventInCalendar(cal.ID, &remote.Event{
+		Subject:  subject,
+		IsAllDay: allDay,
+		Start:    remote.NewDateTime(start, timeZone),
+		End:      remote.NewDateTime(end, timeZone),
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return evt, cal, nil
+}
+
+// splitWhen splits text into the longest start that p reads as the time of
+// an event and the title after it. The time is empty when no start of the
+// text is one, or the whole text is, which leaves no title.
+func splitWhen(p *DateTimeParser, text string) (when, subject string) {
+	words := strings.Fields(text)
+	for n := len(words); n > 0; n-- {
+		when = strings.Join(words[:n], " ")
+		if _, err := p.Parse(when); err == nil {
+			if n == len(words) {
+				break
+			}
+			return when, strings.Join(words[n:], " ")
+		}
+	}
+	return "", ""
+}
+
+// HandleQuickAddEvent handles POST /api/v1/events/quickadd
+func (q *QuickAdd) HandleQuickAddEvent(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: "Not authorized"}, http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QuickAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: "Text is required"}, http.StatusBadRequest)
+		return
+	}
+
+	evt, cal, err := q.Add(mattermostUserID, req.CalendarID, req.ChannelID, req.Text)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &CreateEventResponse{Error: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	if req.ChannelID != "" {
+		q.API.SendEphemeralPost(mattermostUserID, &model.Post{
+			UserId:    q.BotUserID,
+			ChannelId: req.ChannelID,
+			Props: model.StringInterface{
+				"attachments": []*model.SlackAttachment{q.card(convertGCalEventToRemoteEvent(evt), cal)},
+			},
+		})
+	}
+
+	dto := convertEventToDTO(convertGCalEventToRemoteEvent(evt))
+	setEventDTOCalendar(dto, cal)
+
+	httputils.WriteJSONResponse(w, &CreateEventResponse{Event: dto}, http.StatusOK)
+}
+
+// card is the confirmation of a quickly added event, the buttons open the
+// edit dialog and delete the event
+func (q *QuickAdd) card(event *remote.Event, cal *Calendar) *model.SlackAttachment {
+	subject := event.Subject
+	if subject == "" {
+		subject = "(No title)"
+	}
+
+	text := formatEventTime(event, cal.TimeZone)
+	if event.Location != nil && event.Location.DisplayName != "" {
+		text += "\n" + event.Location.DisplayName
+	}
+	text += "\nCalendar: " + cal.Name
+
+	actionURL := q.Env.Config.PluginURLPath + PathQuickAddAction
+	actionContext := func(action string) map[string]any {
+		return map[string]any{
+			"action":      action,
+			"calendar_id": cal.ID,
+			"event_id":    event.ID,
+		}
+	}
+
+	return &model.SlackAttachment{
+		Pretext:   "Event created",
+		Title:     subject,
+		TitleLink: event.Weblink,
+		Text:      text,
+		Actions: []*model.PostAction{
+			{
+				Name: "Edit",
+				Type: model.PostActionTypeButton,
+				Integration: &model.PostActionIntegration{
+					URL:     actionURL,
+					Context: actionContext(actionEdit),
+				},
+			},
+			{
+				Name:  "Undo",
+				Type:  model.PostActionTypeButton,
+				Style: "danger",
+				Integration: &model.PostActionIntegration{
+					URL:     actionURL,
+					Context: actionContext(actionUndo),
+				},
+			},
+		},
+	}
+}
+
+// HandleAction handles the Edit and Undo buttons of the confirmation
+func (q *QuickAdd) HandleAction(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if mattermostUserID == "" || req.UserId != mattermostUserID {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	action, _ := req.Context["action"].(string)
+	calendarID, _ := req.Context["calendar_id"].(string)
+	eventID, _ := req.Context["event_id"].(string)
+
+	c, err := makeUserClient(q.Env, mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{EphemeralText: err.Error()}, http.StatusOK)
+		return
+	}
+
+	evt, cal, err := c.FindEvent(calendarID, eventID)
+	if err == ErrNotFound {
+		httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{
+			Update: &model.Post{Id: req.PostId, Message: "The event no longer exists."},
+		}, http.StatusOK)
+		return
+	}
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{EphemeralText: err.Error()}, http.StatusOK)
+		return
+	}
+
+	switch action {
+	case actionUndo:
+		message := fmt.Sprintf("Removed event **%s**.", evt.Summary)
+		if err = c.CancelEvent(cal.ID, evt.Id, SendUpdatesAll); err != nil {
+			message = fmt.Sprintf("Failed to remove event **%s**: %s", evt.Summary, err.Error())
+		}
+		httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{
+			Update: &model.Post{Id: req.PostId, Message: message},
+		}, http.StatusOK)
+	case actionEdit:
+		state := quickAddDialogState{
+			CalendarID: cal.ID,
+			EventID:    evt.Id,
+			PostID:     req.PostId,
+			ChannelID:  req.ChannelId,
+		}
+		if err = q.openEditDialog(req.TriggerId, evt, cal, state); err != nil {
+			httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{EphemeralText: err.Error()}, http.StatusOK)
+			return
+		}
+		httputils.WriteJSONResponse(w, &model.PostActionIntegrationResponse{}, http.StatusOK)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+	}
+}
+
+func (q *QuickAdd) openEditDialog(triggerID string, evt *calendar.Event, cal *Calendar, state quickAddDialogState) error {
+	start, end, allDay, err := dialogEventTimes(evt, calendarLocation(cal))
+	if err != nil {
+		return err
+	}
+
+	format := "YYYY-MM-DD HH:MM"
+	if allDay {
+		format = "YYYY-MM-DD"
+	}
+
+	encodedState, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	appErr := q.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       q.Env.Config.PluginURLPath + PathQuickAddDialog,
+		Dialog: model.Dialog{
+			CallbackId:  "quickadd_edit",
+			Title:       "Edit event",
+			SubmitLabel: "Save",
+			State:       string(encodedState),
+			Elements: []model.DialogElement{
+				{DisplayName: "Title", Name: "subject", Type: "text", Default: evt.Summary},
+				{DisplayName: "Start", Name: "start", Type: "text", Default: start, Placeholder: format},
+				{DisplayName: "End", Name: "end", Type: "text", Default: end, Placeholder: format},
+				{DisplayName: "Location", Name: "location", Type: "text", Default: evt.Location, Optional: true},
+			},
+		},
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open edit dialog")
+	}
+	return nil
+}
+
+// HandleEditDialog handles the submission of the edit dialog, and updates
+// the confirmation with the changed event
+func (q *QuickAdd) HandleEditDialog(w http.ResponseWriter, r *http.Request) {
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+
+	var req model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if mattermostUserID == "" || req.UserId != mattermostUserID {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var state quickAddDialogState
+	if err := json.Unmarshal([]byte(req.State), &state); err != nil {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+
+	c, err := makeUserClient(q.Env, mattermostUserID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.SubmitDialogResponse{Error: err.Error()}, http.StatusOK)
+		return
+	}
+
+	evt, cal, err := c.FindEvent(state.CalendarID, state.EventID)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.SubmitDialogResponse{Error: err.Error()}, http.StatusOK)
+		return
+	}
+
+	parser := NewDateTimeParser(calendarLocation(cal))
+	parser.WeekStart = userWeekStart(c)
+	update, fieldErrors := dialogEventUpdate(evt, parser, req.Submission)
+	if len(fieldErrors) > 0 {
+		httputils.WriteJSONResponse(w, &model.SubmitDialogResponse{Errors: fieldErrors}, http.StatusOK)
+		return
+	}
+
+	patch, err := buildEventPatch(evt, update)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.SubmitDialogResponse{Error: err.Error()}, http.StatusOK)
+		return
+	}
+
+	updated, err := c.PatchEvent(cal.ID, evt.Id, patch, evt.Etag, SendUpdatesAll)
+	if err != nil {
+		httputils.WriteJSONResponse(w, &model.SubmitDialogResponse{Error: err.Error()}, http.StatusOK)
+		return
+	}
+
+	if state.PostID != "" {
+		attachment := q.card(convertGCalEventToRemoteEvent(updated), cal)
+		attachment.Pretext = "Event updated"
+		q.API.UpdateEphemeralPost(mattermostUserID, &model.Post{
+			Id:        state.PostID,
+			UserId:    q.BotUserID,
+			ChannelId: state.ChannelID,
+			Props: model.StringInterface{
+				"attachments": []*model.SlackAttachment{attachment},
+			},
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// dialogEventTimes returns the start and end of an event as shown in the
+// edit dialog. The end of all-day events is their last day, where Google
+// keeps the day after.
+func dialogEventTimes(evt *calendar.Event, loc *time.Location) (start, end string, allDay bool, err error) {
+	startTime, err := parseEventDateTime(evt.Start)
+	if err != nil {
+		return "", "", false, err
+	}
+	endTime, err := parseEventDateTime(evt.End)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	if evt.Start.Date != "" {
+		return startTime.Format(dialogDateFormat), endTime.AddDate(0, 0, -1).Format(dialogDateFormat), true, nil
+	}
+	return startTime.In(loc).Format(dialogTimeFormat), endTime.In(loc).Format(dialogTimeFormat), false, nil
+}
+
+// dialogEventUpdate returns the change request of an edit dialog submission,
+// only the fields that were changed are set. Invalid fields are returned as
+// errors by field name. The times are read by p, in its location.
+func dialogEventUpdate(evt *calendar.Event, p *DateTimeParser, submission map[string]any) (*UpdateEventRequest, map[string]string) {
+	update := &UpdateEventRequest{}
+	fieldErrors := map[string]string{}
+
+	subject, _ := submission["subject"].(string)
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		fieldErrors["subject"] = "The title is required."
+	} else if subject != evt.Summary {
+		update.Subject = &subject
+	}
+
+	location, _ := submission["location"].(string)
+	location = strings.TrimSpace(location)
+	if location != evt.Location {
+		update.Location = &location
+	}
+
+	currentStart, currentEnd, _, err := dialogEventTimes(evt, p.Location)
+	if err != nil {
+		fieldErrors["start"] = err.Error()
+		return nil, fieldErrors
+	}
+
+	for _, field := range []struct {
+		Name    string
+		Current string
+		Value   **string
+		IsEnd   bool
+	}{
+		{Name: "start", Current: currentStart, Value: &update.Start},
+		{Name: "end", Current: currentEnd, Value: &update.End, IsEnd: true},
+	} {
+		value, _ := submission[field.Name].(string)
+		value = strings.TrimSpace(value)
+		if value == field.Current {
+			continue
+		}
+		parsed, err := parseDialogTime(value, field.IsEnd, p)
+		if err != nil {
+			fieldErrors[field.Name] = err.Error()
+			continue
+		}
+		*field.Value = &parsed
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	return update, nil
+}
+
+// parseDialogTime converts a time of the edit dialog to the form of change
+// requests. Besides the formats shown in the dialog, times such as "tomorrow
+// 3pm" are read. A date is an all-day value, whose Google end is the day
+// after.
+func parseDialogTime(value string, isEnd bool, p *DateTimeParser) (string, error) {
+	t, err := time.ParseInLocation(dialogTimeFormat, value, p.Location)
+	if err == nil {
+		return t.Format(time.RFC3339), nil
+	}
+
+	t, allDay, err := p.ParseDateTime(value)
+	if err != nil {
+		return "", errors.New("enter a time as YYYY-MM-DD HH:MM, or a date as YYYY-MM-DD for all-day events")
+	}
+	if !allDay {
+		return t.Format(time.RFC3339), nil
+	}
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Format(dialogDateFormat), nil
+}
+
+// calendarLocation returns the timezone of a calendar, where quick add reads
+// the times of the text
+func calendarLocation(cal *Calendar) *time.Location {
+	loc, err := time.LoadLocation(cal.TimeZone)
+	if err != nil || cal.TimeZone == "" {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestDialogEventUpdate(t *testing.T) {
+	parser, _ := newTestDateTimeParser(t)
+
+	timed := &calendar.Event{
+		Summary:  "Lunch with Ana",
+		Location: "Café",
+		Start:    &calendar.EventDateTime{DateTime: "2026-10-17T10:30:00Z"},
+		End:      &calendar.EventDateTime{DateTime: "2026-10-17T11:30:00Z"},
+	}
+	allDay := &calendar.Event{
+		Summary: "Offsite",
+		Start:   &calendar.EventDateTime{Date: "2026-10-19"},
+		End:     &calendar.EventDateTime{Date: "2026-10-21"},
+	}
+
+	for _, tc := range []struct {
+		Name       string
+		Event      *calendar.Event
+		Submission map[string]any
+		Check      func(t *testing.T, update *UpdateEventRequest)
+		Errors     []string
+	}{
+		{
+			Name:  "unchanged fields are left out",
+			Event: timed,
+			Submission: map[string]any{
+				"subject": "Lunch with Ana", "start": "2026-10-17 12:30", "end": "2026-10-17 13:30", "location": "Café",
+			},
+			Check: func(t *testing.T, update *UpdateEventRequest) {
+				require.Equal(t, &UpdateEventRequest{}, update)
+			},
+		},
+		{
+			Name:  "times are read in the calendar timezone",
+			Event: timed,
+			Submission: map[string]any{
+				"subject": "Lunch with Ana", "start": "2026-10-17 13:00", "end": "2026-10-17 13:30", "location": "",
+			},
+			Check: func(t *testing.T, update *UpdateEventRequest) {
+				require.Equal(t, "2026-10-17T13:00:00+02:00", *update.Start)
+				require.Nil(t, update.End)
+				require.Equal(t, "", *update.Location)
+			},
+		},
+		{
+			Name:  "times are read with the user's week start",
+			Event: timed,
+			Submission: map[string]any{
+				"subject": "Lunch with Ana", "start": "next sun 10:00", "end": "next sun 11:00", "location": "Café",
+			},
+			Check: func(t *testing.T, update *UpdateEventRequest) {
+				require.Equal(t, "2026-10-25T10:00:00+01:00", *update.Start)
+				require.Equal(t, "2026-10-25T11:00:00+01:00", *update.End)
+			},
+		},
+		{
+			Name:  "all-day end is the last day",
+			Event: allDay,
+			Submission: map[string]any{
+				"subject": "Team offsite", "start": "2026-10-19", "end": "2026-10-21",
+			},
+			Check: func(t *testing.T, update *UpdateEventRequest) {
+				require.Equal(t, "Team offsite", *update.Subject)
+				require.Nil(t, update.Start)
+				require.Equal(t, "2026-10-22", *update.End)
+			},
+		},
+		{
+			Name:  "invalid fields",
+			Event: timed,
+			Submission: map[string]any{
+				"subject": " ", "start": "someday", "end": "2026-10-17 13:30",
+			},
+			Errors: []string{"subject", "start"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			update, fieldErrors := dialogEventUpdate(tc.Event, parser, tc.Submission)
+			if tc.Errors != nil {
+				require.Nil(t, update)
+				for _, field := range tc.Errors {
+					require.Contains(t, fieldErrors, field)
+				}
+				return
+			}
+			require.Empty(t, fieldErrors)
+			tc.Check(t, update)
+		})
+	}
+}
+
+func TestSplitWhen(t *testing.T) {
+	parser, _ := newTestDateTimeParser(t)
+
+	for _, tc := range []struct {
+		Text    string
+		When    string
+		Subject string
+	}{
+		{Text: "next Tue 2-3pm Sprint planning", When: "next Tue 2-3pm", Subject: "Sprint planning"},
+		{Text: "tomorrow 9:30 for 45m Retro with the team", When: "tomorrow 9:30 for 45m", Subject: "Retro with the team"},
+		{Text: "in 2 days Offsite", When: "in 2 days", Subject: "Offsite"},
+		{Text: "Fri 10 Standup 2", When: "Fri 10", Subject: "Standup 2"},
+		{Text: "Sprint planning next Tue", When: "", Subject: ""},
+		{Text: "tomorrow 9:30", When: "", Subject: ""},
+	} {
+		t.Run(tc.Text, func(t *testing.T) {
+			when, subject := splitWhen(parser, tc.Text)
+			require.Equal(t, tc.When, when)
+			require.Equal(t, tc.Subject, subject)
+		})
+	}
+}
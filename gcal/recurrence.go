@@ -0,0 +1,284 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	RecurrenceDaily   = "daily"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+	RecurrenceYearly  = "yearly"
+
+	// Monthly events repeat on the same date, or on the same weekday of the
+	// month, such as the second Tuesday
+	MonthlyByDate = "date"
+	MonthlyByDay  = "day"
+)
+
+// rawRecurrencePrefixes are the iCalendar lines Google accepts in recurrence
+var rawRecurrencePrefixes = []string{"RRULE:", "EXRULE:", "RDATE", "EXDATE"}
+
+var rruleWeekdays = map[string]string{
+	"mo": "MO", "monday": "MO",
+	"tu": "TU", "tuesday": "TU",
+	"we": "WE", "wednesday": "WE",
+	"th": "TH", "thursday": "TH",
+	"fr": "FR", "friday": "FR",
+	"sa": "SA", "saturday": "SA",
+	"su": "SU", "sunday": "SU",
+}
+
+// RecurrenceRequest describes how an event repeats. Rules takes raw
+// RRULE, EXRULE, RDATE and EXDATE lines, alone or on top of the frequency.
+// The other fields build the rule of the frequency, raw rules alone carry
+// their own interval and end.
+type RecurrenceRequest struct {
+	// Frequency is daily, weekly, monthly or yearly
+	Frequency string `json:"frequency,omitempty"`
+
+	// Interval repeats the event every n days, weeks, months or years
+	Interval int `json:"interval,omitempty"`
+
+	// Weekdays are the days weekly events happen on, as names (monday) or
+	// iCalendar codes (MO). The weekday of the start is used when empty.
+	Weekdays []string `json:"weekdays,omitempty"`
+
+	// MonthlyBy is date (the default) or day
+	MonthlyBy string `json:"monthly_by,omitempty"`
+
+	// Until is the last date (2006-01-02) the event may happen on, Count
+	// the number of occurrences. At most one of them is set.
+	Until string `json:"until,omitempty"`
+	Count int    `json:"count,omitempty"`
+
+	Rules []string `json:"rules,omitempty"`
+}
+
+// Lines returns the recurrence lines of an event starting at start, in the
+// form of the Google event recurrence field
+func (rr *RecurrenceRequest) Lines(start time.Time, allDay bool) ([]string, error) {
+	lines := []string{}
+
+	if rr.Frequency == "" && (rr.Interval != 0 || rr.Until != "" || rr.Count != 0 || len(rr.Weekdays) > 0 || rr.MonthlyBy != "") {
+		return nil, errors.New("interval, weekdays, monthly_by, until and count need a frequency, put them in the RRULE of the rules instead")
+	}
+
+	if rr.Frequency != "" {
+		rule, err := rr.rrule(start, allDay)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, rule)
+	}
+
+	for _, line := range rr.Rules {
+		line = strings.TrimSpace(line)
+		if !hasRawRecurrencePrefix(line) {
+			return nil, errors.Errorf("invalid recurrence rule %q, rules start with RRULE:, EXRULE:, RDATE or EXDATE", line)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return nil, errors.New("recurrence needs a frequency or rules")
+	}
+	return lines, nil
+}
+
+func (rr *RecurrenceRequest) rrule(start time.Time, allDay bool) (string, error) {
+	parts := []string{}
+
+	switch rr.Frequency {
+	case RecurrenceDaily, RecurrenceYearly:
+		parts = append(parts, "FREQ="+strings.ToUpper(rr.Frequency))
+	case RecurrenceWeekly:
+		days, err := rr.weekdays(start)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "FREQ=WEEKLY", "BYDAY="+strings.Join(days, ","))
+	case RecurrenceMonthly:
+		parts = append(parts, "FREQ=MONTHLY")
+		switch rr.MonthlyBy {
+		case "", MonthlyByDate:
+			parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", start.Day()))
+		case MonthlyByDay:
+			parts = append(parts, "BYDAY="+monthlyWeekday(start))
+		default:
+			return "", errors.Errorf("monthly_by must be %s or %s", MonthlyByDate, MonthlyByDay)
+		}
+	default:
+		return "", errors.Errorf("frequency must be %s, %s, %s or %s", RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly, RecurrenceYearly)
+	}
+
+	if rr.Interval < 0 {
+		return "", errors.New("interval cannot be negative")
+	}
+	if rr.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", rr.Interval))
+	}
+
+	if rr.Until != "" && rr.Count != 0 {
+		return "", errors.New("recurrence takes an until date or a count, not both")
+	}
+	if rr.Count < 0 {
+		return "", errors.New("count cannot be negative")
+	}
+	if rr.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", rr.Count))
+	}
+	if rr.Until != "" {
+		until, err := time.ParseInLocation("2006-01-02", rr.Until, start.Location())
+		if err != nil {
+			return "", errors.Wrap(err, "invalid until date")
+		}
+		if until.Before(startOfDay(start)) {
+			return "", errors.New("until date is before the start of the event")
+		}
+		parts = append(parts, "UNTIL="+formatRRuleUntil(until, allDay))
+	}
+
+	return "RRULE:" + strings.Join(parts, ";"), nil
+}
+
+func (rr *RecurrenceRequest) weekdays(start time.Time) ([]string, error) {
+	if len(rr.Weekdays) == 0 {
+		return []string{rruleWeekday(start.Weekday())}, nil
+	}
+
+	days := []string{}
+	for _, day := range rr.Weekdays {
+		code, ok := rruleWeekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			return nil, errors.Errorf("unknown weekday %q", day)
+		}
+		days = append(days, code)
+	}
+	return days, nil
+}
+
+// formatRRuleUntil returns the UNTIL value that includes the whole until
+// day. It is a date for all-day events and a UTC time otherwise.
+func formatRRuleUntil(until time.Time, allDay bool) string {
+	if allDay {
+		return until.Format("20060102")
+	}
+	return endOfDay(until).UTC().Format("20060102T150405Z")
+}
+
+// monthlyWeekday returns the BYDAY value for the weekday of the month of t,
+// such as 2TU. The fifth weekday, which some months lack, becomes the last.
+func monthlyWeekday(t time.Time) string {
+	n := (t.Day()-1)/7 + 1
+	if n == 5 {
+		n = -1
+	}
+	return fmt.Sprintf("%d%s", n, rruleWeekday(t.Weekday()))
+}
+
+func rruleWeekday(day time.Weekday) string {
+	return strings.ToUpper(day.String()[:2])
+}
+
+func hasRawRecurrencePrefix(line string) bool {
+	upper := strings.ToUpper(line)
+	for _, prefix := range rawRecurrencePrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateRecurrence ends the RRULE lines of a recurrence at until, an
+// UNTIL value, in place of the until or count they had
+func truncateRecurrence(lines []string, until string) []string {
+	out := []string{}
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.ToUpper(line), "RRULE:") {
+			out = append(out, line)
+			continue
+		}
+
+		parts := []string{}
+		for _, part := range strings.Split(line[len("RRULE:"):], ";") {
+			name := strings.ToUpper(strings.SplitN(part, "=", 2)[0])
+			if name == "UNTIL" || name == "COUNT" {
+				continue
+			}
+			parts = append(parts, part)
+		}
+		parts = append(parts, "UNTIL="+until)
+		out = append(out, "RRULE:"+strings.Join(parts, ";"))
+	}
+	return out
+}
+
+// recurrenceCount returns the COUNT of the RRULE lines of a recurrence, zero
+// when they have none
+func recurrenceCount(lines []string) int {
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.ToUpper(line), "RRULE:") {
+			continue
+		}
+		for _, part := range strings.Split(line[len("RRULE:"):], ";") {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) == 2 && strings.ToUpper(kv[0]) == "COUNT" {
+				count, _ := strconv.Atoi(kv[1])
+				return count
+			}
+		}
+	}
+	return 0
+}
+
+// withRecurrenceCount sets the COUNT of the RRULE lines that have one
+func withRecurrenceCount(lines []string, count int) []string {
+	out := []string{}
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.ToUpper(line), "RRULE:") {
+			out = append(out, line)
+			continue
+		}
+
+		parts := strings.Split(line[len("RRULE:"):], ";")
+		for i, part := range parts {
+			if strings.HasPrefix(strings.ToUpper(part), "COUNT=") {
+				parts[i] = fmt.Sprintf("COUNT=%d", count)
+			}
+		}
+		out = append(out, "RRULE:"+strings.Join(parts, ";"))
+	}
+	return out
+}
+
+// untilBefore returns the UNTIL value that ends a recurrence right before an
+// occurrence originally starting at start
+func untilBefore(start *calendar.EventDateTime) (string, error) {
+	if start == nil {
+		return "", errors.New("the occurrence has no start")
+	}
+	if start.Date != "" {
+		t, err := time.Parse("2006-01-02", start.Date)
+		if err != nil {
+			return "", err
+		}
+		return t.AddDate(0, 0, -1).Format("20060102"), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, start.DateTime)
+	if err != nil {
+		return "", err
+	}
+	return t.Add(-time.Second).UTC().Format("20060102T150405Z"), nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestRecurrenceLines(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Tuesday, the second of the month
+	start := time.Date(2024, time.January, 9, 10, 0, 0, 0, newYork)
+
+	for _, tc := range []struct {
+		Name       string
+		Recurrence RecurrenceRequest
+		AllDay     bool
+		Expected   []string
+		Error      bool
+	}{
+		{
+			Name:       "daily with count",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceDaily, Count: 10},
+			Expected:   []string{"RRULE:FREQ=DAILY;COUNT=10"},
+		},
+		{
+			Name:       "weekly defaults to the weekday of the start",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceWeekly},
+			Expected:   []string{"RRULE:FREQ=WEEKLY;BYDAY=TU"},
+		},
+		{
+			Name:       "weekly on chosen weekdays every two weeks",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceWeekly, Weekdays: []string{"monday", "WE", "Fr"}, Interval: 2},
+			Expected:   []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR;INTERVAL=2"},
+		},
+		{
+			Name:       "monthly by date",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceMonthly},
+			Expected:   []string{"RRULE:FREQ=MONTHLY;BYMONTHDAY=9"},
+		},
+		{
+			Name:       "monthly by day",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceMonthly, MonthlyBy: MonthlyByDay},
+			Expected:   []string{"RRULE:FREQ=MONTHLY;BYDAY=2TU"},
+		},
+		{
+			Name:       "until includes the whole day, in UTC",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceDaily, Until: "2024-01-31"},
+			Expected:   []string{"RRULE:FREQ=DAILY;UNTIL=20240201T045959Z"},
+		},
+		{
+			Name:       "until is a date for all-day events",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceDaily, Until: "2024-01-31"},
+			AllDay:     true,
+			Expected:   []string{"RRULE:FREQ=DAILY;UNTIL=20240131"},
+		},
+		{
+			Name:       "raw rules are kept",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceWeekly, Rules: []string{"EXDATE;TZID=America/New_York:20240116T100000"}},
+			Expected:   []string{"RRULE:FREQ=WEEKLY;BYDAY=TU", "EXDATE;TZID=America/New_York:20240116T100000"},
+		},
+		{
+			Name:       "until and count together",
+			Recurrence: RecurrenceRequest{Frequency: RecurrenceDaily, Until: "2024-01-31", Count: 3},
+			Error:      true,
+		},
+		{
+			Name:       "count with raw rules alone",
+			Recurrence: RecurrenceRequest{Rules: []string{"RRULE:FREQ=DAILY"}, Count: 3},
+			Error:      true,
+		},
+		{
+			Name:       "invalid raw rule",
+			Recurrence: RecurrenceRequest{Rules: []string{"FREQ=DAILY"}},
+			Error:      true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			lines, err := tc.Recurrence.Lines(start, tc.AllDay)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, lines)
+		})
+	}
+}
+
+func TestTruncateRecurrence(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Lines    []string
+		Start    *calendar.EventDateTime
+		Expected []string
+	}{
+		{
+			Name:     "timed event ends the second before the occurrence",
+			Lines:    []string{"RRULE:FREQ=WEEKLY;BYDAY=TU"},
+			Start:    &calendar.EventDateTime{DateTime: "2024-01-16T10:00:00-05:00"},
+			Expected: []string{"RRULE:FREQ=WEEKLY;BYDAY=TU;UNTIL=20240116T145959Z"},
+		},
+		{
+			Name:     "all-day event ends the day before the occurrence",
+			Lines:    []string{"RRULE:FREQ=DAILY"},
+			Start:    &calendar.EventDateTime{Date: "2024-03-01"},
+			Expected: []string{"RRULE:FREQ=DAILY;UNTIL=20240229"},
+		},
+		{
+			Name:     "until and count are replaced, other lines are kept",
+			Lines:    []string{"EXDATE;VALUE=DATE:20240110", "RRULE:FREQ=DAILY;COUNT=10", "RRULE:FREQ=MONTHLY;UNTIL=20250101T000000Z;BYMONTHDAY=1"},
+			Start:    &calendar.EventDateTime{Date: "2024-01-12"},
+			Expected: []string{"EXDATE;VALUE=DATE:20240110", "RRULE:FREQ=DAILY;UNTIL=20240111", "RRULE:FREQ=MONTHLY;BYMONTHDAY=1;UNTIL=20240111"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			until, err := untilBefore(tc.Start)
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, truncateRecurrence(tc.Lines, until))
+		})
+	}
+}
+
+func TestRecurrenceCount(t *testing.T) {
+	lines := []string{"EXDATE;VALUE=DATE:20240110", "RRULE:FREQ=DAILY;COUNT=10;INTERVAL=2"}
+	require.Equal(t, 10, recurrenceCount(lines))
+	require.Equal(t, []string{"EXDATE;VALUE=DATE:20240110", "RRULE:FREQ=DAILY;COUNT=4;INTERVAL=2"}, withRecurrenceCount(lines, 4))
+
+	require.Equal(t, 0, recurrenceCount([]string{"RRULE:FREQ=DAILY"}))
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const (
+	// ReminderInterval is how often due reminders are sent
+	ReminderInterval = time.Minute
+
+	// reminderLookahead is how far ahead events are read, the earliest
+	// reminder Google Calendar allows
+	reminderLookahead = maxReminderMinutes * time.Minute
+
+	// ownRemindersListedBefore is how long before their start the events
+	// with their own reminders are listed for the base plugin. Its status
+	// sync looks a few minutes ahead, and it sends its fixed reminder about
+	// five minutes before start, which these events replace with theirs.
+	ownRemindersListedBefore = 4 * time.Minute
+
+	// reminderScheduleMaxAge is how long the events of a reminder schedule
+	// are used before the calendars are read again. The schedules are
+	// refreshed by the change notifications of the watched calendars, and
+	// when the selected calendars change, so this only catches a missed
+	// notification. Reading the weeks of events up to the earliest reminder
+	// more often would be a lot of calls for the few reminders due.
+	reminderScheduleMaxAge = 24 * time.Hour
+
+	// unwatchedReminderScheduleMaxAge is the age of the schedules of users
+	// without calendar watches, which no notification refreshes, and of the
+	// schedules missing a calendar that could not be read
+	unwatchedReminderScheduleMaxAge = time.Hour
+
+	// A reminder missed by more than reminderLateness is skipped
+	reminderLateness = 5 * time.Minute
+
+	reminderSentKeyPrefix = "gcal_reminder_sent_"
+
+	// The limits of Google Calendar
+	maxReminders       = 5
+	maxReminderMinutes = 40320
+)
+
+// EventReminders sends the reminders of events at the times the events
+// chose. Users get a direct message for the events with their own
+// reminders instead of the fixed reminder of the base plugin, which is left
+// the events using the calendar defaults. Channels get a post for every event of their
+// linked calendar, at the times of the event or of the calendar defaults.
+type EventReminders struct {
+	Env              engine.Env
+	Store            Store
+	API              plugin.API
+	ChannelCalendars *ChannelCalendars
+
+	// makeClient is replaced in tests
+	makeClient func(mattermostUserID string) (*client, error)
+}
+
+// NewEventReminders creates a new event reminders sender
+func NewEventReminders(env engine.Env, store Store, api plugin.API, channelCalendars *ChannelCalendars) *EventReminders {
+	return &EventReminders{
+		Env:              env,
+		Store:            store,
+		API:              api,
+		ChannelCalendars: channelCalendars,
+
+		makeClient: userClientMaker(env),
+	}
+}
+
+// SendDue sends the reminders due at now. Every reminder is claimed before
+// it is sent, so that late or overlapping runs do not send it twice. The
+// events come from the reminder schedules, Google is only called for the
+// schedules to refresh.
+func (er *EventReminders) SendDue(now time.Time) {
+	users, err := er.Env.Store.LoadUserIndex()
+	if err != nil {
+		er.Env.Logger.Warnf("gcal: failed to load users for reminders. err=%v", err)
+	} else {
+		for _, u := range users {
+			er.sendUserReminders(u.MattermostUserID, now)
+		}
+	}
+
+	links, err := er.Store.ListChannelCalendars()
+	if err != nil {
+		er.Env.Logger.Warnf("gcal: failed to list channel calendars for reminders. err=%v", err)
+		return
+	}
+	for _, link := range links {
+		er.sendChannelReminders(link, now)
+	}
+}
+
+func (er *EventReminders) sendUserReminders(mattermostUserID string, now time.Time) {
+	logger := er.Env.Logger.With(bot.LogContext{"mattermostUserID": mattermostUserID})
+
+	user, err := er.Env.Store.LoadUser(mattermostUserID)
+	if err != nil || !user.Settings.ReceiveReminders {
+		return
+	}
+
+	schedule, err := er.loadSchedule(mattermostUserID, now, func() (*ReminderSchedule, error) {
+		return er.buildUserSchedule(mattermostUserID, now)
+	})
+	if err != nil {
+		logger.Warnf("gcal: failed to get events for reminders. err=%v", err)
+		return
+	}
+
+	for _, event := range schedule.Events {
+		minutes, ok := dueReminder(event, now)
+		if !ok || !er.claimReminder(mattermostUserID, event.Event, minutes) {
+			continue
+		}
+
+		_, err = er.Env.Poster.DMWithAttachments(mattermostUserID, reminderAttachment(event.Event, minutes, schedule.TimeZone))
+		if err != nil {
+			logger.Warnf("gcal: failed to send event reminder. err=%v", err)
+		}
+	}
+}
+
+func (er *EventReminders) sendChannelReminders(link *ChannelCalendar, now time.Time) {
+	logger := er.Env.Logger.With(bot.LogContext{"channelID": link.ChannelID})
+
+	schedule, err := er.loadSchedule(link.ChannelID, now, func() (*ReminderSchedule, error) {
+		return er.buildChannelSchedule(link, now)
+	})
+	if err != nil {
+		logger.Warnf("gcal: failed to get events for channel reminders. err=%v", err)
+		return
+	}
+
+	for _, event := range schedule.Events {
+		minutes, ok := dueReminder(event, now)
+		if !ok || !er.claimReminder(link.ChannelID, event.Event, minutes) {
+			continue
+		}
+
+		_, appErr := er.API.CreatePost(&model.Post{
+			UserId:    er.ChannelCalendars.BotUserID,
+			ChannelId: link.ChannelID,
+			Props: model.StringInterface{
+				"attachments": []*model.SlackAttachment{reminderAttachment(event.Event, minutes, schedule.TimeZone)},
+			},
+		})
+		if appErr != nil {
+			logger.Warnf("gcal: failed to post event reminder. err=%v", appErr)
+		}
+	}
+}
+
+// loadSchedule returns the reminder schedule of a user or channel, built
+// again when it is missing or old. A change notified while it is built is
+// caught by the next refresh.
+func (er *EventReminders) loadSchedule(targetID string, now time.Time, build func() (*ReminderSchedule, error)) (*ReminderSchedule, error) {
+	schedule, err := er.Store.LoadReminderSchedule(targetID)
+	if err == nil && !now.Before(schedule.RefreshedAt) && now.Before(schedule.ExpiresAt) {
+		return schedule, nil
+	}
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	schedule, err = build()
+	if err != nil {
+		return nil, err
+	}
+
+	err = er.Store.StoreReminderSchedule(targetID, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func (er *EventReminders) buildUserSchedule(mattermostUserID string, now time.Time) (*ReminderSchedule, error) {
+	c, err := er.makeClient(mattermostUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars, err := c.SelectedCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := reminderScheduleMaxAge
+	if _, err = er.Store.LoadCalendarWatches(mattermostUserID); err == ErrNotFound {
+		maxAge = unwatchedReminderScheduleMaxAge
+	}
+	schedule := &ReminderSchedule{RefreshedAt: now, ExpiresAt: now.Add(maxAge)}
+
+	events, failed, err := c.GetCalendarEvents(calendars, now.Add(-reminderLateness), schedule.ExpiresAt.Add(reminderLookahead))
+	if err != nil {
+		return nil, err
+	}
+	// The reminders of the calendars that failed are missing until the
+	// schedule is built again
+	if len(failed) > 0 {
+		schedule.ExpiresAt = now.Add(unwatchedReminderScheduleMaxAge)
+	}
+
+	if settings, err := c.GetMailboxSettings(""); err == nil {
+		schedule.TimeZone = settings.TimeZone
+	}
+
+	for _, event := range events {
+		if !event.HasOwnReminders || event.IsCancelled || isDeclined(event.Event) {
+			continue
+		}
+		scheduleEvent(schedule, event)
+	}
+
+	return schedule, nil
+}
+
+func (er *EventReminders) buildChannelSchedule(link *ChannelCalendar, now time.Time) (*ReminderSchedule, error) {
+	c, err := er.makeClient(link.LinkedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &ReminderSchedule{
+		RefreshedAt: now,
+		ExpiresAt:   now.Add(reminderScheduleMaxAge),
+		TimeZone:    link.CalendarTimeZone,
+	}
+
+	cal := findCalendar(calendars, link.CalendarID)
+	if cal == nil {
+		return schedule, nil
+	}
+
+	events, _, err := c.GetCalendarEvents([]*Calendar{cal}, now.Add(-reminderLateness), schedule.ExpiresAt.Add(reminderLookahead))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		if !event.IsCancelled {
+			scheduleEvent(schedule, event)
+		}
+	}
+
+	return schedule, nil
+}
+
+// scheduleEvent adds an event to a schedule with the reminders due before
+// the schedule gets old, if it has any
+func scheduleEvent(schedule *ReminderSchedule, event *CalendarEvent) {
+	if event.Start == nil {
+		return
+	}
+	start := event.Start.Time()
+	earliest := schedule.RefreshedAt.Add(-reminderLateness)
+	latest := schedule.ExpiresAt
+
+	minutes := []int{}
+	for _, m := range event.ReminderMinutes {
+		remindAt := start.Add(-time.Duration(m) * time.Minute)
+		if !remindAt.Before(earliest) && !remindAt.After(latest) {
+			minutes = append(minutes, m)
+		}
+	}
+
+	if len(minutes) > 0 {
+		schedule.Events = append(schedule.Events, &ScheduledEvent{
+			Event:           event.Event,
+			ReminderMinutes: minutes,
+		})
+	}
+}
+
+// claimReminder reports whether the reminder of an event is still to be
+// sent to the target, marking it as sent
+func (er *EventReminders) claimReminder(targetID string, event *remote.Event, minutes int) bool {
+	sum := sha256.Sum256([]byte(targetID + event.ID + event.Start.Time().Format(time.RFC3339) + strconv.Itoa(minutes)))
+	key := reminderSentKeyPrefix + hex.EncodeToString(sum[:16])
+
+	// The claim only has to outlive the lateness, after which the reminder
+	// is no longer due
+	claimed, appErr := er.API.KVSetWithOptions(key, []byte("1"), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(2 * reminderLateness / time.Second),
+	})
+	if appErr != nil {
+		er.Env.Logger.Warnf("gcal: failed to claim event reminder. err=%v", appErr)
+		return false
+	}
+	return claimed
+}
+
+// dueReminder returns the reminder of an event due at now, which is the
+// latest one that passed within the allowed lateness
+func dueReminder(event *ScheduledEvent, now time.Time) (int, bool) {
+	if event.Event.Start == nil {
+		return 0, false
+	}
+	start := event.Event.Start.Time()
+
+	due, found := 0, false
+	for _, minutes := range event.ReminderMinutes {
+		remindAt := start.Add(-time.Duration(minutes) * time.Minute)
+		if remindAt.After(now) || now.Sub(remindAt) > reminderLateness {
+			continue
+		}
+		if !found || minutes < due {
+			due, found = minutes, true
+		}
+	}
+	return due, found
+}
+
+func isDeclined(event *remote.Event) bool {
+	return event.ResponseStatus != nil && event.ResponseStatus.Response == remote.EventResponseStatusDeclined
+}
+
+func reminderAttachment(event *remote.Event, minutes int, timeZone string) *model.SlackAttachment {
+	subject := event.Subject
+	if subject == "" {
+		subject = "(No title)"
+	}
+
+	pretext := "Event starting now"
+	switch {
+	case minutes >= 60*24 && minutes%(60*24) == 0:
+		pretext = fmt.Sprintf("Event in %d day(s)", minutes/(60*24))
+	case minutes >= 60 && minutes%60 == 0:
+		pretext = fmt.Sprintf("Event in %d hour(s)", minutes/60)
+	case minutes > 0:
+		pretext = fmt.Sprintf("Event in %d minute(s)", minutes)
+	}
+
+	text := formatEventTime(event, timeZone)
+	if event.Location != nil && event.Location.DisplayName != "" {
+		text += "\n" + event.Location.DisplayName
+	}
+	if event.Conference != nil && event.Conference.URL != "" {
+		name := event.Conference.Application
+		if name == "" {
+			name = "meeting"
+		}
+		text += fmt.Sprintf("\n[Join %s](%s)", name, event.Conference.URL)
+	}
+
+	return &model.SlackAttachment{
+		Pretext:   pretext,
+		Title:     subject,
+		TitleLink: event.Weblink,
+		Text:      text,
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestDueReminder(t *testing.T) {
+	start := time.Date(2024, time.January, 9, 10, 0, 0, 0, time.UTC)
+	event := &ScheduledEvent{
+		Event:           &remote.Event{Start: remote.NewDateTime(start, "UTC")},
+		ReminderMinutes: []int{60, 10, 5},
+	}
+
+	for _, tc := range []struct {
+		Name     string
+		Now      time.Time
+		Expected int
+		Due      bool
+	}{
+		{Name: "before the first reminder", Now: start.Add(-61 * time.Minute)},
+		{Name: "at the first reminder", Now: start.Add(-60 * time.Minute), Expected: 60, Due: true},
+		{Name: "late within the lateness", Now: start.Add(-57 * time.Minute), Expected: 60, Due: true},
+		{Name: "between reminders", Now: start.Add(-30 * time.Minute)},
+		{Name: "latest of two passed reminders", Now: start.Add(-4 * time.Minute), Expected: 5, Due: true},
+		{Name: "after the event started", Now: start.Add(10 * time.Minute)},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			minutes, due := dueReminder(event, tc.Now)
+			require.Equal(t, tc.Due, due)
+			require.Equal(t, tc.Expected, minutes)
+		})
+	}
+}
+
+func TestEventRemindersChannelSchedule(t *testing.T) {
+	g := newFakeGoogle(t, &calendar.CalendarListEntry{Id: testTeamCalendarID, Summary: "Team", AccessRole: AccessRoleWriter})
+
+	now := time.Now().Truncate(time.Minute)
+	lists := 0
+	g.handle("GET calendars/"+testTeamCalendarID+"/events", func(w http.ResponseWriter, _ *http.Request) {
+		lists++
+		_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{{
+			Id:      "event1",
+			Summary: "Standup",
+			Start:   &calendar.EventDateTime{DateTime: now.Add(10 * time.Minute).Format(time.RFC3339)},
+			End:     &calendar.EventDateTime{DateTime: now.Add(25 * time.Minute).Format(time.RFC3339)},
+			Reminders: &calendar.EventReminders{
+				Overrides: []*calendar.EventReminder{{Method: googleReminderPopup, Minutes: 10}},
+			},
+		}}})
+	})
+
+	api := newTestAPI(t)
+	posts := 0
+	api.On("CreatePost", mock.Anything).Run(func(mock.Arguments) { posts++ }).Return(&model.Post{}, nil)
+
+	cc := NewChannelCalendars(newTestEnv(nil), NewStore(api), api, "bot")
+	er := NewEventReminders(newTestEnv(nil), NewStore(api), api, cc)
+	er.makeClient = g.makeClient(api)
+	link := &ChannelCalendar{ChannelID: "channel1", CalendarID: testTeamCalendarID, LinkedBy: "admin"}
+
+	er.sendChannelReminders(link, now)
+	require.Equal(t, 1, lists)
+	require.Equal(t, 1, posts)
+
+	er.sendChannelReminders(link, now.Add(time.Minute))
+	require.Equal(t, 1, lists, "the schedule is used between runs")
+	require.Equal(t, 1, posts, "a reminder is sent once")
+
+	cc.forgetReminders("channel1")
+	er.sendChannelReminders(link, now.Add(2*time.Minute))
+	require.Equal(t, 2, lists, "a change notification refreshes the schedule")
+
+	er.sendChannelReminders(link, now.Add(2*time.Minute+reminderScheduleMaxAge))
+	require.Equal(t, 3, lists, "an old schedule is refreshed")
+	require.Equal(t, 1, posts)
+}
+
+func TestEventRemindersUserScheduleAge(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleReader},
+	)
+	g.handle("GET users/me/settings/timezone", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&calendar.Setting{Id: "timezone", Value: "UTC"})
+	})
+	lists := 0
+	g.handle("GET calendars/me@example.com/events", func(w http.ResponseWriter, _ *http.Request) {
+		lists++
+		_ = json.NewEncoder(w).Encode(&calendar.Events{})
+	})
+	teamFails := false
+	g.handle("GET calendars/team/events", func(w http.ResponseWriter, _ *http.Request) {
+		if teamFails {
+			http.Error(w, `{"error":{"code":403,"message":"Forbidden"}}`, http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&calendar.Events{})
+	})
+
+	api := newTestAPI(t)
+	er := NewEventReminders(newTestEnv(nil), NewStore(api), api, nil)
+	er.makeClient = g.makeClient(api)
+	require.NoError(t, er.Store.StoreUserSettings("user1", &UserSettings{SelectedCalendarIDs: []string{"me@example.com", "team"}}))
+
+	now := time.Now().Truncate(time.Minute)
+	load := func(at time.Time) {
+		_, err := er.loadSchedule("user1", at, func() (*ReminderSchedule, error) {
+			return er.buildUserSchedule("user1", at)
+		})
+		require.NoError(t, err)
+	}
+
+	load(now)
+	load(now.Add(unwatchedReminderScheduleMaxAge))
+	require.Equal(t, 2, lists, "the schedules of users without watches are refreshed often")
+
+	require.NoError(t, er.Store.StoreCalendarWatches("user1", &CalendarWatches{SubscriptionID: "sub1"}))
+	require.NoError(t, er.Store.DeleteReminderSchedule("user1"))
+	load(now)
+	load(now.Add(unwatchedReminderScheduleMaxAge))
+	require.Equal(t, 3, lists, "change notifications refresh the schedules of watched users")
+	load(now.Add(reminderScheduleMaxAge))
+	require.Equal(t, 4, lists)
+
+	teamFails = true
+	require.NoError(t, er.Store.DeleteReminderSchedule("user1"))
+	load(now)
+	load(now.Add(unwatchedReminderScheduleMaxAge))
+	require.Equal(t, 6, lists, "the schedules missing a calendar are refreshed often")
+}
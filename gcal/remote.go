@@ -23,6 +23,9 @@ const Kind = "gcal"
 type impl struct {
 	conf   *config.Config
 	logger bot.Logger
+	store  Store
+
+	maxEventsPerCalendar int
 }
 
 func init() {
@@ -36,14 +39,33 @@ func NewRemote(conf *config.Config, logger bot.Logger) remote.Remote {
 	}
 }
 
+// NewRemoteMaker returns a remote maker whose clients keep the gcal specific
+// user data, such as the selected calendars, in the given store, and read
+// at most maxEventsPerCalendar events from a calendar in one query.
+func NewRemoteMaker(store Store, maxEventsPerCalendar int) func(*config.Config, bot.Logger) remote.Remote {
+	return func(conf *config.Config, logger bot.Logger) remote.Remote {
+		return &impl{
+			conf:   conf,
+			logger: logger,
+			store:  store,
+
+			maxEventsPerCalendar: maxEventsPerCalendar,
+		}
+	}
+}
+
 // MakeUserClient creates a new client for user-delegated permissions.
-func (r *impl) MakeUserClient(ctx context.Context, token *oauth2.Token, _ string, _ bot.Poster, _ remote.UserTokenHelpers) remote.Client {
+func (r *impl) MakeUserClient(ctx context.Context, token *oauth2.Token, mattermostUserID string, _ bot.Poster, _ remote.UserTokenHelpers) remote.Client {
 	httpClient := r.NewOAuth2Config().Client(ctx, token)
 	c := &client{
-		conf:       r.conf,
-		ctx:        ctx,
-		httpClient: httpClient,
-		Logger:     r.logger,
+		conf:             r.conf,
+		ctx:              ctx,
+		httpClient:       httpClient,
+		mattermostUserID: mattermostUserID,
+		store:            r.store,
+		Logger:           r.logger,
+
+		maxEventsPerCalendar: r.maxEventsPerCalendar,
 	}
 	return c
 }
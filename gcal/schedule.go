@@ -4,9 +4,189 @@
 package gcal
 
 import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
-func (c *client) GetSchedule(_ []*remote.ScheduleUserInfo, _, _ *remote.DateTime, _ int) ([]*remote.ScheduleInformation, error) {
-	return nil, remote.ErrNotImplemented
+const (
+	// freeBusyMaxCalendars is the most calendars a free/busy query takes
+	freeBusyMaxCalendars = 50
+
+	scheduleStatusBusy = "busy"
+)
+
+// GetSchedule returns when the requested people are busy between two times,
+// from the free/busy information of Google Calendar. The user's own schedule
+// covers their selected calendars, the schedule of someone else their
+// primary calendar, whose ID is their email. The availability view has a
+// digit per interval of availabilityViewInterval minutes, busy or free.
+func (c *client) GetSchedule(requests []*remote.ScheduleUserInfo, startTime, endTime *remote.DateTime, availabilityViewInterval int) ([]*remote.ScheduleInformation, error) {
+	start, end := startTime.Time(), endTime.Time()
+	if !end.After(start) {
+		return nil, errors.New("gcal GetSchedule, the end must be after the start")
+	}
+
+	settings, err := c.loadUserSettings()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetSchedule, error loading user settings")
+	}
+	calendars, err := c.cachedCalendars()
+	if err != nil {
+		return nil, err
+	}
+	ownEmail := ""
+	if primary := findCalendar(calendars, defaultCalendarName); primary != nil {
+		ownEmail = primary.ID
+	}
+
+	scheduleCalendarIDs := make([][]string, len(requests))
+	calendarIDs := []string{}
+	queried := map[string]bool{}
+	for i, req := range requests {
+		ids := []string{req.Mail}
+		if ownEmail != "" && strings.EqualFold(req.Mail, ownEmail) {
+			ids = []string{}
+			for _, cal := range filterSelectedCalendars(calendars, settings.SelectedCalendarIDs) {
+				ids = append(ids, cal.ID)
+			}
+		}
+		scheduleCalendarIDs[i] = ids
+
+		for _, id := range ids {
+			if !queried[id] {
+				queried[id] = true
+				calendarIDs = append(calendarIDs, id)
+			}
+		}
+	}
+
+	freeBusy, err := c.queryFreeBusy(calendarIDs, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*remote.ScheduleInformation, 0, len(requests))
+	for i, req := range requests {
+		out = append(out, scheduleInformation(req.Mail, scheduleCalendarIDs[i], freeBusy, startTime, endTime, availabilityViewInterval))
+	}
+
+	return out, nil
+}
+
+// queryFreeBusy returns the free/busy information of calendars by calendar ID,
+// querying them in batches of freeBusyMaxCalendars
+func (c *client) queryFreeBusy(calendarIDs []string, start, end time.Time) (map[string]calendar.FreeBusyCalendar, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetSchedule, error creating service")
+	}
+
+	out := map[string]calendar.FreeBusyCalendar{}
+	for len(calendarIDs) > 0 {
+		batch := calendarIDs[:min(freeBusyMaxCalendars, len(calendarIDs))]
+		calendarIDs = calendarIDs[len(batch):]
+
+		items := make([]*calendar.FreeBusyRequestItem, 0, len(batch))
+		for _, id := range batch {
+			items = append(items, &calendar.FreeBusyRequestItem{Id: id})
+		}
+		res, err := service.Freebusy.Query(&calendar.FreeBusyRequest{
+			TimeMin: start.Format(time.RFC3339),
+			TimeMax: end.Format(time.RFC3339),
+			Items:   items,
+		}).Do()
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal GetSchedule, error querying free/busy information")
+		}
+
+		for id, fb := range res.Calendars {
+			out[id] = fb
+		}
+	}
+
+	return out, nil
+}
+
+// scheduleInformation merges the busy times of the calendars of a schedule.
+// Calendars Google has no free/busy information of, such as the calendars of
+// people outside the domain that are not shared, are left out, and the
+// schedule is an error when none of them has any.
+func scheduleInformation(scheduleID string, calendarIDs []string, freeBusy map[string]calendar.FreeBusyCalendar, startTime, endTime *remote.DateTime, availabilityViewInterval int) *remote.ScheduleInformation {
+	info := &remote.ScheduleInformation{ScheduleID: scheduleID}
+
+	reason := "notFound"
+	busy := []*calendar.TimePeriod{}
+	found := false
+	for _, id := range calendarIDs {
+		fb, ok := freeBusy[id]
+		if !ok {
+			continue
+		}
+		if len(fb.Errors) > 0 {
+			reason = fb.Errors[0].Reason
+			continue
+		}
+		found = true
+		busy = append(busy, fb.Busy...)
+	}
+	if !found {
+		info.Error = &remote.ScheduleInformationError{
+			Message:      "no free/busy information for " + scheduleID,
+			ResponseCode: reason,
+		}
+		return info
+	}
+
+	type period struct{ start, end time.Time }
+	periods := []period{}
+	for _, p := range busy {
+		periodStart, err := time.Parse(time.RFC3339, p.Start)
+		if err != nil {
+			continue
+		}
+		periodEnd, err := time.Parse(time.RFC3339, p.End)
+		if err != nil {
+			continue
+		}
+		periods = append(periods, period{periodStart, periodEnd})
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].start.Before(periods[j].start)
+	})
+
+	start, end := startTime.Time(), endTime.Time()
+	info.ScheduleItems = make([]*remote.ScheduleItem, 0, len(periods))
+	for _, p := range periods {
+		info.ScheduleItems = append(info.ScheduleItems, &remote.ScheduleItem{
+			Start:  remote.NewDateTime(p.start.In(start.Location()), startTime.TimeZone),
+			End:    remote.NewDateTime(p.end.In(start.Location()), startTime.TimeZone),
+			Status: scheduleStatusBusy,
+		})
+	}
+
+	if availabilityViewInterval > 0 {
+		interval := time.Duration(availabilityViewInterval) * time.Minute
+		view := []byte{}
+		for slot := start; slot.Before(end); slot = slot.Add(interval) {
+			status := byte(remote.AvailabilityViewFree)
+			for _, p := range periods {
+				if p.start.Before(slot.Add(interval)) && p.end.After(slot) {
+					status = remote.AvailabilityViewBusy
+					break
+				}
+			}
+			view = append(view, status)
+		}
+		info.AvailabilityView = string(view)
+	}
+
+	return info
 }
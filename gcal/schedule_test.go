@@ -0,0 +1,63 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestGetSchedule(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleWriter},
+		&calendar.CalendarListEntry{Id: "holidays", AccessRole: AccessRoleReader},
+	)
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+	require.NoError(t, c.store.StoreUserSettings("user1", &UserSettings{SelectedCalendarIDs: []string{"me@example.com", "team"}}))
+
+	queried := []string{}
+	g.handle("POST freeBusy", func(w http.ResponseWriter, r *http.Request) {
+		req := &calendar.FreeBusyRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		for _, item := range req.Items {
+			queried = append(queried, item.Id)
+		}
+		_ = json.NewEncoder(w).Encode(&calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			"me@example.com":  {Busy: []*calendar.TimePeriod{{Start: "2024-03-04T10:00:00Z", End: "2024-03-04T10:30:00Z"}}},
+			"team":            {Busy: []*calendar.TimePeriod{{Start: "2024-03-04T11:00:00Z", End: "2024-03-04T12:00:00Z"}}},
+			"ana@example.com": {Busy: []*calendar.TimePeriod{{Start: "2024-03-04T10:15:00Z", End: "2024-03-04T10:45:00Z"}}},
+			"bob@example.org": {Errors: []*calendar.Error{{Domain: "global", Reason: "notFound"}}},
+		}})
+	})
+
+	schedules, err := c.GetSchedule(
+		[]*remote.ScheduleUserInfo{{Mail: "me@example.com"}, {Mail: "ana@example.com"}, {Mail: "bob@example.org"}},
+		&remote.DateTime{DateTime: "2024-03-04T10:00:00", TimeZone: "UTC"},
+		&remote.DateTime{DateTime: "2024-03-04T12:00:00", TimeZone: "UTC"},
+		30,
+	)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"me@example.com", "team", "ana@example.com", "bob@example.org"}, queried, "the user's own schedule covers their selected calendars")
+	require.Len(t, schedules, 3)
+
+	require.Equal(t, "me@example.com", schedules[0].ScheduleID)
+	require.Equal(t, "2022", schedules[0].AvailabilityView)
+	require.Len(t, schedules[0].ScheduleItems, 2)
+	require.Equal(t, "2024-03-04T11:00:00", schedules[0].ScheduleItems[1].Start.DateTime)
+
+	require.Equal(t, "2200", schedules[1].AvailabilityView)
+	require.Nil(t, schedules[1].Error)
+
+	require.NotNil(t, schedules[2].Error)
+	require.Equal(t, "notFound", schedules[2].Error.ResponseCode)
+}
@@ -0,0 +1,594 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+const (
+	userSettingsKeyPrefix     = "gcal_user_settings_"
+	channelCalendarKeyPrefix  = "gcal_channel_calendar_"
+	calendarSharesKeyPrefix   = "gcal_calendar_shares_"
+	calendarWatchesKeyPrefix  = "gcal_calendar_watches_"
+	reminderScheduleKeyPrefix = "gcal_reminder_schedule_"
+	calendarListKeyPrefix     = "gcal_calendar_list_"
+
+	// The share index lists the targets of each shared calendar, so the
+	// shares of a calendar are found without listing all the keys
+	calendarShareIndexKeyPrefix = "gcal_calendar_share_index_"
+	calendarShareIndexBuiltKey  = "gcal_calendar_share_index_built"
+
+	listKeysPerPage = 1000
+)
+
+// ErrNotFound is returned when a stored item does not exist
+var ErrNotFound = errors.New("not found")
+
+// UserSettings holds the Google Calendar specific preferences of a user,
+// which have no place in the mscalendar user settings.
+type UserSettings struct {
+	// SelectedCalendarIDs are the calendars included in the user's event
+	// views. Only the primary calendar is included when empty.
+	SelectedCalendarIDs []string `json:"selected_calendar_ids,omitempty"`
+
+	// DefaultCalendarID is the calendar new events are created on. The
+	// primary calendar is used when empty.
+	DefaultCalendarID string `json:"default_calendar_id,omitempty"`
+}
+
+// SelectCalendar adds a calendar to the user's event views. Selecting a first
+// calendar keeps the primary calendar, which was shown while nothing was selected.
+func (settings *UserSettings) SelectCalendar(calendarID, primaryCalendarID string) {
+	if len(settings.SelectedCalendarIDs) == 0 && primaryCalendarID != "" && primaryCalendarID != calendarID {
+		settings.SelectedCalendarIDs = []string{primaryCalendarID}
+	}
+
+	for _, id := range settings.SelectedCalendarIDs {
+		if id == calendarID {
+			return
+		}
+	}
+	settings.SelectedCalendarIDs = append(settings.SelectedCalendarIDs, calendarID)
+}
+
+// RemoveCalendar drops a calendar from the user's preferences, and reports
+// whether anything changed
+func (settings *UserSettings) RemoveCalendar(calendarID string) bool {
+	changed := false
+	if settings.DefaultCalendarID == calendarID {
+		settings.DefaultCalendarID = ""
+		changed = true
+	}
+
+	selected := []string{}
+	for _, id := range settings.SelectedCalendarIDs {
+		if id == calendarID {
+			changed = true
+			continue
+		}
+		selected = append(selected, id)
+	}
+	settings.SelectedCalendarIDs = selected
+
+	return changed
+}
+
+// WatchChannel is a Google push notification channel
+type WatchChannel struct {
+	ID         string `json:"id"`
+	ResourceID string `json:"resource_id"`
+	Token      string `json:"token"`
+	CalendarID string `json:"calendar_id"`
+	// Expiration is in milliseconds since the epoch, as returned by Google
+	Expiration int64 `json:"expiration"`
+}
+
+// ExpiresAt returns the time Google stops sending notifications on the channel
+func (watch *WatchChannel) ExpiresAt() time.Time {
+	return time.UnixMilli(watch.Expiration)
+}
+
+// CalendarWatches are the watch channels on the selected calendars of a user,
+// which all report to the user's event subscription. The subscription itself
+// watches the primary calendar and is stored by the base plugin.
+type CalendarWatches struct {
+	SubscriptionID  string `json:"subscription_id"`
+	ClientState     string `json:"client_state"`
+	NotificationURL string `json:"notification_url"`
+
+	// Watches maps the IDs of the watched secondary calendars to their channel
+	Watches map[string]*WatchChannel `json:"watches"`
+
+	// LastSyncTimes maps calendar IDs to the update time of the last change
+	// delivered, and DeliveredEventIDs to the events delivered with that
+	// update time
+	LastSyncTimes     map[string]time.Time `json:"last_sync_times,omitempty"`
+	DeliveredEventIDs map[string][]string  `json:"delivered_event_ids,omitempty"`
+
+	// CalendarListWatch watches the calendar list of the user, and
+	// KnownCalendarIDs is the list as of its last notification
+	CalendarListWatch *WatchChannel `json:"calendar_list_watch,omitempty"`
+	KnownCalendarIDs  []string      `json:"known_calendar_ids,omitempty"`
+}
+
+// FindWatch returns the watch channel with the given ID, if any
+func (cw *CalendarWatches) FindWatch(channelID string) *WatchChannel {
+	for _, watch := range cw.Watches {
+		if watch.ID == channelID {
+			return watch
+		}
+	}
+	return nil
+}
+
+// ChannelCalendar is a Google calendar linked to a Mattermost channel
+type ChannelCalendar struct {
+	ChannelID        string `json:"channel_id"`
+	CalendarID       string `json:"calendar_id"`
+	CalendarName     string `json:"calendar_name"`
+	CalendarTimeZone string `json:"calendar_time_zone,omitempty"`
+
+	// LinkedBy is the channel admin whose Google account watches the calendar
+	LinkedBy string `json:"linked_by"`
+
+	Watch *WatchChannel `json:"watch,omitempty"`
+
+	// LastSyncTime is when the calendar changes were last posted to the channel
+	LastSyncTime time.Time `json:"last_sync_time"`
+}
+
+// CalendarShare shares a calendar with the members of a channel or a group
+type CalendarShare struct {
+	CalendarID   string `json:"calendar_id"`
+	CalendarName string `json:"calendar_name"`
+	Role         string `json:"role"`
+
+	// Only one of ChannelID and GroupID is set
+	ChannelID string `json:"channel_id,omitempty"`
+	GroupID   string `json:"group_id,omitempty"`
+
+	// SharedBy is the calendar owner whose Google account manages the access rules
+	SharedBy string `json:"shared_by"`
+
+	// Members maps the Mattermost users granted access by the share to the
+	// Google email the access rule was created for
+	Members map[string]string `json:"members"`
+
+	// PriorRoles maps the members to the role they had on the calendar apart
+	// from the plugin's shares, empty when the plugin created their access
+	// rule. Removing a member from the share gives them that role back.
+	PriorRoles map[string]string `json:"prior_roles,omitempty"`
+}
+
+// TargetID returns the ID of the channel or group the calendar is shared with
+func (share *CalendarShare) TargetID() string {
+	if share.GroupID != "" {
+		return share.GroupID
+	}
+	return share.ChannelID
+}
+
+// ReminderSchedule holds the upcoming events of a user or of a linked
+// channel, with the reminders due before it is refreshed. The reminder job
+// reads it every minute instead of the calendars, which are read again when
+// it gets old or when a change notification of the calendars deletes it.
+type ReminderSchedule struct {
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+
+	// TimeZone is the timezone the event times are shown in
+	TimeZone string `json:"time_zone,omitempty"`
+
+	Events []*ScheduledEvent `json:"events"`
+}
+
+// ScheduledEvent is an event of a reminder schedule
+type ScheduledEvent struct {
+	Event           *remote.Event `json:"event"`
+	ReminderMinutes []int         `json:"reminder_minutes"`
+}
+
+// CachedCalendarList is the calendar list of a user as read at RefreshedAt,
+// which the event views and syncs use instead of listing the calendars for
+// every read
+type CachedCalendarList struct {
+	RefreshedAt time.Time   `json:"refreshed_at"`
+	Calendars   []*Calendar `json:"calendars"`
+}
+
+// Store persists the gcal specific plugin data in the plugin KV store
+type Store interface {
+	LoadUserSettings(mattermostUserID string) (*UserSettings, error)
+	StoreUserSettings(mattermostUserID string, settings *UserSettings) error
+
+	LoadChannelCalendar(channelID string) (*ChannelCalendar, error)
+	StoreChannelCalendar(link *ChannelCalendar) error
+	DeleteChannelCalendar(channelID string) error
+	ListChannelCalendars() ([]*ChannelCalendar, error)
+
+	LoadCalendarShares(targetID string) ([]*CalendarShare, error)
+	StoreCalendarShares(targetID string, shares []*CalendarShare) error
+	ListCalendarShareTargets() ([]string, error)
+	LoadCalendarShareIndex(calendarID string) ([]string, error)
+	AddCalendarShareIndex(calendarID, targetID string) error
+	RemoveCalendarShareIndex(calendarID, targetID string) error
+	IsCalendarShareIndexBuilt() (bool, error)
+	StoreCalendarShareIndexBuilt() error
+
+	LoadCalendarWatches(mattermostUserID string) (*CalendarWatches, error)
+	StoreCalendarWatches(mattermostUserID string, watches *CalendarWatches) error
+	DeleteCalendarWatches(mattermostUserID string) error
+	LockCalendarWatches(mattermostUserID string) (unlock func(), err error)
+
+	LoadReminderSchedule(targetID string) (*ReminderSchedule, error)
+	StoreReminderSchedule(targetID string, schedule *ReminderSchedule) error
+	DeleteReminderSchedule(targetID string) error
+
+	LoadCalendarList(mattermostUserID string) (*CachedCalendarList, error)
+	StoreCalendarList(mattermostUserID string, list *CachedCalendarList) error
+	DeleteCalendarList(mattermostUserID string) error
+}
+
+type pluginStore struct {
+	api plugin.API
+}
+
+// NewStore creates a store backed by the plugin KV store
+func NewStore(api plugin.API) Store {
+	return &pluginStore{api: api}
+}
+
+func (s *pluginStore) LoadUserSettings(mattermostUserID string) (*UserSettings, error) {
+	settings := &UserSettings{}
+	err := s.loadJSON(userSettingsKeyPrefix+mattermostUserID, settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load user settings")
+	}
+	return settings, nil
+}
+
+// StoreUserSettings stores the settings of a user, and drops their reminder
+// schedule, which was built from the calendars selected before
+func (s *pluginStore) StoreUserSettings(mattermostUserID string, settings *UserSettings) error {
+	err := s.storeJSON(userSettingsKeyPrefix+mattermostUserID, settings)
+	if err != nil {
+		return errors.Wrap(err, "failed to store user settings")
+	}
+	return s.DeleteReminderSchedule(mattermostUserID)
+}
+
+func (s *pluginStore) LoadChannelCalendar(channelID string) (*ChannelCalendar, error) {
+	link := &ChannelCalendar{}
+	err := s.loadJSON(channelCalendarKeyPrefix+channelID, link)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load channel calendar")
+	}
+	if link.CalendarID == "" {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+func (s *pluginStore) StoreChannelCalendar(link *ChannelCalendar) error {
+	err := s.storeJSON(channelCalendarKeyPrefix+link.ChannelID, link)
+	if err != nil {
+		return errors.Wrap(err, "failed to store channel calendar")
+	}
+	return nil
+}
+
+func (s *pluginStore) DeleteChannelCalendar(channelID string) error {
+	if appErr := s.api.KVDelete(channelCalendarKeyPrefix + channelID); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete channel calendar")
+	}
+	return nil
+}
+
+func (s *pluginStore) ListChannelCalendars() ([]*ChannelCalendar, error) {
+	keys, err := s.listKeys(channelCalendarKeyPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list channel calendars")
+	}
+
+	links := []*ChannelCalendar{}
+	for _, key := range keys {
+		link, err := s.LoadChannelCalendar(strings.TrimPrefix(key, channelCalendarKeyPrefix))
+		if err != nil {
+			continue
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// LoadCalendarShares returns the calendars shared with a channel or group
+func (s *pluginStore) LoadCalendarShares(targetID string) ([]*CalendarShare, error) {
+	shares := []*CalendarShare{}
+	err := s.loadJSON(calendarSharesKeyPrefix+targetID, &shares)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load calendar shares")
+	}
+	return shares, nil
+}
+
+func (s *pluginStore) StoreCalendarShares(targetID string, shares []*CalendarShare) error {
+	if len(shares) == 0 {
+		if appErr := s.api.KVDelete(calendarSharesKeyPrefix + targetID); appErr != nil {
+			return errors.Wrap(appErr, "failed to delete calendar shares")
+		}
+		return nil
+	}
+
+	err := s.storeJSON(calendarSharesKeyPrefix+targetID, shares)
+	if err != nil {
+		return errors.Wrap(err, "failed to store calendar shares")
+	}
+	return nil
+}
+
+// ListCalendarShareTargets returns the channels and groups calendars are shared with
+func (s *pluginStore) ListCalendarShareTargets() ([]string, error) {
+	keys, err := s.listKeys(calendarSharesKeyPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list calendar shares")
+	}
+
+	targetIDs := []string{}
+	for _, key := range keys {
+		targetIDs = append(targetIDs, strings.TrimPrefix(key, calendarSharesKeyPrefix))
+	}
+	return targetIDs, nil
+}
+
+// LoadCalendarShareIndex returns the channels and groups a calendar is shared with
+func (s *pluginStore) LoadCalendarShareIndex(calendarID string) ([]string, error) {
+	targetIDs := []string{}
+	err := s.loadJSON(calendarShareIndexKey(calendarID), &targetIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load calendar share index")
+	}
+	return targetIDs, nil
+}
+
+// AddCalendarShareIndex records that a calendar is shared with a channel or group
+func (s *pluginStore) AddCalendarShareIndex(calendarID, targetID string) error {
+	return s.updateCalendarShareIndex(calendarID, func(targetIDs []string) []string {
+		for _, existing := range targetIDs {
+			if existing == targetID {
+				return nil
+			}
+		}
+		return append(targetIDs, targetID)
+	})
+}
+
+// RemoveCalendarShareIndex records that a calendar is no longer shared with a
+// channel or group
+func (s *pluginStore) RemoveCalendarShareIndex(calendarID, targetID string) error {
+	return s.updateCalendarShareIndex(calendarID, func(targetIDs []string) []string {
+		remaining := []string{}
+		for _, existing := range targetIDs {
+			if existing != targetID {
+				remaining = append(remaining, existing)
+			}
+		}
+		if len(remaining) == len(targetIDs) {
+			return nil
+		}
+		return remaining
+	})
+}
+
+// updateCalendarShareIndex changes the targets of a calendar under a cluster
+// lock, update returning nil when there is nothing to store
+func (s *pluginStore) updateCalendarShareIndex(calendarID string, update func(targetIDs []string) []string) error {
+	key := calendarShareIndexKey(calendarID)
+	unlock, err := lockCluster(s.api, "gcal_calendar_share_index_lock_"+strings.TrimPrefix(key, calendarShareIndexKeyPrefix))
+	if err != nil {
+		return errors.Wrap(err, "failed to lock calendar share index")
+	}
+	defer unlock()
+
+	targetIDs, err := s.LoadCalendarShareIndex(calendarID)
+	if err != nil {
+		return err
+	}
+
+	targetIDs = update(targetIDs)
+	if targetIDs == nil {
+		return nil
+	}
+
+	if len(targetIDs) == 0 {
+		if appErr := s.api.KVDelete(key); appErr != nil {
+			return errors.Wrap(appErr, "failed to delete calendar share index")
+		}
+		return nil
+	}
+
+	err = s.storeJSON(key, targetIDs)
+	if err != nil {
+		return errors.Wrap(err, "failed to store calendar share index")
+	}
+	return nil
+}
+
+// IsCalendarShareIndexBuilt returns whether the shares stored before the share
+// index existed have been added to it
+func (s *pluginStore) IsCalendarShareIndexBuilt() (bool, error) {
+	data, appErr := s.api.KVGet(calendarShareIndexBuiltKey)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to load calendar share index state")
+	}
+	return data != nil, nil
+}
+
+func (s *pluginStore) StoreCalendarShareIndexBuilt() error {
+	if appErr := s.api.KVSet(calendarShareIndexBuiltKey, []byte("true")); appErr != nil {
+		return errors.Wrap(appErr, "failed to store calendar share index state")
+	}
+	return nil
+}
+
+// calendarShareIndexKey hashes the calendar ID, which may be longer than a key allows
+func calendarShareIndexKey(calendarID string) string {
+	sum := sha256.Sum256([]byte(calendarID))
+	return calendarShareIndexKeyPrefix + hex.EncodeToString(sum[:16])
+}
+
+// LoadCalendarWatches returns the watch channels of a user, or ErrNotFound
+// when the user has no event subscription
+func (s *pluginStore) LoadCalendarWatches(mattermostUserID string) (*CalendarWatches, error) {
+	watches := &CalendarWatches{}
+	err := s.loadJSON(calendarWatchesKeyPrefix+mattermostUserID, watches)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load calendar watches")
+	}
+	if watches.SubscriptionID == "" {
+		return nil, ErrNotFound
+	}
+	if watches.Watches == nil {
+		watches.Watches = map[string]*WatchChannel{}
+	}
+	if watches.LastSyncTimes == nil {
+		watches.LastSyncTimes = map[string]time.Time{}
+	}
+	if watches.DeliveredEventIDs == nil {
+		watches.DeliveredEventIDs = map[string][]string{}
+	}
+	return watches, nil
+}
+
+func (s *pluginStore) StoreCalendarWatches(mattermostUserID string, watches *CalendarWatches) error {
+	err := s.storeJSON(calendarWatchesKeyPrefix+mattermostUserID, watches)
+	if err != nil {
+		return errors.Wrap(err, "failed to store calendar watches")
+	}
+	return nil
+}
+
+func (s *pluginStore) DeleteCalendarWatches(mattermostUserID string) error {
+	if appErr := s.api.KVDelete(calendarWatchesKeyPrefix + mattermostUserID); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete calendar watches")
+	}
+	return nil
+}
+
+// LockCalendarWatches serializes the changes of the watches of a user across
+// the cluster, around their load and store
+func (s *pluginStore) LockCalendarWatches(mattermostUserID string) (unlock func(), err error) {
+	return lockCluster(s.api, "gcal_calendar_watches_lock_"+mattermostUserID)
+}
+
+// LoadReminderSchedule returns the reminder schedule of a user or channel, or
+// ErrNotFound when it has to be built
+func (s *pluginStore) LoadReminderSchedule(targetID string) (*ReminderSchedule, error) {
+	schedule := &ReminderSchedule{}
+	err := s.loadJSON(reminderScheduleKeyPrefix+targetID, schedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load reminder schedule")
+	}
+	if schedule.RefreshedAt.IsZero() {
+		return nil, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (s *pluginStore) StoreReminderSchedule(targetID string, schedule *ReminderSchedule) error {
+	err := s.storeJSON(reminderScheduleKeyPrefix+targetID, schedule)
+	if err != nil {
+		return errors.Wrap(err, "failed to store reminder schedule")
+	}
+	return nil
+}
+
+func (s *pluginStore) DeleteReminderSchedule(targetID string) error {
+	if appErr := s.api.KVDelete(reminderScheduleKeyPrefix + targetID); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete reminder schedule")
+	}
+	return nil
+}
+
+// LoadCalendarList returns the cached calendar list of a user, or
+// ErrNotFound when it has to be read from Google
+func (s *pluginStore) LoadCalendarList(mattermostUserID string) (*CachedCalendarList, error) {
+	list := &CachedCalendarList{}
+	err := s.loadJSON(calendarListKeyPrefix+mattermostUserID, list)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load calendar list")
+	}
+	if list.RefreshedAt.IsZero() {
+		return nil, ErrNotFound
+	}
+	return list, nil
+}
+
+func (s *pluginStore) StoreCalendarList(mattermostUserID string, list *CachedCalendarList) error {
+	err := s.storeJSON(calendarListKeyPrefix+mattermostUserID, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to store calendar list")
+	}
+	return nil
+}
+
+func (s *pluginStore) DeleteCalendarList(mattermostUserID string) error {
+	if appErr := s.api.KVDelete(calendarListKeyPrefix + mattermostUserID); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete calendar list")
+	}
+	return nil
+}
+
+// listKeys returns all the keys starting with the given prefix
+func (s *pluginStore) listKeys(prefix string) ([]string, error) {
+	keys := []string{}
+	for page := 0; ; page++ {
+		pageKeys, appErr := s.api.KVList(page, listKeysPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < listKeysPerPage {
+			return keys, nil
+		}
+	}
+}
+
+// loadJSON leaves v untouched when the key does not exist
+func (s *pluginStore) loadJSON(key string, v interface{}) error {
+	data, appErr := s.api.KVGet(key)
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *pluginStore) storeJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if appErr := s.api.KVSet(key, data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
@@ -6,8 +6,10 @@ package gcal
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
@@ -22,22 +24,24 @@ const defaultCalendarName = "primary"
 const googleSubscriptionType = "webhook"
 const subscriptionSuffix = "_calendar_event_notifications_"
 
-// CreateMySubscription creates a subscription for the user's calendar
+// calendarWatchPrefix starts the IDs of the watch channels on secondary
+// calendars, which route the notifications back to the user's subscription
+const calendarWatchPrefix = "gcalsub"
+
+// calendarListWatchPrefix starts the IDs of the watch channels on the
+// calendar lists of users
+const calendarListWatchPrefix = "gcallist"
+
+// CreateMySubscription creates a subscription for the user's calendars. The
+// subscription watches the primary calendar, and one more watch channel is
+// opened for each of the other selected calendars.
 func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*remote.Subscription, error) {
 	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateMySubscription, error creating service")
 	}
 
-	reqBody := &calendar.Channel{
-		Id:      remoteUserID + subscriptionSuffix + newRandomString(),
-		Token:   newRandomString(),
-		Type:    googleSubscriptionType,
-		Address: notificationURL,
-		Params: map[string]string{
-			"ttl": fmt.Sprintf("%d", int64(subscribeTTL.Seconds())),
-		},
-	}
+	reqBody := newWatchChannel(remoteUserID+subscriptionSuffix+newRandomString(), notificationURL)
 
 	createSubscriptionRequest := service.Events.Watch(defaultCalendarName, reqBody).EventTypes("default")
 	googleSubscription, err := createSubscriptionRequest.Do()
@@ -51,7 +55,7 @@ func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*re
 		Resource:   defaultCalendarName,
 		// ChangeType:         "created,updated,deleted",
 		NotificationURL:    notificationURL,
-		ExpirationDateTime: time.Now().Add(time.Second * time.Duration(googleSubscription.Expiration)).Format(time.RFC3339),
+		ExpirationDateTime: time.UnixMilli(googleSubscription.Expiration).Format(time.RFC3339),
 		ClientState:        reqBody.Token,
 		CreatorID:          remoteUserID,
 	}
@@ -63,6 +67,13 @@ func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*re
 		"expirationDateTime": sub.ExpirationDateTime,
 	}).Debugf("gcal: created subscription.")
 
+	err = c.watchSelectedCalendars(sub)
+	if err != nil {
+		c.Logger.With(bot.LogContext{
+			"subscriptionID": sub.ID,
+		}).Warnf("gcal: failed to watch selected calendars. err=%v", err)
+	}
+
 	return sub, nil
 }
 
@@ -73,16 +84,13 @@ func (c *client) DeleteSubscription(sub *remote.Subscription) error {
 		return errors.Wrap(err, "gcal DeleteSubscription, error creating service")
 	}
 
-	stopRequest := service.Channels.Stop(&calendar.Channel{
-		Id:         sub.ID,
-		ResourceId: sub.ResourceID,
-	})
-	err = stopRequest.Do()
-
+	err = stopWatchChannel(service, sub.ID, sub.ResourceID)
 	if err != nil {
 		return errors.Wrap(err, "gcal DeleteSubscription, error from google response")
 	}
 
+	c.stopCalendarWatches(sub.ID)
+
 	c.Logger.With(bot.LogContext{
 		"subscriptionID": sub.ID,
 	}).Debugf("gcal: deleted subscription.")
@@ -107,6 +115,276 @@ func (c *client) RenewSubscription(notificationURL, remoteUserID string, oldSub
 	return sub, nil
 }
 
+// SyncCalendarWatches opens and stops watch channels so that all the selected
+// calendars of a subscribed user are watched. Users without a subscription
+// have nothing to sync.
+func (c *client) SyncCalendarWatches() error {
+	if c.store == nil {
+		return nil
+	}
+
+	unlock, err := c.store.LockCalendarWatches(c.mattermostUserID)
+	if err != nil {
+		return errors.Wrap(err, "gcal SyncCalendarWatches, error locking calendar watches")
+	}
+	defer unlock()
+
+	watches, err := c.store.LoadCalendarWatches(c.mattermostUserID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "gcal SyncCalendarWatches, error loading calendar watches")
+	}
+
+	calendars, err := c.SelectedCalendars()
+	if err != nil {
+		return err
+	}
+
+	// The primary calendar is watched by the subscription itself
+	selected := map[string]bool{}
+	for _, cal := range calendars {
+		if !cal.Primary {
+			selected[cal.ID] = true
+		}
+	}
+
+	for calendarID, watch := range watches.Watches {
+		if selected[calendarID] {
+			continue
+		}
+		if err = c.StopWatch(watch); err != nil {
+			c.Logger.With(bot.LogContext{
+				"calendarID": calendarID,
+				"channelID":  watch.ID,
+			}).Warnf("gcal: failed to stop calendar watch. err=%v", err)
+		}
+		delete(watches.Watches, calendarID)
+		delete(watches.LastSyncTimes, calendarID)
+		delete(watches.DeliveredEventIDs, calendarID)
+	}
+
+	var watchErr error
+	for calendarID := range selected {
+		if watches.Watches[calendarID] != nil {
+			continue
+		}
+		watch, err := c.WatchCalendarEvents(calendarID, newCalendarWatchID(c.mattermostUserID), watches.NotificationURL)
+		if err != nil {
+			watchErr = err
+			continue
+		}
+		watches.Watches[calendarID] = watch
+		watches.LastSyncTimes[calendarID] = time.Now()
+	}
+
+	err = c.store.StoreCalendarWatches(c.mattermostUserID, watches)
+	if err != nil {
+		return errors.Wrap(err, "gcal SyncCalendarWatches, error storing calendar watches")
+	}
+
+	return watchErr
+}
+
+// watchSelectedCalendars starts tracking the watch channels of a new
+// subscription, replacing those of the previous one
+func (c *client) watchSelectedCalendars(sub *remote.Subscription) error {
+	if c.store == nil {
+		return nil
+	}
+
+	c.stopCalendarWatches("")
+
+	watches := &CalendarWatches{
+		SubscriptionID:  sub.ID,
+		ClientState:     sub.ClientState,
+		NotificationURL: sub.NotificationURL,
+		Watches:         map[string]*WatchChannel{},
+		LastSyncTimes: map[string]time.Time{
+			defaultCalendarName: time.Now(),
+		},
+	}
+
+	// The calendar list is only watched for subscribed users, whose
+	// selected calendars are watched too
+	err := c.watchCalendarList(watches)
+	if err != nil {
+		c.Logger.With(bot.LogContext{
+			"subscriptionID": sub.ID,
+		}).Warnf("gcal: failed to watch calendar list. err=%v", err)
+	}
+
+	err = c.store.StoreCalendarWatches(c.mattermostUserID, watches)
+	if err != nil {
+		return errors.Wrap(err, "gcal CreateMySubscription, error storing calendar watches")
+	}
+
+	return c.SyncCalendarWatches()
+}
+
+// stopCalendarWatches stops the watch channels that report to a subscription,
+// or to any subscription when subscriptionID is empty
+func (c *client) stopCalendarWatches(subscriptionID string) {
+	if c.store == nil {
+		return
+	}
+
+	watches, err := c.store.LoadCalendarWatches(c.mattermostUserID)
+	if err != nil || (subscriptionID != "" && watches.SubscriptionID != subscriptionID) {
+		return
+	}
+
+	for calendarID, watch := range watches.Watches {
+		if err = c.StopWatch(watch); err != nil {
+			c.Logger.With(bot.LogContext{
+				"calendarID": calendarID,
+				"channelID":  watch.ID,
+			}).Warnf("gcal: failed to stop calendar watch. err=%v", err)
+		}
+	}
+
+	if watches.CalendarListWatch != nil {
+		if err = c.StopWatch(watches.CalendarListWatch); err != nil {
+			c.Logger.With(bot.LogContext{
+				"channelID": watches.CalendarListWatch.ID,
+			}).Warnf("gcal: failed to stop calendar list watch. err=%v", err)
+		}
+	}
+
+	err = c.store.DeleteCalendarWatches(c.mattermostUserID)
+	if err != nil {
+		c.Logger.Warnf("gcal: failed to delete calendar watches. err=%v", err)
+	}
+}
+
+// watchCalendarList opens a push notification channel on the calendar list of
+// the user, and records the calendars it holds now
+func (c *client) watchCalendarList(watches *CalendarWatches) error {
+	calendars, err := c.ListCalendars()
+	if err != nil {
+		return err
+	}
+
+	watches.KnownCalendarIDs = []string{}
+	for _, cal := range calendars {
+		watches.KnownCalendarIDs = append(watches.KnownCalendarIDs, cal.ID)
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal watchCalendarList, error creating service")
+	}
+
+	reqBody := newWatchChannel(newCalendarListWatchID(c.mattermostUserID), c.conf.PluginURL+PathCalendarListWebhook)
+	googleChannel, err := service.CalendarList.Watch(reqBody).Do()
+	if err != nil {
+		return errors.Wrap(err, "gcal watchCalendarList, error creating watch channel")
+	}
+
+	watches.CalendarListWatch = &WatchChannel{
+		ID:         googleChannel.Id,
+		ResourceID: googleChannel.ResourceId,
+		Token:      reqBody.Token,
+		Expiration: googleChannel.Expiration,
+	}
+
+	return nil
+}
+
+// WatchCalendarEvents opens a push notification channel on the events of a
+// calendar, delivering notifications to the given address
+func (c *client) WatchCalendarEvents(calendarID, channelID, notificationURL string) (*WatchChannel, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal WatchCalendarEvents, error creating service")
+	}
+
+	reqBody := newWatchChannel(channelID, notificationURL)
+	googleChannel, err := service.Events.Watch(calendarID, reqBody).EventTypes("default").Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal WatchCalendarEvents, error creating watch channel")
+	}
+
+	watch := &WatchChannel{
+		ID:         googleChannel.Id,
+		ResourceID: googleChannel.ResourceId,
+		Token:      reqBody.Token,
+		CalendarID: calendarID,
+		Expiration: googleChannel.Expiration,
+	}
+
+	c.Logger.With(bot.LogContext{
+		"channelID":  watch.ID,
+		"calendarID": calendarID,
+		"expiration": watch.ExpiresAt().Format(time.RFC3339),
+	}).Debugf("gcal: created watch channel.")
+
+	return watch, nil
+}
+
+// StopWatch closes a push notification channel
+func (c *client) StopWatch(watch *WatchChannel) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal StopWatch, error creating service")
+	}
+
+	err = stopWatchChannel(service, watch.ID, watch.ResourceID)
+	if err != nil {
+		return errors.Wrap(err, "gcal StopWatch, error from google response")
+	}
+
+	return nil
+}
+
+// newCalendarWatchID returns a unique watch channel ID that routes the
+// notifications back to the subscription of a Mattermost user
+func newCalendarWatchID(mattermostUserID string) string {
+	return calendarWatchPrefix + "_" + mattermostUserID + "_" + model.NewId()
+}
+
+func mattermostUserIDFromCalendarWatchID(watchID string) string {
+	parts := strings.Split(watchID, "_")
+	if len(parts) != 3 || parts[0] != calendarWatchPrefix {
+		return ""
+	}
+	return parts[1]
+}
+
+// newCalendarListWatchID returns a unique watch channel ID for the calendar
+// list of a Mattermost user
+func newCalendarListWatchID(mattermostUserID string) string {
+	return calendarListWatchPrefix + "_" + mattermostUserID + "_" + model.NewId()
+}
+
+func mattermostUserIDFromCalendarListWatchID(watchID string) string {
+	parts := strings.Split(watchID, "_")
+	if len(parts) != 3 || parts[0] != calendarListWatchPrefix {
+		return ""
+	}
+	return parts[1]
+}
+
+func newWatchChannel(channelID, notificationURL string) *calendar.Channel {
+	return &calendar.Channel{
+		Id:      channelID,
+		Token:   newRandomString(),
+		Type:    googleSubscriptionType,
+		Address: notificationURL,
+		Params: map[string]string{
+			"ttl": fmt.Sprintf("%d", int64(subscribeTTL.Seconds())),
+		},
+	}
+}
+
+func stopWatchChannel(service *calendar.Service, channelID, resourceID string) error {
+	return service.Channels.Stop(&calendar.Channel{
+		Id:         channelID,
+		ResourceId: resourceID,
+	}).Do()
+}
+
 // ListSubscriptions lists all subscriptions
 func (c *client) ListSubscriptions() ([]*remote.Subscription, error) {
 	return nil, errors.New("gcal ListSubscriptions not implemented. only used for debug command")
@@ -0,0 +1,66 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSyncCalendarWatches(t *testing.T) {
+	g := newFakeGoogle(t,
+		&calendar.CalendarListEntry{Id: "me@example.com", Primary: true, AccessRole: AccessRoleOwner},
+		&calendar.CalendarListEntry{Id: "team", AccessRole: AccessRoleWriter},
+		&calendar.CalendarListEntry{Id: "kept", AccessRole: AccessRoleReader},
+		&calendar.CalendarListEntry{Id: "unselected", AccessRole: AccessRoleReader},
+	)
+	g.watches["watch-kept"] = "kept"
+	g.watches["watch-unselected"] = "unselected"
+
+	api := newTestAPI(t)
+	c, err := g.makeClient(api)("user1")
+	require.NoError(t, err)
+
+	t.Run("users without subscription have no watches", func(t *testing.T) {
+		require.NoError(t, c.SyncCalendarWatches())
+		require.Len(t, g.watches, 2)
+	})
+
+	err = c.store.StoreUserSettings("user1", &UserSettings{
+		SelectedCalendarIDs: []string{"me@example.com", "team", "kept"},
+	})
+	require.NoError(t, err)
+	err = c.store.StoreCalendarWatches("user1", &CalendarWatches{
+		SubscriptionID:  "sub1",
+		NotificationURL: testPluginURL + "/webhook",
+		Watches: map[string]*WatchChannel{
+			"kept":       {ID: "watch-kept", CalendarID: "kept"},
+			"unselected": {ID: "watch-unselected", CalendarID: "unselected"},
+		},
+		LastSyncTimes: map[string]time.Time{},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.SyncCalendarWatches())
+
+	watches, err := c.store.LoadCalendarWatches("user1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"team", "kept"}, mapKeys(watches.Watches), "the primary calendar is watched by the subscription")
+	require.Equal(t, "watch-kept", watches.Watches["kept"].ID)
+	require.Equal(t, "team", g.watched(watches.Watches["team"].ID))
+	require.Equal(t, "user1", mattermostUserIDFromCalendarWatchID(watches.Watches["team"].ID))
+	require.Contains(t, watches.LastSyncTimes, "team")
+	require.Equal(t, []string{"watch-unselected"}, g.stopped)
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -6,11 +6,84 @@ package gcal
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+const (
+	dayFormat   = "Mon, Jan 2"
+	clockFormat = "15:04"
 )
 
+// isNotFound reports whether Google answered that the item does not exist
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone
+	}
+	return false
+}
+
+// isPreconditionFailed reports whether Google rejected a change because the
+// item changed since the given ETag
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusPreconditionFailed
+	}
+	return false
+}
+
 // newRandomString generates a random string used for subscription ID and token
 func newRandomString() string {
 	b := make([]byte, 96)
 	rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// lockCluster takes a lock on the key that is held across the cluster
+func lockCluster(api plugin.API, key string) (unlock func(), err error) {
+	mutex, err := cluster.NewMutex(api, key)
+	if err != nil {
+		return nil, err
+	}
+	mutex.Lock()
+	return mutex.Unlock, nil
+}
+
+// formatEventTime formats when an event happens in the given timezone, UTC
+// being used when the timezone is empty or unknown
+func formatEventTime(event *remote.Event, timeZone string) string {
+	if event.Start == nil || event.End == nil {
+		return ""
+	}
+
+	if event.IsAllDay {
+		// All-day dates carry no timezone, and Google's end date is exclusive
+		start := event.Start.Time()
+		last := event.End.Time().AddDate(0, 0, -1)
+		if !last.After(start) {
+			return start.Format(dayFormat) + " (all day)"
+		}
+		return start.Format(dayFormat) + " – " + last.Format(dayFormat) + " (all day)"
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start := event.Start.Time().In(loc)
+	end := event.End.Time().In(loc)
+
+	if start.YearDay() == end.YearDay() && start.Year() == end.Year() {
+		return start.Format(dayFormat) + ", " + start.Format(clockFormat) + " – " + end.Format(clockFormat+" MST")
+	}
+	return start.Format(dayFormat+", "+clockFormat) + " – " + end.Format(dayFormat+", "+clockFormat+" MST")
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+// Video call providers an admin can choose for new events
+const (
+	VideoCallMattermostCalls = "mattermost_calls"
+	VideoCallGoogleMeet      = "google_meet"
+	VideoCallJitsi           = "jitsi"
+	VideoCallCustom          = "custom"
+
+	defaultJitsiURL = "https://meet.jit.si"
+
+	// The shared extended properties holding the call of an event that
+	// Google does not host, read back as the event's conference
+	videoCallURLProperty      = "videoCallUrl"
+	videoCallProviderProperty = "videoCallProvider"
+)
+
+var roomNameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// VideoCallConfig is the video call part of the plugin settings
+type VideoCallConfig struct {
+	// VideoCallProvider is mattermost_calls (the default), google_meet,
+	// jitsi or custom
+	VideoCallProvider string
+
+	// VideoCallChannelID is the channel Mattermost calls take place in for
+	// events not linked to a channel
+	VideoCallChannelID string
+
+	// JitsiServerURL defaults to the public Jitsi server
+	JitsiServerURL string
+
+	// VideoCallURLTemplate is the custom call link, {room} and {subject}
+	// are replaced with the room name and the event subject
+	VideoCallURLTemplate string
+}
+
+// LoadVideoCallConfig reads the video call settings of the plugin
+func LoadVideoCallConfig(api plugin.API) (*VideoCallConfig, error) {
+	conf := &VideoCallConfig{}
+	err := api.LoadPluginConfiguration(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal LoadVideoCallConfig, error loading configuration")
+	}
+	if conf.VideoCallProvider == "" {
+		conf.VideoCallProvider = VideoCallMattermostCalls
+	}
+	if conf.JitsiServerURL == "" {
+		conf.JitsiServerURL = defaultJitsiURL
+	}
+	return conf, nil
+}
+
+// addVideoCall sets up the conference of a new event with the configured
+// provider, channelID is the channel the user linked the event to
+func (h *EventsAPIHandler) addVideoCall(mattermostUserID string, event *remote.Event, opts *EventOptions, channelID string) error {
+	conf := h.VideoCall
+	if conf == nil {
+		conf = &VideoCallConfig{VideoCallProvider: VideoCallMattermostCalls}
+	}
+
+	switch conf.VideoCallProvider {
+	case VideoCallGoogleMeet:
+		opts.AddGoogleMeet = true
+		return nil
+	case VideoCallMattermostCalls, "":
+		// The link names the team and channel, which only members may see
+		if channelID != "" && !h.API.HasPermissionToChannel(mattermostUserID, channelID, model.PermissionReadChannel) {
+			return errors.New("you cannot add a Mattermost call of a channel you do not have access to")
+		}
+		if channelID == "" {
+			channelID = conf.VideoCallChannelID
+		}
+		link, err := h.callsChannelLink(channelID)
+		if err != nil {
+			return err
+		}
+		event.Conference = &remote.Conference{URL: link, Application: "Mattermost Calls"}
+	case VideoCallJitsi:
+		event.Conference = &remote.Conference{
+			URL:         strings.TrimSuffix(conf.JitsiServerURL, "/") + "/" + newRoomName(event.Subject),
+			Application: "Jitsi Meet",
+		}
+	case VideoCallCustom:
+		if conf.VideoCallURLTemplate == "" {
+			return errors.New("the video call link template is not configured")
+		}
+		link := strings.NewReplacer(
+			"{room}", newRoomName(event.Subject),
+			"{subject}", url.QueryEscape(event.Subject),
+		).Replace(conf.VideoCallURLTemplate)
+		event.Conference = &remote.Conference{URL: link, Application: "Video call"}
+	default:
+		return errors.Errorf("unknown video call provider %q", conf.VideoCallProvider)
+	}
+
+	return nil
+}
+
+// callsChannelLink returns the link of a channel, where the call of an event
+// is started from
+func (h *EventsAPIHandler) callsChannelLink(channelID string) (string, error) {
+	if channelID == "" {
+		return "", errors.New("link the event to a channel to add a Mattermost call")
+	}
+
+	channel, appErr := h.API.GetChannel(channelID)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get call channel")
+	}
+	if channel.TeamId == "" {
+		return "", errors.New("a Mattermost call needs a team channel, direct and group messages cannot host it")
+	}
+	team, appErr := h.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get team of call channel")
+	}
+
+	return strings.TrimSuffix(h.Env.Config.MattermostSiteURL, "/") + "/" + team.Name + "/channels/" + channel.Name, nil
+}
+
+// newRoomName returns a call room name that is hard to guess and still
+// recognizable by the event subject
+func newRoomName(subject string) string {
+	name := strings.Trim(roomNameInvalidChars.ReplaceAllString(subject, "-"), "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	if name == "" {
+		return model.NewId()
+	}
+	return name + "-" + model.NewId()
+}
+
+// videoCallProperties returns the extended properties storing a call that
+// Google does not host
+func videoCallProperties(conference *remote.Conference) *calendar.EventExtendedProperties {
+	return &calendar.EventExtendedProperties{
+		Shared: map[string]string{
+			videoCallURLProperty:      conference.URL,
+			videoCallProviderProperty: conference.Application,
+		},
+	}
+}
+
+// videoCallFromProperties returns the call stored in the extended properties
+// of an event, nil when there is none
+func videoCallFromProperties(evt *calendar.Event) *remote.Conference {
+	if evt.ExtendedProperties == nil || evt.ExtendedProperties.Shared[videoCallURLProperty] == "" {
+		return nil
+	}
+	return &remote.Conference{
+		URL:         evt.ExtendedProperties.Shared[videoCallURLProperty],
+		Application: evt.ExtendedProperties.Shared[videoCallProviderProperty],
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestAddVideoCall(t *testing.T) {
+	api := newTestAPI(t)
+	api.On("HasPermissionToChannel", "user1", "town", model.PermissionReadChannel).Return(true).Maybe()
+	api.On("HasPermissionToChannel", "user1", "secret", model.PermissionReadChannel).Return(false).Maybe()
+	api.On("GetChannel", "town").Return(&model.Channel{Id: "town", Name: "town-square", TeamId: "team1"}, nil).Maybe()
+	api.On("GetTeam", "team1").Return(&model.Team{Id: "team1", Name: "team"}, nil).Maybe()
+
+	env := newTestEnv(nil)
+	env.Config.MattermostSiteURL = "https://mattermost.example.com/"
+
+	for _, tc := range []struct {
+		Name      string
+		Config    *VideoCallConfig
+		ChannelID string
+		Check     func(t *testing.T, event *remote.Event, opts *EventOptions)
+		Error     bool
+	}{
+		{
+			Name:   "google meet is created by Google",
+			Config: &VideoCallConfig{VideoCallProvider: VideoCallGoogleMeet},
+			Check: func(t *testing.T, event *remote.Event, opts *EventOptions) {
+				require.True(t, opts.AddGoogleMeet)
+				require.Nil(t, event.Conference)
+			},
+		},
+		{
+			Name:   "jitsi room is named after the subject",
+			Config: &VideoCallConfig{VideoCallProvider: VideoCallJitsi, JitsiServerURL: "https://jitsi.example.com/"},
+			Check: func(t *testing.T, event *remote.Event, opts *EventOptions) {
+				require.True(t, strings.HasPrefix(event.Conference.URL, "https://jitsi.example.com/Team-sync-"))
+				require.Equal(t, "Jitsi Meet", event.Conference.Application)
+			},
+		},
+		{
+			Name:   "custom template",
+			Config: &VideoCallConfig{VideoCallProvider: VideoCallCustom, VideoCallURLTemplate: "https://video.example.com/join?topic={subject}"},
+			Check: func(t *testing.T, event *remote.Event, opts *EventOptions) {
+				require.Equal(t, "https://video.example.com/join?topic=Team+sync%21", event.Conference.URL)
+			},
+		},
+		{
+			Name:   "custom provider without template",
+			Config: &VideoCallConfig{VideoCallProvider: VideoCallCustom},
+			Error:  true,
+		},
+		{
+			Name:      "calls in the channel of the event",
+			Config:    &VideoCallConfig{VideoCallProvider: VideoCallMattermostCalls},
+			ChannelID: "town",
+			Check: func(t *testing.T, event *remote.Event, opts *EventOptions) {
+				require.Equal(t, "https://mattermost.example.com/team/channels/town-square", event.Conference.URL)
+			},
+		},
+		{
+			Name:      "calls in a channel the user cannot read",
+			Config:    &VideoCallConfig{VideoCallProvider: VideoCallMattermostCalls},
+			ChannelID: "secret",
+			Error:     true,
+		},
+		{
+			Name:   "calls without a channel",
+			Config: &VideoCallConfig{VideoCallProvider: VideoCallMattermostCalls},
+			Error:  true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			h := &EventsAPIHandler{Env: env, API: api, VideoCall: tc.Config}
+			event := &remote.Event{Subject: "Team sync!"}
+			opts := &EventOptions{}
+
+			err := h.addVideoCall("user1", event, opts, tc.ChannelID)
+			if tc.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tc.Check(t, event, opts)
+		})
+	}
+}
+
+func TestConvertRemoteEventCallLink(t *testing.T) {
+	conference := &remote.Conference{URL: "https://meet.jit.si/Team-sync-1", Application: "Jitsi Meet"}
+	start := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	event := &remote.Event{
+		Subject:    "Team sync",
+		Start:      remote.NewDateTime(start, "UTC"),
+		End:        remote.NewDateTime(start.Add(time.Hour), "UTC"),
+		Conference: conference,
+	}
+
+	out := convertRemoteEventToGcalEvent(event, nil)
+	require.Nil(t, out.ConferenceData, "Google refuses conferences it does not create")
+	require.Equal(t, conference.URL, out.Location, "the link is the location when there is none")
+	require.Equal(t, conference, convertGCalEventToRemoteEvent(out).Conference, "the call is read back as the conference")
+
+	event.Location = &remote.Location{DisplayName: "Room 1"}
+	out = convertRemoteEventToGcalEvent(event, nil)
+	require.Equal(t, "Room 1", out.Location, "the location is kept")
+
+	read := convertGCalEventToRemoteEvent(out)
+	require.Equal(t, conference, read.Conference, "the call is kept with a location")
+	require.Equal(t, "Room 1", read.Location.DisplayName)
+}
@@ -5,6 +5,7 @@ package gcal
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
 const (
@@ -26,6 +28,12 @@ const (
 	GoogleResponseStatusMaybe = "tentative"
 	GoogleResponseStatusNo    = "declined"
 	GoogleResponseStatusNone  = "needsAction"
+
+	GoogleMeetName            = "Google Meet"
+	googleMeetConferenceType  = "hangoutsMeet"
+	conferenceEntryPointVideo = "video"
+
+	googleReminderPopup = "popup"
 )
 
 var responseStatusConversion = map[string]string{
@@ -35,43 +43,148 @@ var responseStatusConversion = map[string]string{
 	GoogleResponseStatusNone:  remote.EventResponseStatusNotAnswered,
 }
 
+// CalendarEvent is an event together with the calendar it was read from
+type CalendarEvent struct {
+	*remote.Event
+	Calendar *Calendar
+
+	// ColorID is the event color chosen in Google Calendar, events without
+	// one have the color of their calendar
+	ColorID string
+
+	// RecurringEventID is the recurring event an instance belongs to
+	RecurringEventID string
+
+	// ReminderMinutes are the minutes before start the event reminds of
+	// itself, the event's own or else the defaults of its calendar.
+	// HasOwnReminders tells which.
+	ReminderMinutes []int
+	HasOwnReminders bool
+}
+
 func (c *client) GetDefaultCalendarView(_ string, start, end time.Time) ([]*remote.Event, error) {
-	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	calendars, err := c.SelectedCalendars()
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView, error creating service")
+		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView, error getting selected calendars")
 	}
 
-	req := service.Events.
-		List(defaultCalendarName).
-		EventTypes("default").
-		TimeMin(start.Format(time.RFC3339)).
-		TimeMax(end.Format(time.RFC3339)).
-		SingleEvents(true).
-		ShowDeleted(false).
-		ShowHiddenInvitations(false).
-		OrderBy("startTime").
-		Context(context.TODO())
-
-	result, err := req.Do()
+	// The base plugin's view has no way to tell of the calendars that failed,
+	// they are logged by GetCalendarEvents
+	calendarEvents, _, err := c.GetCalendarEvents(calendars, start, end)
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView, error performing request")
 	}
 
-	if len(result.Items) == 0 {
-		return []*remote.Event{}, nil
-	}
-
 	events := []*remote.Event{}
-	for _, event := range result.Items {
+	for _, event := range calendarEvents {
 		if event.ICalUID != "" {
-			events = append(events, convertGCalEventToRemoteEvent(event))
+			events = append(events, event.Event)
 		}
 	}
 
 	return events, nil
 }
 
+// GetCalendarEvents returns the events of all the given calendars between the
+// two dates, merged and sorted by start time. A calendar that cannot be read
+// is skipped and returned in failed, so that one revoked calendar does not
+// hide the rest; an error is only returned when none of the calendars could
+// be read. At most the configured maximum of events is read from each calendar.
+func (c *client) GetCalendarEvents(calendars []*Calendar, start, end time.Time) (events []*CalendarEvent, failed []*Calendar, err error) {
+	events, failed, _, err = c.getCalendarEvents(calendars, start, end)
+	return events, failed, err
+}
+
+// GetCalendarEventsPage is GetCalendarEvents for the event lists shown to
+// users, which must not have gaps. When a calendar has more events than the
+// configured maximum, the events starting from the last one read of it are
+// left out of every calendar, and next is their start, for the next page to
+// begin with. next is zero when the page holds all the events.
+func (c *client) GetCalendarEventsPage(calendars []*Calendar, start, end time.Time) (events []*CalendarEvent, failed []*Calendar, next time.Time, err error) {
+	events, failed, cutoff, err := c.getCalendarEvents(calendars, start, end)
+	if err != nil || cutoff.IsZero() {
+		return events, failed, time.Time{}, err
+	}
+
+	// A page whose events all start at once cannot be cut, the next page
+	// begins after them
+	if !cutoff.After(start) {
+		return events, failed, cutoff.Add(time.Second), nil
+	}
+
+	page := []*CalendarEvent{}
+	for _, event := range events {
+		if event.Start.Time().Before(cutoff) {
+			page = append(page, event)
+		}
+	}
+	return page, failed, cutoff, nil
+}
+
+// getCalendarEvents reads the events for GetCalendarEvents and returns the
+// earliest start of the last events read from the calendars that had more,
+// or zero when every event was read
+func (c *client) getCalendarEvents(calendars []*Calendar, start, end time.Time) ([]*CalendarEvent, []*Calendar, time.Time, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, nil, time.Time{}, errors.Wrap(err, "gcal GetCalendarEvents, error creating service")
+	}
+
+	events := []*CalendarEvent{}
+	failed := []*Calendar{}
+	var cutoff time.Time
+	var lastErr error
+	for _, cal := range calendars {
+		var last *CalendarEvent
+		truncated, err := eachCalendarEvent(ctx, service, cal, start, end, c.maxEventsPerCalendar, func(event *CalendarEvent) error {
+			events = append(events, event)
+			last = event
+			return nil
+		})
+		if err != nil {
+			lastErr = err
+			failed = append(failed, cal)
+			c.Logger.With(bot.LogContext{
+				"calendarID": cal.ID,
+				"err":        err.Error(),
+			}).Warnf("gcal: failed to list events of calendar.")
+			continue
+		}
+		if truncated {
+			c.Logger.With(bot.LogContext{
+				"calendarID": cal.ID,
+				"limit":      c.maxEventsPerCalendar,
+			}).Warnf("gcal: too many events in calendar, the rest are left out.")
+			if last != nil && (cutoff.IsZero() || last.Start.Time().Before(cutoff)) {
+				cutoff = last.Start.Time()
+			}
+		}
+	}
+
+	if len(failed) > 0 && len(failed) == len(calendars) {
+		return nil, nil, time.Time{}, errors.Wrap(lastErr, "gcal GetCalendarEvents, error listing events")
+	}
+
+	sortCalendarEvents(events)
+
+	return events, failed, cutoff, nil
+}
+
+// sortCalendarEvents sorts events by start time, keeping the per calendar
+// order of events starting at the same time
+func sortCalendarEvents(events []*CalendarEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Time().Before(events[j].Start.Time())
+	})
+}
+
 func convertGCalEventDateTimeToRemoteDateTime(dt *calendar.EventDateTime) *remote.DateTime {
+	// Cancelled events may come without times
+	if dt == nil {
+		return nil
+	}
+
 	// Handle all-day events
 	if len(dt.Date) > 0 {
 		t, _ := time.Parse("2006-01-02", dt.Date)
@@ -96,11 +209,20 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 
 	if event.ConferenceData != nil && len(event.ConferenceData.EntryPoints) > 0 {
 		conference = &remote.Conference{
-			URL: event.ConferenceData.EntryPoints[0].Uri,
+			URL: conferenceJoinURL(event.ConferenceData),
 		}
 		if event.ConferenceData.ConferenceSolution != nil {
 			conference.Application = event.ConferenceData.ConferenceSolution.Name
 		}
+	} else if event.HangoutLink != "" {
+		// The entry points of a Meet conference are missing while it is
+		// still being created
+		conference = &remote.Conference{
+			URL:         event.HangoutLink,
+			Application: GoogleMeetName,
+		}
+	} else if call := videoCallFromProperties(event); call != nil {
+		conference = call
 	} else if utils.IsURL(event.Location) {
 		conference = &remote.Conference{
 			URL: event.Location,
@@ -113,11 +235,14 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 		}
 	}
 
-	organizer := &remote.Attendee{
-		EmailAddress: &remote.EmailAddress{
-			Name:    event.Organizer.Email,
-			Address: event.Organizer.Email,
-		},
+	var organizer *remote.Attendee
+	if event.Organizer != nil {
+		organizer = &remote.Attendee{
+			EmailAddress: &remote.EmailAddress{
+				Name:    event.Organizer.Email,
+				Address: event.Organizer.Email,
+			},
+		}
 	}
 
 	responseStatus := &remote.EventResponseStatus{
@@ -150,7 +275,19 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 		}
 	}
 
-	isAllDay := len(event.Start.Date) > 0 // if Date field is present, it is all-day. as opposed to DateTime field
+	isAllDay := event.Start != nil && len(event.Start.Date) > 0 // if Date field is present, it is all-day. as opposed to DateTime field
+
+	// The earliest reminder of an event with its own reminders, which is
+	// the one furthest from its start. Events using the defaults of their
+	// calendar have none.
+	reminderMinutes := 0
+	if event.Reminders != nil && !event.Reminders.UseDefault {
+		for i, minutes := range popupReminderMinutes(event.Reminders.Overrides) {
+			if i == 0 || minutes > reminderMinutes {
+				reminderMinutes = minutes
+			}
+		}
+	}
 
 	return &remote.Event{
 		ID:                event.Id,
@@ -168,14 +305,38 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 		Organizer:         organizer,
 		Attendees:         attendees,
 		ResponseStatus:    responseStatus,
-		IsCancelled:       event.Status == "cancelled",
+		IsCancelled:       event.Status == googleEventStatusCancelled,
 		IsOrganizer:       isOrganizer,
 		ResponseRequested: responseRequested,
+
+		ReminderMinutesBeforeStart: reminderMinutes,
 		// 	Importance                 string
-		// 	ReminderMinutesBeforeStart int
 	}
 }
 
+// popupReminderMinutes returns the minutes of the reminders Google shows as
+// notifications, email reminders are left to Google
+func popupReminderMinutes(reminders []*calendar.EventReminder) []int {
+	minutes := []int{}
+	for _, reminder := range reminders {
+		if reminder.Method == googleReminderPopup {
+			minutes = append(minutes, int(reminder.Minutes))
+		}
+	}
+	return minutes
+}
+
+// conferenceJoinURL returns the video link of a conference, conferences
+// without one are joined through their first entry point, such as a phone
+func conferenceJoinURL(data *calendar.ConferenceData) string {
+	for _, entryPoint := range data.EntryPoints {
+		if entryPoint.EntryPointType == conferenceEntryPointVideo {
+			return entryPoint.Uri
+		}
+	}
+	return data.EntryPoints[0].Uri
+}
+
 func (c *client) DoBatchViewCalendarRequests(_ []*remote.ViewCalendarParams) ([]*remote.ViewCalendarResponse, error) {
 	return nil, remote.ErrNotImplemented
 }
@@ -118,6 +118,37 @@ func TestConvertGCalEventToRemoteEvent(t *testing.T) {
 				require.Empty(t, event.Location)
 			},
 		},
+		{
+			Name: "video entry point is used to join the conference",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.ConferenceData = &calendar.ConferenceData{
+					EntryPoints: []*calendar.EntryPoint{
+						{EntryPointType: "phone", Uri: "tel:+1-555-0100"},
+						{EntryPointType: "video", Uri: "https://meet.google.com/abc-defg-hij"},
+					},
+				}
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", event.Conference.URL)
+			},
+		},
+		{
+			Name: "meet link is used while the conference is created",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.HangoutLink = "https://meet.google.com/abc-defg-hij"
+				evt.ConferenceData = &calendar.ConferenceData{
+					CreateRequest: &calendar.CreateConferenceRequest{RequestId: "request"},
+				}
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", event.Conference.URL)
+				require.Equal(t, GoogleMeetName, event.Conference.Application)
+			},
+		},
 		{
 			Name: "location url used as conference if no conference data is present",
 			In: func() calendar.Event {
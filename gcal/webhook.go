@@ -15,7 +15,10 @@ type webhook struct {
 	Resource                       string `json:"resource,omitempty"`
 	SubscriptionExpirationDateTime string `json:"subscriptionExpirationDateTime,omitempty"`
 	SubscriptionID                 string `json:"subscriptionId"`
-	ResourceData                   struct {
+	// CalendarID is the calendar the notification is about, the primary
+	// calendar when empty
+	CalendarID   string `json:"calendarId,omitempty"`
+	ResourceData struct {
 		DataType string `json:"@odata.type"`
 	} `json:"resourceData"`
 }
@@ -27,24 +30,20 @@ const (
 )
 
 func (r *impl) HandleWebhook(w http.ResponseWriter, req *http.Request) []*remote.Notification {
-	resourceState := req.Header.Get("X-Goog-Resource-State")
-	if resourceState == resourceStateSync {
+	wh, isSync := parseWebhook(req)
+	if isSync {
 		w.WriteHeader(http.StatusAccepted)
 		return []*remote.Notification{}
 	}
 
-	notificationChannelID := req.Header.Get("X-Goog-Channel-Id")
-	resourceID := req.Header.Get("X-Goog-Resource-Id")
-	token := req.Header.Get("X-Goog-Channel-Token")
-
-	wh := &webhook{
-		SubscriptionID: notificationChannelID,
-		ClientState:    token,
-		Resource:       resourceID,
+	if !r.routeCalendarWatch(wh) {
+		// Google stops retrying once the channel is unknown
+		http.Error(w, "Unknown channel", http.StatusNotFound)
+		return []*remote.Notification{}
 	}
 
 	n := &remote.Notification{
-		SubscriptionID: notificationChannelID,
+		SubscriptionID: wh.SubscriptionID,
 		// ChangeType:     wh.ChangeType, // not needed
 		ClientState: wh.ClientState,
 		IsBare:      true,
@@ -57,3 +56,43 @@ func (r *impl) HandleWebhook(w http.ResponseWriter, req *http.Request) []*remote
 	notifications := []*remote.Notification{n}
 	return notifications
 }
+
+// parseWebhook reads the Google push notification headers. The sync message
+// only confirms that a new channel works and carries no change.
+func parseWebhook(req *http.Request) (wh *webhook, isSync bool) {
+	wh = &webhook{
+		SubscriptionID: req.Header.Get("X-Goog-Channel-Id"),
+		ClientState:    req.Header.Get("X-Goog-Channel-Token"),
+		Resource:       req.Header.Get("X-Goog-Resource-Id"),
+	}
+
+	return wh, req.Header.Get("X-Goog-Resource-State") == resourceStateSync
+}
+
+// routeCalendarWatch points the notifications of secondary calendar watch
+// channels to the subscription they report to, and to the calendar they
+// watch. It reports false for unknown or forged channels.
+func (r *impl) routeCalendarWatch(wh *webhook) bool {
+	mattermostUserID := mattermostUserIDFromCalendarWatchID(wh.SubscriptionID)
+	if mattermostUserID == "" {
+		return true
+	}
+	if r.store == nil {
+		return false
+	}
+
+	watches, err := r.store.LoadCalendarWatches(mattermostUserID)
+	if err != nil {
+		return false
+	}
+
+	watch := watches.FindWatch(wh.SubscriptionID)
+	if watch == nil || watch.Token != wh.ClientState || watch.ResourceID != wh.Resource {
+		return false
+	}
+
+	wh.SubscriptionID = watches.SubscriptionID
+	wh.ClientState = watches.ClientState
+	wh.CalendarID = watch.CalendarID
+	return true
+}
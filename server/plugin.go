@@ -8,9 +8,11 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
@@ -20,15 +22,27 @@ import (
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/config"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
 	baseplugin "github.com/mattermost/mattermost-plugin-mscalendar/calendar/plugin"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
 // Plugin wraps the base mscalendar plugin to add events API
 type Plugin struct {
 	*baseplugin.Plugin
 
-	envLock   sync.RWMutex
-	eventsAPI *gcal.EventsAPIHandler
-	env       engine.Env
+	envLock          sync.RWMutex
+	eventsAPI        *gcal.EventsAPIHandler
+	commands         *gcal.CommandHandler
+	channelCalendars *gcal.ChannelCalendars
+	calendarSharing  *gcal.CalendarSharing
+	calendarLists    *gcal.CalendarListWatcher
+	eventReminders   *gcal.EventReminders
+	quickAdd         *gcal.QuickAdd
+	env              engine.Env
+	store            gcal.Store
+	videoCall        *gcal.VideoCallConfig
+	botUserID        string
+	renewJob         *cluster.Job
+	reminderJob      *cluster.Job
 }
 
 // NewPlugin creates a new plugin instance
@@ -46,29 +60,142 @@ func (p *Plugin) OnActivate() error {
 		return err
 	}
 
+	// The base plugin ensured the bot on activation, this returns its ID
+	botUserID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    config.Provider.BotUsername,
+		DisplayName: config.Provider.BotDisplayName,
+	})
+	if err != nil {
+		return err
+	}
+
 	// Initialize events API handler
 	p.envLock.Lock()
-	p.eventsAPI = gcal.NewEventsAPIHandler(p.env)
+	p.botUserID = botUserID
+	p.initHandlers()
 	p.envLock.Unlock()
 
+	// Watch channels of linked calendars expire after a week, and group
+	// memberships of shared calendars change without any hook
+	p.renewJob, err = cluster.Schedule(p.API, "gcal_renew_channel_calendar_watches", cluster.MakeWaitForInterval(time.Hour), func() {
+		p.envLock.RLock()
+		channelCalendars := p.channelCalendars
+		calendarSharing := p.calendarSharing
+		p.envLock.RUnlock()
+
+		channelCalendars.RenewWatches()
+		calendarSharing.ResyncAll()
+	})
+	if err != nil {
+		return err
+	}
+
+	p.reminderJob, err = cluster.Schedule(p.API, "gcal_event_reminders", cluster.MakeWaitForInterval(gcal.ReminderInterval), func() {
+		p.envLock.RLock()
+		eventReminders := p.eventReminders
+		p.envLock.RUnlock()
+
+		eventReminders.SendDue(time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// OnDeactivate is called when the plugin is deactivated
+func (p *Plugin) OnDeactivate() error {
+	if p.renewJob != nil {
+		_ = p.renewJob.Close()
+	}
+	if p.reminderJob != nil {
+		_ = p.reminderJob.Close()
+	}
+
+	return p.Plugin.OnDeactivate()
+}
+
+// initHandlers creates the gcal handlers, the env lock must be held
+func (p *Plugin) initHandlers() {
+	p.channelCalendars = gcal.NewChannelCalendars(p.env, p.store, p.API, p.botUserID)
+	p.calendarSharing = gcal.NewCalendarSharing(p.env, p.store, p.API)
+	p.calendarLists = gcal.NewCalendarListWatcher(p.env, p.store, p.API)
+	p.eventReminders = gcal.NewEventReminders(p.env, p.store, p.API, p.channelCalendars)
+	p.eventsAPI = gcal.NewEventsAPIHandler(p.env, p.store, p.API, p.channelCalendars, p.videoCall)
+	p.quickAdd = gcal.NewQuickAdd(p.env, p.store, p.API, p.botUserID)
+	p.commands = gcal.NewCommandHandler(p.env, p.store, p.channelCalendars, p.calendarSharing, p.quickAdd)
+}
+
 // OnConfigurationChange is called when config changes
 func (p *Plugin) OnConfigurationChange() error {
-	err := p.Plugin.OnConfigurationChange()
+	// The base plugin creates the remote here, which is called before
+	// OnActivate, so the gcal store and settings have to be registered first
+	eventList, err := gcal.LoadEventListConfig(p.API)
+	if err != nil {
+		return err
+	}
+
+	p.envLock.Lock()
+	if p.store == nil {
+		p.store = gcal.NewStore(p.API)
+	}
+	remote.Makers[gcal.Kind] = gcal.NewRemoteMaker(p.store, eventList.MaxEventsPerCalendar)
+	p.envLock.Unlock()
+
+	err = p.Plugin.OnConfigurationChange()
+	if err != nil {
+		return err
+	}
+
+	// The base plugin only knows its own settings
+	videoCall, err := gcal.LoadVideoCallConfig(p.API)
 	if err != nil {
 		return err
 	}
 
 	// Update events API handler with new env
 	p.envLock.Lock()
-	p.eventsAPI = gcal.NewEventsAPIHandler(p.env)
+	p.videoCall = videoCall
+	p.initHandlers()
 	p.envLock.Unlock()
 
 	return nil
 }
 
+// ExecuteCommand handles the gcal specific commands and delegates the rest to the base plugin
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	p.envLock.RLock()
+	handler := p.commands
+	p.envLock.RUnlock()
+
+	if handler != nil && handler.Handles(args) {
+		return handler.Execute(args), nil
+	}
+
+	return p.Plugin.ExecuteCommand(c, args)
+}
+
+// UserHasJoinedChannel grants the new member access to the calendars shared with the channel
+func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	p.handleChannelMembershipChange(channelMember, true)
+}
+
+// UserHasLeftChannel removes the access of the former member to the calendars shared with the channel
+func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	p.handleChannelMembershipChange(channelMember, false)
+}
+
+func (p *Plugin) handleChannelMembershipChange(channelMember *model.ChannelMember, isMember bool) {
+	p.envLock.RLock()
+	calendarSharing := p.calendarSharing
+	p.envLock.RUnlock()
+
+	if calendarSharing != nil {
+		go calendarSharing.HandleChannelMembershipChange(channelMember.ChannelId, channelMember.UserId, isMember)
+	}
+}
+
 // handleOAuth2Connect intercepts the OAuth connect flow to add prompt=consent
 // This ensures Google always returns a refresh token, not just on first auth
 func (p *Plugin) handleOAuth2Connect(w http.ResponseWriter, r *http.Request) {
@@ -131,8 +258,28 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	if strings.HasPrefix(path, "/api/v1/events") {
 		p.envLock.RLock()
 		handler := p.eventsAPI
+		quickAdd := p.quickAdd
 		p.envLock.RUnlock()
 
+		// Matched before the single event routes, which take any path
+		// under /api/v1/events/
+		if quickAdd != nil {
+			switch path {
+			case gcal.PathQuickAddEvent:
+				w.Header().Set("Content-Type", "application/json")
+				quickAdd.HandleQuickAddEvent(w, r)
+				return
+			case gcal.PathQuickAddAction:
+				w.Header().Set("Content-Type", "application/json")
+				quickAdd.HandleAction(w, r)
+				return
+			case gcal.PathQuickAddDialog:
+				w.Header().Set("Content-Type", "application/json")
+				quickAdd.HandleEditDialog(w, r)
+				return
+			}
+		}
+
 		if handler != nil {
 			switch path {
 			case "/api/v1/events":
@@ -155,6 +302,66 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 				w.Header().Set("Content-Type", "application/json")
 				handler.HandleCreateEvent(w, r)
 				return
+			default:
+				if strings.HasPrefix(path, gcal.PathEventPrefix) {
+					w.Header().Set("Content-Type", "application/json")
+					handler.HandleEvent(w, r)
+					return
+				}
+			}
+		}
+	}
+
+	// Handle calendars API routes
+	if strings.HasPrefix(path, "/api/v1/calendars") {
+		p.envLock.RLock()
+		handler := p.eventsAPI
+		p.envLock.RUnlock()
+
+		if handler != nil {
+			switch path {
+			case "/api/v1/calendars":
+				w.Header().Set("Content-Type", "application/json")
+				handler.HandleGetCalendars(w, r)
+				return
+			case "/api/v1/calendars/selected":
+				w.Header().Set("Content-Type", "application/json")
+				handler.HandleSelectedCalendars(w, r)
+				return
+			case "/api/v1/calendars/default":
+				w.Header().Set("Content-Type", "application/json")
+				handler.HandleDefaultCalendar(w, r)
+				return
+			}
+		}
+
+		p.envLock.RLock()
+		commands := p.commands
+		channelCalendars := p.channelCalendars
+		calendarLists := p.calendarLists
+		p.envLock.RUnlock()
+
+		if commands != nil && path == gcal.PathDeleteCalendarAction {
+			w.Header().Set("Content-Type", "application/json")
+			commands.HandleDeleteCalendarAction(w, r)
+			return
+		}
+
+		// Google push notifications of calendars linked to channels
+		if channelCalendars != nil && path == gcal.PathChannelCalendarWebhook {
+			channelCalendars.HandleWebhook(w, r)
+			return
+		}
+
+		if calendarLists != nil {
+			switch path {
+			case gcal.PathCalendarListWebhook:
+				calendarLists.HandleWebhook(w, r)
+				return
+			case gcal.PathIncludeCalendarAction:
+				w.Header().Set("Content-Type", "application/json")
+				calendarLists.HandleIncludeCalendarAction(w, r)
+				return
 			}
 		}
 	}
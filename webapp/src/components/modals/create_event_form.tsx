@@ -10,7 +10,7 @@ import {getTheme} from 'mattermost-redux/selectors/entities/preferences';
 
 import ChannelSelector from '../channel_selector';
 
-import {CreateEventPayload} from '@/types/calendar_api_types';
+import {CreateEventPayload, RecurrencePayload} from '@/types/calendar_api_types';
 
 import {getModalStyles} from '@/utils/styles';
 
@@ -45,7 +45,7 @@ export default function CreateEventForm(props: Props) {
         description: '',
         channel_id: '',
         location: '',
-        add_mattermost_call: true, // Default to adding a call
+        add_video_call: false, // Off by default since a Mattermost call needs a channel, see docs/usage.md
     });
 
     const setFormValue = <Key extends keyof CreateEventPayload>(name: Key, value: CreateEventPayload[Key]) => {
@@ -270,6 +270,28 @@ const ActualForm = (props: ActualFormProps) => {
                 </div>
             </div>
 
+            {/* Repeat */}
+            <Setting
+                label='Repeat'
+                inputId='recurrence'
+            >
+                <select
+                    id='recurrence'
+                    className='form-control'
+                    value={formValues.recurrence?.frequency || ''}
+                    onChange={(e) => {
+                        const frequency = e.target.value as RecurrencePayload['frequency'];
+                        setFormValue('recurrence', frequency ? {frequency} : undefined);
+                    }}
+                >
+                    <option value=''>{'Does not repeat'}</option>
+                    <option value='daily'>{'Daily'}</option>
+                    <option value='weekly'>{'Weekly'}</option>
+                    <option value='monthly'>{'Monthly'}</option>
+                    <option value='yearly'>{'Yearly'}</option>
+                </select>
+            </Setting>
+
             {/* Guests */}
             <Setting
                 label='Guests'
@@ -306,7 +328,34 @@ const ActualForm = (props: ActualFormProps) => {
                 />
             </Setting>
 
-            {/* Mattermost Call checkbox */}
+            {/* Video call checkbox */}
+            <div
+                style={{
+                    display: 'flex',
+                    alignItems: 'center',
+                    gap: '8px',
+                    padding: '8px 10px',
+                    backgroundColor: 'var(--center-channel-color-04)',
+                    borderRadius: '4px',
+                    border: '1px solid var(--center-channel-color-08)',
+                }}
+            >
+                <input
+                    type='checkbox'
+                    id='add_video_call'
+                    checked={formValues.add_video_call || false}
+                    onChange={(e) => setFormValue('add_video_call', e.target.checked)}
+                    style={{width: '18px', height: '18px', cursor: 'pointer', flexShrink: 0}}
+                />
+                <label
+                    htmlFor='add_video_call'
+                    style={{cursor: 'pointer', fontSize: '14px', margin: 0}}
+                >
+                    {'📞 Add video call link'}
+                </label>
+            </div>
+
+            {/* Google Meet checkbox */}
             <div
                 style={{
                     display: 'flex',
@@ -320,16 +369,16 @@ const ActualForm = (props: ActualFormProps) => {
             >
                 <input
                     type='checkbox'
-                    id='add_mattermost_call'
-                    checked={formValues.add_mattermost_call || false}
-                    onChange={(e) => setFormValue('add_mattermost_call', e.target.checked)}
+                    id='add_google_meet'
+                    checked={formValues.add_google_meet || false}
+                    onChange={(e) => setFormValue('add_google_meet', e.target.checked)}
                     style={{width: '18px', height: '18px', cursor: 'pointer', flexShrink: 0}}
                 />
                 <label
-                    htmlFor='add_mattermost_call'
+                    htmlFor='add_google_meet'
                     style={{cursor: 'pointer', fontSize: '14px', margin: 0}}
                 >
-                    {'📞 Add Mattermost Call link'}
+                    {'🎥 Add Google Meet video conference'}
                 </label>
             </div>
         </div>
@@ -15,11 +15,26 @@ interface CalendarEvent {
     webLink?: string;
     organizer?: string;
     conference?: string;
+    calendarId?: string;
+    calendarName?: string;
+    calendarColor?: string;
+    colorId?: string;
+    backgroundColor?: string;
+    foregroundColor?: string;
+    recurringEventId?: string; // Set on the instances of recurring events
 }
 
 interface EventsResponse {
     events: CalendarEvent[];
     error?: string;
+    truncated?: boolean;
+    next_cursor?: string; // Set when there are more events, fetches the next ones
+    failed_calendars?: FailedCalendar[]; // Selected calendars whose events are missing
+}
+
+interface FailedCalendar {
+    id: string;
+    name: string;
 }
 
 type ViewType = 'today' | 'tomorrow' | 'week';
@@ -59,11 +74,15 @@ const CalendarRHS: React.FC = () => {
     const [error, setError] = useState<string | null>(null);
     const [view, setView] = useState<ViewType>('today');
     const [connected, setConnected] = useState<boolean | null>(null);
+    const [nextCursor, setNextCursor] = useState<string | null>(null);
+    const [loadingMore, setLoadingMore] = useState(false);
+    const [failedCalendars, setFailedCalendars] = useState<FailedCalendar[]>([]);
     const dispatch = useDispatch();
 
     const fetchEvents = useCallback(async (viewType: ViewType) => {
         setLoading(true);
         setError(null);
+        setFailedCalendars([]);
         try {
             const endpoint = viewType === 'week' ? 'week' : viewType;
             const data: EventsResponse = await doFetch(`/plugins/${PluginId}/api/v1/events/${endpoint}`, {method: 'GET'});
@@ -82,9 +101,12 @@ const CalendarRHS: React.FC = () => {
                     setError(data.error);
                 }
                 setEvents([]);
+                setNextCursor(null);
             } else {
                 setConnected(true);
                 setEvents(data.events || []);
+                setNextCursor(data.next_cursor || null);
+                setFailedCalendars(data.failed_calendars || []);
             }
         } catch (e: any) {
             // Check if error message indicates not connected
@@ -99,11 +121,29 @@ const CalendarRHS: React.FC = () => {
                 setError(e.message || 'Failed to fetch events');
             }
             setEvents([]);
+            setNextCursor(null);
         } finally {
             setLoading(false);
         }
     }, []);
 
+    const fetchMoreEvents = useCallback(async (viewType: ViewType, cursor: string) => {
+        setLoadingMore(true);
+        try {
+            const data: EventsResponse = await doFetch(`/plugins/${PluginId}/api/v1/events/${viewType}?cursor=${encodeURIComponent(cursor)}`, {method: 'GET'});
+            if (data.error) {
+                setError(data.error);
+                return;
+            }
+            setEvents((current) => current.concat(data.events || []));
+            setNextCursor(data.next_cursor || null);
+        } catch (e: any) {
+            setError(e.message || 'Failed to fetch events');
+        } finally {
+            setLoadingMore(false);
+        }
+    }, []);
+
     useEffect(() => {
         fetchEvents(view);
     }, [view, fetchEvents]);
@@ -258,7 +298,22 @@ const CalendarRHS: React.FC = () => {
                     </div>
                 )}
 
-                {!loading && connected && !error && events.length === 0 && (
+                {!loading && connected && failedCalendars.length > 0 && (
+                    <div
+                        style={{
+                            padding: '12px',
+                            backgroundColor: 'rgba(var(--away-indicator-rgb), 0.16)',
+                            borderRadius: '8px',
+                            color: 'var(--center-channel-color)',
+                            fontSize: '13px',
+                            marginBottom: '12px',
+                        }}
+                    >
+                        {`Events of ${failedCalendars.map((cal) => cal.name || cal.id).join(', ')} could not be loaded and are missing.`}
+                    </div>
+                )}
+
+                {!loading && connected && !error && events.length === 0 && failedCalendars.length === 0 && (
                     <div
                         style={{
                             textAlign: 'center',
@@ -291,6 +346,23 @@ const CalendarRHS: React.FC = () => {
                                 showDate={view === 'week'}
                             />
                         ))}
+                        {nextCursor && (
+                            <button
+                                onClick={() => fetchMoreEvents(view, nextCursor)}
+                                disabled={loadingMore}
+                                style={{
+                                    padding: '8px 12px',
+                                    border: '1px solid var(--center-channel-color-16)',
+                                    borderRadius: '4px',
+                                    backgroundColor: 'transparent',
+                                    cursor: loadingMore ? 'wait' : 'pointer',
+                                    fontSize: '13px',
+                                    color: 'var(--center-channel-color)',
+                                }}
+                            >
+                                {loadingMore ? 'Loading...' : 'Load more events'}
+                            </button>
+                        )}
                     </div>
                 )}
             </div>
@@ -348,6 +420,7 @@ const EventCard: React.FC<EventCardProps> = ({event, showDate}) => {
                 borderRadius: '8px',
                 backgroundColor: 'var(--center-channel-color-04)',
                 border: '1px solid var(--center-channel-color-08)',
+                borderLeft: event.backgroundColor ? `4px solid ${event.backgroundColor}` : '1px solid var(--center-channel-color-08)',
             }}
         >
             {/* Time */}
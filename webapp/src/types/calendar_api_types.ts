@@ -2,12 +2,100 @@ export type CreateEventPayload = {
     all_day: boolean;
     attendees: string[]; // list of Mattermost UserIDs or email addresses
     date: string;
+    end_date?: string; // YYYY-MM-DD, the last day of events spanning several days
+    timezone?: string; // IANA timezone of the date and times, defaults to the user's Google Calendar timezone
     start_time: string;
     end_time: string;
-    reminder?: number;
+    when?: string; // One expression such as "next Tue 2-3pm", replacing the date, end date, times and all day fields
+    reminders?: number[]; // Minutes before start, the calendar defaults are used when missing
     description?: string;
     subject: string;
     location?: string;
     channel_id?: string;
-    add_mattermost_call?: boolean; // If true, add Mattermost Calls link to the event
+    add_video_call?: boolean; // If true, add a call of the provider the admin configured
+    add_google_meet?: boolean; // If true, create a Google Meet conference for the event
+    calendar_id?: string; // Defaults to the user's default calendar
+    recurrence?: RecurrencePayload;
+}
+
+export type RecurrencePayload = {
+    frequency?: 'daily' | 'weekly' | 'monthly' | 'yearly';
+    interval?: number;
+    weekdays?: string[]; // monday or MO, defaults to the weekday of the date
+    monthly_by?: 'date' | 'day'; // Same date, or same weekday of the month
+    until?: string; // YYYY-MM-DD
+    count?: number;
+    rules?: string[]; // Raw RRULE, EXRULE, RDATE and EXDATE lines, the other fields need a frequency
+}
+
+export type Calendar = {
+    id: string;
+    name: string;
+    description?: string;
+    timeZone?: string;
+    colorId?: string;
+    backgroundColor?: string;
+    foregroundColor?: string;
+    accessRole: 'owner' | 'writer' | 'reader' | 'freeBusyReader';
+    primary: boolean;
+    hidden: boolean;
+    selected: boolean; // Included in the plugin's event views
+    default: boolean; // New events are created on it
+}
+
+export type CalendarsResponse = {
+    calendars: Calendar[];
+    error?: string;
+}
+
+export type EventAttendee = {
+    email: string;
+    name?: string;
+    responseStatus: 'needsAction' | 'declined' | 'tentative' | 'accepted';
+    comment?: string;
+    optional?: boolean;
+    organizer?: boolean;
+    resource?: boolean;
+    self?: boolean;
+}
+
+export type EventDetail = {
+    id: string;
+    subject: string;
+    start: string;
+    end: string;
+    location?: string;
+    isAllDay: boolean;
+    webLink?: string;
+    organizer?: string;
+    description?: string;
+    conference?: string;
+    calendarId?: string;
+    calendarName?: string;
+    colorId?: string;
+    backgroundColor?: string;
+    foregroundColor?: string;
+    status?: string;
+    etag?: string;
+    created?: string;
+    updated?: string;
+    recurrence?: string[];
+    recurringEventId?: string;
+    responseStatus?: EventAttendee['responseStatus'];
+    attendees: EventAttendee[];
+    conferenceName?: string;
+    entryPoints?: {type: string; uri: string; label?: string; pin?: string; passcode?: string}[];
+    attachments?: {title: string; fileUrl: string; mimeType?: string; iconLink?: string}[];
+}
+
+export type UpdateEventPayload = {
+    subject?: string;
+    location?: string;
+    description?: string;
+    start?: string; // RFC3339, or YYYY-MM-DD for all-day events
+    end?: string;
+    attendees?: string[];
+    etag?: string;
+    send_updates?: 'all' | 'externalOnly' | 'none';
+    scope?: 'instance' | 'following' | 'series'; // For instances of recurring events
 }